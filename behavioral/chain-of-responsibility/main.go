@@ -1,6 +1,23 @@
 package main
 
-import "fmt"
+import (
+	"bytes"
+	"cmp"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
 // TransactionRequest represents a transaction that needs approval
 type TransactionRequest struct {
@@ -12,10 +29,113 @@ type TransactionRequest struct {
 	Priority    string // "low", "medium", "high", "critical"
 }
 
-// Handler is the interface that all handlers in the chain must implement
+// Outcome is the final state of an approval decision
+type Outcome string
+
+const (
+	OutcomeApproved Outcome = "approved"
+	OutcomeRejected Outcome = "rejected"
+	OutcomePending  Outcome = "pending"
+)
+
+// ReasonCode explains why a handler reached its decision
+type ReasonCode string
+
+const (
+	ReasonLowAmount        ReasonCode = "LOW_AMOUNT"
+	ReasonMediumAmount     ReasonCode = "MEDIUM_AMOUNT"
+	ReasonManagerApproval  ReasonCode = "MANAGER_APPROVAL"
+	ReasonDirectorApproval ReasonCode = "DIRECTOR_APPROVAL"
+	ReasonWithinBand       ReasonCode = "WITHIN_BAND"
+	ReasonNoHandler        ReasonCode = "NO_HANDLER"
+	ReasonDepositAutoPass  ReasonCode = "DEPOSIT_AUTO_APPROVED"
+	ReasonQuorumPending    ReasonCode = "QUORUM_PENDING"
+	ReasonQuorumMet        ReasonCode = "QUORUM_MET"
+	ReasonVetoed           ReasonCode = "VETOED"
+	ReasonQuorumTimeout    ReasonCode = "QUORUM_TIMEOUT"
+	ReasonApproverSigned   ReasonCode = "APPROVER_SIGNED_OFF"
+	ReasonApproverDeclined ReasonCode = "APPROVER_DECLINED"
+	ReasonApproverError    ReasonCode = "APPROVER_ERROR"
+	ReasonCanceled         ReasonCode = "CANCELED"
+	ReasonDeadlineExceeded ReasonCode = "DEADLINE_EXCEEDED"
+	ReasonVelocityExceeded ReasonCode = "VELOCITY_EXCEEDED"
+	ReasonVelocityStoreErr ReasonCode = "VELOCITY_STORE_UNAVAILABLE"
+	ReasonDuplicateRequest ReasonCode = "DUPLICATE_REQUEST"
+)
+
+// Decision records who decided a TransactionRequest, how, why, and which handlers saw it
+type Decision struct {
+	RequestID string     `json:"request_id"`
+	Approver  string     `json:"approver,omitempty"` // handler that made the decision, empty if the request fell off the chain
+	Outcome   Outcome    `json:"outcome"`
+	Reason    ReasonCode `json:"reason"`
+	Visited   []string   `json:"visited"`             // handler names in the order the request reached them
+	Signoffs  []string   `json:"signoffs,omitempty"`  // named approvers who signed off on a quorum decision
+	VetoedBy  string     `json:"vetoed_by,omitempty"` // named approver who vetoed a quorum decision
+	Escalated []string   `json:"escalated,omitempty"` // handlers that timed out and escalated to the next one
+	Detail    string     `json:"detail,omitempty"`    // extra context for the reason, e.g. which rule was broken
+}
+
+func newDecision(request *TransactionRequest, approver string, outcome Outcome, reason ReasonCode) *Decision {
+	return &Decision{
+		RequestID: request.ID,
+		Approver:  approver,
+		Outcome:   outcome,
+		Reason:    reason,
+		Visited:   []string{approver},
+	}
+}
+
+// clone returns a copy that shares no slices with d
+func (d *Decision) clone() *Decision {
+	c := *d
+	c.Visited = slices.Clone(d.Visited)
+	c.Signoffs = slices.Clone(d.Signoffs)
+	c.Escalated = slices.Clone(d.Escalated)
+	return &c
+}
+
+func (d *Decision) Approved() bool {
+	return d.Outcome == OutcomeApproved
+}
+
+func (d *Decision) String() string {
+	approver := d.Approver
+	if approver == "" {
+		approver = "nobody"
+	}
+	summary := fmt.Sprintf("%s %s by %s (%s) via %s",
+		d.RequestID, d.Outcome, approver, d.Reason, strings.Join(d.Visited, " → "))
+	if len(d.Signoffs) > 0 {
+		summary += fmt.Sprintf(" [signed off: %s]", strings.Join(d.Signoffs, ", "))
+	}
+	if d.VetoedBy != "" {
+		summary += fmt.Sprintf(" [vetoed by: %s]", d.VetoedBy)
+	}
+	if len(d.Escalated) > 0 {
+		summary += fmt.Sprintf(" [timed out: %s]", strings.Join(d.Escalated, ", "))
+	}
+	if d.Detail != "" {
+		summary += fmt.Sprintf(" [%s]", d.Detail)
+	}
+	return summary
+}
+
+// contextDecision rejects a request because its context is done
+func contextDecision(ctx context.Context, request *TransactionRequest) *Decision {
+	reason := ReasonCanceled
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		reason = ReasonDeadlineExceeded
+	}
+	return &Decision{RequestID: request.ID, Outcome: OutcomeRejected, Reason: reason}
+}
+
+// Handler is the interface that all handlers in the chain must implement.
+// Handle is shorthand for HandleContext with context.Background().
 type Handler interface {
 	SetNext(handler Handler) Handler
-	Handle(request *TransactionRequest) bool
+	Handle(request *TransactionRequest) *Decision
+	HandleContext(ctx context.Context, request *TransactionRequest) *Decision
 }
 
 // BaseHandler provides common functionality for all handlers
@@ -28,15 +148,81 @@ func (h *BaseHandler) SetNext(handler Handler) Handler {
 	return handler
 }
 
-func (h *BaseHandler) HandleNext(request *TransactionRequest) bool {
-	if h.next != nil {
-		return h.next.Handle(request)
+type trailKey struct{}
+
+// withTrail records that a request passed through a handler on its way down the
+// chain. Decisions build their Visited list on the way back up; handlers that
+// keep a decision after returning, like QuorumHandler, read the trail from here.
+func withTrail(ctx context.Context, from string) context.Context {
+	trail := append(slices.Clip(trailFrom(ctx)), from)
+	return context.WithValue(ctx, trailKey{}, trail)
+}
+
+func trailFrom(ctx context.Context) []string {
+	trail, _ := ctx.Value(trailKey{}).([]string)
+	return trail
+}
+
+// Next returns the handler that receives requests this one passes on
+func (h *BaseHandler) Next() Handler {
+	return h.next
+}
+
+// HandleNext passes the request on and records the calling handler at the front
+// of the resulting decision's trail. If the context is done the request goes no
+// further, and if there is no next handler it is rejected with ReasonNoHandler.
+func (h *BaseHandler) HandleNext(ctx context.Context, from string, request *TransactionRequest) *Decision {
+	var decision *Decision
+	if ctx.Err() != nil {
+		decision = contextDecision(ctx, request)
+	} else if h.next != nil {
+		decision = h.next.HandleContext(withTrail(ctx, from), request)
+	} else {
+		decision = &Decision{RequestID: request.ID, Outcome: OutcomeRejected, Reason: ReasonNoHandler}
 	}
-	return false
+	decision.Visited = append([]string{from}, decision.Visited...)
+	return decision
 }
 
 // --- Concrete Handlers ---
 
+// Positions of the classic handlers' bands in the embedded approval-chain.json
+const (
+	bandLowAmount = iota
+	bandMediumAmount
+	bandManager
+	bandDirector
+)
+
+// classicBands are the classic handlers' amount bands, read from the embedded
+// approval-chain.json so the limits are defined in one place
+var classicBands = loadClassicBands(defaultChainConfig)
+
+// loadClassicBands panics unless the config is four contiguous bands for all
+// transaction types, starting at zero and ending unbounded
+func loadClassicBands(data []byte) [4]AmountBand {
+	config, err := ParseChainConfig(data)
+	if err != nil {
+		panic(fmt.Sprintf("classic bands: %v", err))
+	}
+	if len(config.Handlers) != 4 {
+		panic(fmt.Sprintf("classic bands: want 4 handlers, got %d", len(config.Handlers)))
+	}
+	var bands [4]AmountBand
+	previousMax := 0.0
+	for i, hc := range config.Handlers {
+		band := NewAmountBandHandler(hc).AmountBand()
+		if band.Min != previousMax || len(band.Types) != 0 {
+			panic(fmt.Sprintf("classic bands: handler %q does not continue from $%.2f for all types", hc.Name, previousMax))
+		}
+		bands[i], previousMax = band, band.Max
+	}
+	if !math.IsInf(previousMax, 1) {
+		panic("classic bands: the last handler must be unbounded")
+	}
+	return bands
+}
+
 // LowAmountHandler handles low-priority transactions
 type LowAmountHandler struct {
 	BaseHandler
@@ -47,16 +233,24 @@ func NewLowAmountHandler(name string) *LowAmountHandler {
 	return &LowAmountHandler{name: name}
 }
 
-func (h *LowAmountHandler) Handle(request *TransactionRequest) bool {
-	if request.Amount <= 1000.0 {
-		fmt.Printf("[%s] Handling transaction %s: $%.2f - %s\n", h.name, request.ID, request.Amount, request.Description)
-		fmt.Printf("  → Approved: Low amount transaction\n")
-		return true
-	}
+func (h *LowAmountHandler) Name() string {
+	return h.name
+}
+
+func (h *LowAmountHandler) AmountBand() AmountBand {
+	return classicBands[bandLowAmount]
+}
+
+func (h *LowAmountHandler) Handle(request *TransactionRequest) *Decision {
+	return h.HandleContext(context.Background(), request)
+}
 
-	fmt.Printf("[%s] Cannot handle transaction %s (amount: $%.2f), escalating...\n",
-		h.name, request.ID, request.Amount)
-	return h.HandleNext(request)
+func (h *LowAmountHandler) HandleContext(ctx context.Context, request *TransactionRequest) *Decision {
+	// Bands exclude their lower bound, so zero and negative amounts are never auto-approved
+	if band := h.AmountBand(); request.Amount > band.Min && request.Amount <= band.Max {
+		return newDecision(request, h.name, OutcomeApproved, ReasonLowAmount)
+	}
+	return h.HandleNext(ctx, h.name, request)
 }
 
 // MediumAmountHandler handles medium-priority transactions
@@ -69,16 +263,23 @@ func NewMediumAmountHandler(name string) *MediumAmountHandler {
 	return &MediumAmountHandler{name: name}
 }
 
-func (h *MediumAmountHandler) Handle(request *TransactionRequest) bool {
-	if request.Amount > 1000.0 && request.Amount <= 10000.0 {
-		fmt.Printf("[%s] Handling transaction %s: $%.2f - %s\n", h.name, request.ID, request.Amount, request.Description)
-		fmt.Printf("  → Approved: Medium amount transaction\n")
-		return true
-	}
+func (h *MediumAmountHandler) Name() string {
+	return h.name
+}
+
+func (h *MediumAmountHandler) AmountBand() AmountBand {
+	return classicBands[bandMediumAmount]
+}
+
+func (h *MediumAmountHandler) Handle(request *TransactionRequest) *Decision {
+	return h.HandleContext(context.Background(), request)
+}
 
-	fmt.Printf("[%s] Cannot handle transaction %s (amount: $%.2f), escalating...\n",
-		h.name, request.ID, request.Amount)
-	return h.HandleNext(request)
+func (h *MediumAmountHandler) HandleContext(ctx context.Context, request *TransactionRequest) *Decision {
+	if band := h.AmountBand(); request.Amount > band.Min && request.Amount <= band.Max {
+		return newDecision(request, h.name, OutcomeApproved, ReasonMediumAmount)
+	}
+	return h.HandleNext(ctx, h.name, request)
 }
 
 // ManagerHandler handles high-priority transactions
@@ -91,16 +292,23 @@ func NewManagerHandler(name string) *ManagerHandler {
 	return &ManagerHandler{name: name}
 }
 
-func (h *ManagerHandler) Handle(request *TransactionRequest) bool {
-	if request.Amount > 10000.0 && request.Amount <= 50000.0 {
-		fmt.Printf("[%s] Handling transaction %s: $%.2f - %s\n", h.name, request.ID, request.Amount, request.Description)
-		fmt.Printf("  → Approved: Manager approval required\n")
-		return true
-	}
+func (h *ManagerHandler) Name() string {
+	return h.name
+}
+
+func (h *ManagerHandler) AmountBand() AmountBand {
+	return classicBands[bandManager]
+}
+
+func (h *ManagerHandler) Handle(request *TransactionRequest) *Decision {
+	return h.HandleContext(context.Background(), request)
+}
 
-	fmt.Printf("[%s] Cannot handle transaction %s (amount: $%.2f), escalating...\n",
-		h.name, request.ID, request.Amount)
-	return h.HandleNext(request)
+func (h *ManagerHandler) HandleContext(ctx context.Context, request *TransactionRequest) *Decision {
+	if band := h.AmountBand(); request.Amount > band.Min && request.Amount <= band.Max {
+		return newDecision(request, h.name, OutcomeApproved, ReasonManagerApproval)
+	}
+	return h.HandleNext(ctx, h.name, request)
 }
 
 // DirectorHandler handles critical transactions
@@ -113,47 +321,1599 @@ func NewDirectorHandler(name string) *DirectorHandler {
 	return &DirectorHandler{name: name}
 }
 
-func (h *DirectorHandler) Handle(request *TransactionRequest) bool {
-	if request.Amount > 50000.0 {
-		fmt.Printf("[%s] Handling transaction %s: $%.2f - %s\n", h.name, request.ID, request.Amount, request.Description)
-		fmt.Printf("  → Approved: Director approval required\n")
+func (h *DirectorHandler) Name() string {
+	return h.name
+}
+
+// PassesOn is false because the director is always the end of the line
+func (h *DirectorHandler) PassesOn() bool {
+	return false
+}
+
+func (h *DirectorHandler) AmountBand() AmountBand {
+	return classicBands[bandDirector]
+}
+
+func (h *DirectorHandler) Handle(request *TransactionRequest) *Decision {
+	return h.HandleContext(context.Background(), request)
+}
+
+func (h *DirectorHandler) HandleContext(ctx context.Context, request *TransactionRequest) *Decision {
+	if request.Amount > h.AmountBand().Min {
+		return newDecision(request, h.name, OutcomeApproved, ReasonDirectorApproval)
+	}
+	return newDecision(request, h.name, OutcomeRejected, ReasonNoHandler)
+}
+
+// --- Config-driven Handlers ---
+
+// HandlerConfig describes one approval step loaded from a chain config file
+type HandlerConfig struct {
+	Name      string   `json:"name"`
+	MinAmount float64  `json:"min_amount"`           // exclusive lower bound
+	MaxAmount float64  `json:"max_amount,omitempty"` // inclusive upper bound, 0 means unbounded
+	Types     []string `json:"types,omitempty"`      // allowed transaction types, empty means all
+}
+
+// ChainConfig describes the whole approval chain in handler order
+type ChainConfig struct {
+	Handlers []HandlerConfig `json:"handlers"`
+}
+
+var validTransactionTypes = map[string]bool{"transfer": true, "withdrawal": true, "deposit": true}
+
+// LoadChainConfig reads a JSON chain config from disk
+func LoadChainConfig(path string) (*ChainConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read chain config: %w", err)
+	}
+	return ParseChainConfig(data)
+}
+
+// ParseChainConfig decodes and validates a JSON chain config. Unknown keys are
+// an error, so a misspelt "max_amount" can't silently leave a band unbounded.
+func ParseChainConfig(data []byte) (*ChainConfig, error) {
+	var config ChainConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("parse chain config: %w", err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("parse chain config: unexpected data after the config object")
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Validate checks that every handler has a name, a sane amount band and known types
+func (c *ChainConfig) Validate() error {
+	if len(c.Handlers) == 0 {
+		return fmt.Errorf("chain config: no handlers defined")
+	}
+	for i, hc := range c.Handlers {
+		if hc.Name == "" {
+			return fmt.Errorf("chain config: handler %d has no name", i)
+		}
+		if hc.MinAmount < 0 {
+			return fmt.Errorf("chain config: handler %q has negative min_amount", hc.Name)
+		}
+		if hc.MaxAmount != 0 && hc.MaxAmount <= hc.MinAmount {
+			return fmt.Errorf("chain config: handler %q has max_amount %.2f not above min_amount %.2f",
+				hc.Name, hc.MaxAmount, hc.MinAmount)
+		}
+		for _, t := range hc.Types {
+			if !validTransactionTypes[t] {
+				return fmt.Errorf("chain config: handler %q has unknown transaction type %q", hc.Name, t)
+			}
+		}
+	}
+	return nil
+}
+
+// BuildChain creates one AmountBandHandler per config entry, links them in order
+// and returns the head of the chain
+func (c *ChainConfig) BuildChain() Handler {
+	var head, tail Handler
+	for _, hc := range c.Handlers {
+		handler := NewAmountBandHandler(hc)
+		if head == nil {
+			head = handler
+		} else {
+			tail.SetNext(handler)
+		}
+		tail = handler
+	}
+	return head
+}
+
+// AmountBandHandler approves requests whose amount falls within a configured band
+// and whose type is allowed, and escalates everything else
+type AmountBandHandler struct {
+	BaseHandler
+	name      string
+	minAmount float64
+	maxAmount float64
+	types     map[string]bool
+}
+
+func NewAmountBandHandler(config HandlerConfig) *AmountBandHandler {
+	types := make(map[string]bool, len(config.Types))
+	for _, t := range config.Types {
+		types[t] = true
+	}
+	return &AmountBandHandler{
+		name:      config.Name,
+		minAmount: config.MinAmount,
+		maxAmount: config.MaxAmount,
+		types:     types,
+	}
+}
+
+func (h *AmountBandHandler) Name() string {
+	return h.name
+}
+
+func (h *AmountBandHandler) AmountBand() AmountBand {
+	band := AmountBand{Min: h.minAmount, Max: h.maxAmount, Types: slices.Sorted(maps.Keys(h.types))}
+	if band.Max == 0 {
+		band.Max = math.Inf(1)
+	}
+	return band
+}
+
+func (h *AmountBandHandler) accepts(request *TransactionRequest) bool {
+	if request.Amount <= h.minAmount {
+		return false
+	}
+	if h.maxAmount != 0 && request.Amount > h.maxAmount {
+		return false
+	}
+	return len(h.types) == 0 || h.types[request.Type]
+}
+
+func (h *AmountBandHandler) Handle(request *TransactionRequest) *Decision {
+	return h.HandleContext(context.Background(), request)
+}
+
+func (h *AmountBandHandler) HandleContext(ctx context.Context, request *TransactionRequest) *Decision {
+	if h.accepts(request) {
+		return newDecision(request, h.name, OutcomeApproved, ReasonWithinBand)
+	}
+	return h.HandleNext(ctx, h.name, request)
+}
+
+// defaultChainConfig is approval-chain.json as it was at build time, used when
+// the file is missing at run time so both paths build the same chain
+//
+//go:embed approval-chain.json
+var defaultChainConfig []byte
+
+// --- Routing Handlers ---
+
+// Predicate reports whether a request matches a routing rule
+type Predicate func(request *TransactionRequest) bool
+
+// TypeIs matches requests of any of the given transaction types
+func TypeIs(types ...string) Predicate {
+	return func(request *TransactionRequest) bool {
+		return slices.Contains(types, request.Type)
+	}
+}
+
+// PriorityIs matches requests with any of the given priorities
+func PriorityIs(priorities ...string) Predicate {
+	return func(request *TransactionRequest) bool {
+		return slices.Contains(priorities, request.Priority)
+	}
+}
+
+// AmountAbove matches requests strictly above the limit
+func AmountAbove(limit float64) Predicate {
+	return func(request *TransactionRequest) bool {
+		return request.Amount > limit
+	}
+}
+
+// AllOf matches when every predicate matches
+func AllOf(predicates ...Predicate) Predicate {
+	return func(request *TransactionRequest) bool {
+		for _, p := range predicates {
+			if !p(request) {
+				return false
+			}
+		}
 		return true
 	}
+}
 
-	fmt.Printf("[%s] No one can handle transaction %s\n", h.name, request.ID)
-	return false
+// AnyOf matches when at least one predicate matches
+func AnyOf(predicates ...Predicate) Predicate {
+	return func(request *TransactionRequest) bool {
+		for _, p := range predicates {
+			if p(request) {
+				return true
+			}
+		}
+		return false
+	}
 }
 
-func main() {
-	fmt.Println("=== Chain of Responsibility Pattern: JoshBank Transaction Approval ===")
+// Not inverts a predicate
+func Not(predicate Predicate) Predicate {
+	return func(request *TransactionRequest) bool {
+		return !predicate(request)
+	}
+}
 
-	// Build the chain
-	lowAmount := NewLowAmountHandler("Auto-Approval System")
-	mediumAmount := NewMediumAmountHandler("Supervisor")
-	manager := NewManagerHandler("Manager")
-	director := NewDirectorHandler("Director")
+// RouteHandler sends matching requests down a separate branch of handlers
+// (e.g. a fast lane or a human-only review chain) and passes the rest along
+type RouteHandler struct {
+	BaseHandler
+	name   string
+	match  Predicate
+	branch Handler
+}
 
-	lowAmount.SetNext(mediumAmount).SetNext(manager).SetNext(director)
+func NewRouteHandler(name string, match Predicate, branch Handler) *RouteHandler {
+	return &RouteHandler{name: name, match: match, branch: branch}
+}
 
-	// Create transactions with different amounts
-	transactions := []*TransactionRequest{
-		{ID: "TXN001", CustomerID: "CUST001", Amount: 500.0, Type: "transfer", Description: "Payment to merchant", Priority: "low"},
-		{ID: "TXN002", CustomerID: "CUST002", Amount: 5000.0, Type: "transfer", Description: "Bill payment", Priority: "medium"},
-		{ID: "TXN003", CustomerID: "CUST003", Amount: 25000.0, Type: "withdrawal", Description: "Large withdrawal", Priority: "high"},
-		{ID: "TXN004", CustomerID: "CUST004", Amount: 100000.0, Type: "transfer", Description: "Business transfer", Priority: "critical"},
+func (h *RouteHandler) Name() string {
+	return h.name
+}
+
+func (h *RouteHandler) Branches() []Handler {
+	return []Handler{h.branch}
+}
+
+func (h *RouteHandler) Handle(request *TransactionRequest) *Decision {
+	return h.HandleContext(context.Background(), request)
+}
+
+func (h *RouteHandler) HandleContext(ctx context.Context, request *TransactionRequest) *Decision {
+	if !h.match(request) {
+		return h.HandleNext(ctx, h.name, request)
 	}
+	decision := h.branch.HandleContext(withTrail(ctx, h.name), request)
+	decision.Visited = append([]string{h.name}, decision.Visited...)
+	return decision
+}
 
-	// Process each transaction through the chain
-	for _, txn := range transactions {
-		fmt.Printf("\n→ Processing transaction %s ($%.2f)\n", txn.ID, txn.Amount)
-		handled := lowAmount.Handle(txn)
-		if !handled {
-			fmt.Printf("  ✗ Transaction %s was not handled\n", txn.ID)
+// AutoApproveHandler approves matching requests without further review
+type AutoApproveHandler struct {
+	BaseHandler
+	name   string
+	match  Predicate
+	reason ReasonCode
+}
+
+func NewAutoApproveHandler(name string, match Predicate, reason ReasonCode) *AutoApproveHandler {
+	return &AutoApproveHandler{name: name, match: match, reason: reason}
+}
+
+func (h *AutoApproveHandler) Name() string {
+	return h.name
+}
+
+func (h *AutoApproveHandler) Handle(request *TransactionRequest) *Decision {
+	return h.HandleContext(context.Background(), request)
+}
+
+func (h *AutoApproveHandler) HandleContext(ctx context.Context, request *TransactionRequest) *Decision {
+	if h.match(request) {
+		return newDecision(request, h.name, OutcomeApproved, h.reason)
+	}
+	return h.HandleNext(ctx, h.name, request)
+}
+
+// --- Quorum (Dual-control) Handler ---
+
+// Clock abstracts time so quorum timeouts can be tested deterministically
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// ManualClock is a Clock that only moves when told to
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type pendingApproval struct {
+	decision  *Decision
+	parkedAt  time.Time
+	approvals map[string]bool
+}
+
+type resolvedApproval struct {
+	decision   *Decision
+	resolvedAt time.Time
+}
+
+// DefaultQuorumRetention is how long a resolved decision is kept unless changed
+const DefaultQuorumRetention = 24 * time.Hour
+
+// QuorumHandler parks matching requests as pending until enough named approvers
+// sign off. A single veto rejects the request, and so does running out of time.
+type QuorumHandler struct {
+	BaseHandler
+	name      string
+	match     Predicate
+	approvers map[string]bool
+	required  int
+	timeout   time.Duration
+	clock     Clock
+
+	mu        sync.Mutex
+	pending   map[string]*pendingApproval
+	resolved  map[string]*resolvedApproval
+	retention time.Duration
+}
+
+// NewQuorumHandler needs between one and all of the named approvers to sign off
+func NewQuorumHandler(name string, match Predicate, approvers []string, required int, timeout time.Duration, clock Clock) (*QuorumHandler, error) {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	allowed := make(map[string]bool, len(approvers))
+	for _, approver := range approvers {
+		allowed[approver] = true
+	}
+	if required < 1 || required > len(allowed) {
+		return nil, fmt.Errorf("quorum %s: required sign-offs must be between 1 and %d, got %d", name, len(allowed), required)
+	}
+	return &QuorumHandler{
+		name:      name,
+		match:     match,
+		approvers: allowed,
+		required:  required,
+		timeout:   timeout,
+		clock:     clock,
+		pending:   make(map[string]*pendingApproval),
+		resolved:  make(map[string]*resolvedApproval),
+		retention: DefaultQuorumRetention,
+	}, nil
+}
+
+func (h *QuorumHandler) Name() string {
+	return h.name
+}
+
+func (h *QuorumHandler) Handle(request *TransactionRequest) *Decision {
+	return h.HandleContext(context.Background(), request)
+}
+
+// HandleContext parks matching requests and returns a pending Decision. The
+// handler keeps its own copy, including the trail that led to it; Status,
+// Approve, Veto and ExpirePending return copies of it as it resolves.
+// A request whose ID is still pending, or was approved or vetoed and is still
+// retained, is rejected with ReasonDuplicateRequest. One that timed out may be
+// submitted again: nobody decided it.
+func (h *QuorumHandler) HandleContext(ctx context.Context, request *TransactionRequest) *Decision {
+	if !h.match(request) {
+		return h.HandleNext(ctx, h.name, request)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expireLocked()
+	h.evictLocked()
+	if _, ok := h.pending[request.ID]; ok {
+		decision := newDecision(request, h.name, OutcomeRejected, ReasonDuplicateRequest)
+		decision.Detail = "request " + request.ID + " is already awaiting quorum"
+		return decision
+	}
+	if resolved, ok := h.resolved[request.ID]; ok && resolved.decision.Reason != ReasonQuorumTimeout {
+		decision := newDecision(request, h.name, OutcomeRejected, ReasonDuplicateRequest)
+		decision.Detail = "request " + request.ID + " was already " + string(resolved.decision.Outcome)
+		return decision
+	}
+	delete(h.resolved, request.ID)
+	decision := newDecision(request, h.name, OutcomePending, ReasonQuorumPending)
+	parked := decision.clone()
+	parked.Visited = append(slices.Clone(trailFrom(ctx)), h.name)
+	h.pending[request.ID] = &pendingApproval{
+		decision:  parked,
+		parkedAt:  h.clock.Now(),
+		approvals: make(map[string]bool),
+	}
+	return decision
+}
+
+// Approve records an approver's sign-off and resolves the request once the quorum is met
+func (h *QuorumHandler) Approve(requestID, approver string) (*Decision, error) {
+	return h.vote(requestID, approver, true)
+}
+
+// Veto rejects the request on behalf of a named approver
+func (h *QuorumHandler) Veto(requestID, approver string) (*Decision, error) {
+	return h.vote(requestID, approver, false)
+}
+
+func (h *QuorumHandler) vote(requestID, approver string, approve bool) (*Decision, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.expireLocked()
+	h.evictLocked()
+	entry, ok := h.pending[requestID]
+	if !ok {
+		return nil, fmt.Errorf("quorum: no pending request %s", requestID)
+	}
+	if !h.approvers[approver] {
+		return nil, fmt.Errorf("quorum: %s is not an approver for %s", approver, h.name)
+	}
+
+	if !approve {
+		entry.decision.VetoedBy = approver
+		h.resolveLocked(requestID, entry, OutcomeRejected, ReasonVetoed)
+		return entry.decision.clone(), nil
+	}
+
+	entry.approvals[approver] = true
+	if len(entry.approvals) >= h.required {
+		h.resolveLocked(requestID, entry, OutcomeApproved, ReasonQuorumMet)
+	}
+	return entry.decision.clone(), nil
+}
+
+// Status returns a copy of the current decision for a request, expiring it first if it
+// has timed out. Resolved decisions stay available for the retention period, or
+// until ForgetResolved is called.
+func (h *QuorumHandler) Status(requestID string) (*Decision, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.evictLocked()
+	if entry, ok := h.pending[requestID]; ok {
+		if h.expiredLocked(entry) {
+			h.resolveLocked(requestID, entry, OutcomeRejected, ReasonQuorumTimeout)
+		}
+		return entry.decision.clone(), true
+	}
+	if resolved, ok := h.resolved[requestID]; ok {
+		return resolved.decision.clone(), true
+	}
+	return nil, false
+}
+
+// SetRetention changes how long resolved decisions are kept, measured from when they resolved
+func (h *QuorumHandler) SetRetention(retention time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.retention = retention
+}
+
+// ForgetResolved drops resolved decisions so Status no longer reports them and
+// their request IDs can be submitted again
+func (h *QuorumHandler) ForgetResolved() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clear(h.resolved)
+}
+
+// ExpirePending rejects every parked request whose timeout has passed and returns
+// their decisions. It also drops resolved decisions older than the retention period.
+func (h *QuorumHandler) ExpirePending() []*Decision {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	expired := h.expireLocked()
+	h.evictLocked()
+	return expired
+}
+
+func (h *QuorumHandler) expiredLocked(entry *pendingApproval) bool {
+	return h.timeout > 0 && !h.clock.Now().Before(entry.parkedAt.Add(h.timeout))
+}
+
+func (h *QuorumHandler) expireLocked() []*Decision {
+	var expired []*Decision
+	for id, entry := range h.pending {
+		if h.expiredLocked(entry) {
+			h.resolveLocked(id, entry, OutcomeRejected, ReasonQuorumTimeout)
+			expired = append(expired, entry.decision.clone())
+		}
+	}
+	return expired
+}
+
+func (h *QuorumHandler) resolveLocked(requestID string, entry *pendingApproval, outcome Outcome, reason ReasonCode) {
+	entry.decision.Outcome = outcome
+	entry.decision.Reason = reason
+	signoffs := make([]string, 0, len(entry.approvals))
+	for approver := range entry.approvals {
+		signoffs = append(signoffs, approver)
+	}
+	slices.Sort(signoffs)
+	entry.decision.Signoffs = signoffs
+	delete(h.pending, requestID)
+	h.resolved[requestID] = &resolvedApproval{decision: entry.decision, resolvedAt: h.clock.Now()}
+}
+
+// evictLocked drops resolved decisions that have outlived the retention period
+func (h *QuorumHandler) evictLocked() {
+	now := h.clock.Now()
+	for id, resolved := range h.resolved {
+		if now.Sub(resolved.resolvedAt) >= h.retention {
+			delete(h.resolved, id)
 		}
 	}
+}
+
+// --- Context-aware Approver Handler ---
+
+// Approver asks an external party, such as a person or a remote service, to approve a request
+type Approver func(ctx context.Context, request *TransactionRequest) (bool, error)
+
+// ApproverHandler waits for an Approver for at most its own timeout. When the step
+// times out the request escalates to the next handler instead of blocking the chain.
+type ApproverHandler struct {
+	BaseHandler
+	name     string
+	match    Predicate
+	approver Approver
+	timeout  time.Duration
+}
+
+func NewApproverHandler(name string, match Predicate, approver Approver, timeout time.Duration) *ApproverHandler {
+	return &ApproverHandler{name: name, match: match, approver: approver, timeout: timeout}
+}
+
+func (h *ApproverHandler) Name() string {
+	return h.name
+}
+
+func (h *ApproverHandler) Handle(request *TransactionRequest) *Decision {
+	return h.HandleContext(context.Background(), request)
+}
+
+func (h *ApproverHandler) HandleContext(ctx context.Context, request *TransactionRequest) *Decision {
+	if h.match != nil && !h.match(request) {
+		return h.HandleNext(ctx, h.name, request)
+	}
+
+	stepCtx := ctx
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		stepCtx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		approved bool
+		err      error
+	}
+	// Buffered so a slow approver that ignores its context can still finish and exit
+	done := make(chan result, 1)
+	go func() {
+		approved, err := h.approver(stepCtx, request)
+		done <- result{approved, err}
+	}()
+
+	var res result
+	select {
+	case res = <-done:
+	case <-stepCtx.Done():
+		res = result{err: stepCtx.Err()}
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		decision := contextDecision(ctx, request)
+		decision.Visited = []string{h.name}
+		return decision
+	case res.err != nil && stepCtx.Err() == context.DeadlineExceeded:
+		// Only the step's own deadline escalates; an approver whose internal call
+		// timed out returns a DeadlineExceeded of its own, which is an approver error
+		decision := h.HandleNext(ctx, h.name, request)
+		decision.Escalated = append([]string{h.name}, decision.Escalated...)
+		return decision
+	case res.err != nil:
+		return newDecision(request, h.name, OutcomeRejected, ReasonApproverError)
+	case res.approved:
+		return newDecision(request, h.name, OutcomeApproved, ReasonApproverSigned)
+	default:
+		return newDecision(request, h.name, OutcomeRejected, ReasonApproverDeclined)
+	}
+}
+
+// --- Velocity Handler ---
+
+// VelocityEvent is one approved transaction counted towards a customer's velocity
+type VelocityEvent struct {
+	CustomerID string    `json:"customer_id"`
+	Amount     float64   `json:"amount"`
+	At         time.Time `json:"at"`
+}
+
+// VelocityStore keeps per-customer transaction history for velocity checks
+type VelocityStore interface {
+	Record(event VelocityEvent) error
+	// Window returns the total amount and number of events for a customer at or after since
+	Window(customerID string, since time.Time) (total float64, count int, err error)
+}
+
+// MemoryVelocityStore keeps velocity events in memory
+type MemoryVelocityStore struct {
+	mu     sync.Mutex
+	events map[string][]VelocityEvent
+}
+
+func NewMemoryVelocityStore() *MemoryVelocityStore {
+	return &MemoryVelocityStore{events: make(map[string][]VelocityEvent)}
+}
+
+func (s *MemoryVelocityStore) Record(event VelocityEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[event.CustomerID] = append(s.events[event.CustomerID], event)
+	return nil
+}
+
+func (s *MemoryVelocityStore) Window(customerID string, since time.Time) (float64, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var total float64
+	var count int
+	for _, event := range s.events[customerID] {
+		if !event.At.Before(since) {
+			total += event.Amount
+			count++
+		}
+	}
+	return total, count, nil
+}
+
+// Prune drops events older than before so memory use stays bounded
+func (s *MemoryVelocityStore) Prune(before time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for customerID, events := range s.events {
+		kept := events[:0]
+		for _, event := range events {
+			if !event.At.Before(before) {
+				kept = append(kept, event)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.events, customerID)
+		} else {
+			s.events[customerID] = kept
+		}
+	}
+}
+
+// snapshot returns every event, grouped by customer in ID order
+func (s *MemoryVelocityStore) snapshot() []VelocityEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []VelocityEvent
+	for _, customerID := range slices.Sorted(maps.Keys(s.events)) {
+		all = append(all, s.events[customerID]...)
+	}
+	return all
+}
+
+// FileVelocityStore appends velocity events to a JSON-lines file and replays
+// them on open, so limits survive a restart
+type FileVelocityStore struct {
+	*MemoryVelocityStore
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// OpenFileVelocityStore replays the events in path. If a crash left the last
+// line partly written, that record is dropped and the file truncated to the last
+// complete one; a damaged line anywhere else is an error.
+func OpenFileVelocityStore(path string) (*FileVelocityStore, error) {
+	memory := NewMemoryVelocityStore()
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("open velocity store: %w", err)
+	}
+
+	complete := 0 // length of the prefix made of whole, newline-terminated records
+	for lineNo := 1; complete < len(data); lineNo++ {
+		end := bytes.IndexByte(data[complete:], '\n')
+		if end < 0 {
+			break // torn final record
+		}
+		line := data[complete : complete+end]
+		if len(bytes.TrimSpace(line)) > 0 {
+			var event VelocityEvent
+			if err := json.Unmarshal(line, &event); err != nil {
+				return nil, fmt.Errorf("velocity store %s line %d: %w", path, lineNo, err)
+			}
+			memory.Record(event)
+		}
+		complete += end + 1
+	}
+	if complete < len(data) {
+		if err := os.Truncate(path, int64(complete)); err != nil {
+			return nil, fmt.Errorf("truncate torn velocity record: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open velocity store: %w", err)
+	}
+	return &FileVelocityStore{MemoryVelocityStore: memory, path: path, file: file}, nil
+}
+
+func (s *FileVelocityStore) Record(event VelocityEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write velocity event: %w", err)
+	}
+	return s.MemoryVelocityStore.Record(event)
+}
+
+// Prune drops events older than before from memory and compacts the file to
+// match. The file is rewritten to a temporary file and renamed into place, so a
+// crash leaves either the old file or the new one. Later records go through the
+// temporary file's handle, which follows it through the rename; reopening the
+// path instead could fail and leave Record appending to the unlinked old file.
+func (s *FileVelocityStore) Prune(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.MemoryVelocityStore.Prune(before)
+
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("compact velocity store: %w", err)
+	}
+	writeErr := func() error {
+		for _, event := range s.MemoryVelocityStore.snapshot() {
+			line, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := tmp.Write(append(line, '\n')); err != nil {
+				return err
+			}
+		}
+		return tmp.Sync()
+	}()
+	if writeErr == nil {
+		writeErr = os.Rename(tmpPath, s.path)
+	}
+	if writeErr != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("compact velocity store: %w", writeErr)
+	}
+	s.file.Close()
+	s.file = tmp
+	return nil
+}
+
+func (s *FileVelocityStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// VelocityAction says what happens to a request that breaks a velocity rule
+type VelocityAction string
+
+const (
+	VelocityReject   VelocityAction = "reject"
+	VelocityEscalate VelocityAction = "escalate"
+)
+
+// VelocityRule caps the amount and/or number of a customer's transactions over a sliding window.
+// A zero MaxAmount or MaxCount means that dimension is not limited.
+type VelocityRule struct {
+	Name      string
+	Window    time.Duration
+	MaxAmount float64
+	MaxCount  int
+	Action    VelocityAction
+}
+
+// VelocityHandler checks each request against its customer's recent activity.
+// Requests that break a rule are rejected or sent to an escalation branch; the rest
+// continue down the chain, and approved ones are recorded in the store.
+type VelocityHandler struct {
+	BaseHandler
+	name       string
+	rules      []VelocityRule
+	store      VelocityStore
+	escalation Handler
+	clock      Clock
+
+	// mu guards the check and the record, but not the handlers in between.
+	// Requests still in the chain count towards their customer's limits, so
+	// concurrent requests from one customer can't both slip under a limit.
+	mu       sync.Mutex
+	inFlight map[string]velocityUsage
+}
+
+// velocityUsage is the amount and number of a customer's requests still in the chain
+type velocityUsage struct {
+	total float64
+	count int
+}
+
+func NewVelocityHandler(name string, rules []VelocityRule, store VelocityStore, escalation Handler, clock Clock) *VelocityHandler {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	return &VelocityHandler{
+		name:       name,
+		rules:      rules,
+		store:      store,
+		escalation: escalation,
+		clock:      clock,
+		inFlight:   make(map[string]velocityUsage),
+	}
+}
+
+func (h *VelocityHandler) Name() string {
+	return h.name
+}
+
+func (h *VelocityHandler) Branches() []Handler {
+	if h.escalation == nil {
+		return nil
+	}
+	return []Handler{h.escalation}
+}
+
+func (h *VelocityHandler) Handle(request *TransactionRequest) *Decision {
+	return h.HandleContext(context.Background(), request)
+}
+
+func (h *VelocityHandler) HandleContext(ctx context.Context, request *TransactionRequest) *Decision {
+	now := h.clock.Now()
+
+	h.mu.Lock()
+	broken, err := h.brokenRule(request, now)
+	escalate := broken != nil && broken.Action == VelocityEscalate && h.escalation != nil
+	if err == nil && (broken == nil || escalate) {
+		h.reserveLocked(request, 1)
+	}
+	h.mu.Unlock()
+
+	var decision *Decision
+	switch {
+	case err != nil:
+		decision = newDecision(request, h.name, OutcomeRejected, ReasonVelocityStoreErr)
+		decision.Detail = err.Error()
+		return decision
+	case broken == nil:
+		decision = h.passOn(request, now, func() *Decision {
+			return h.HandleNext(ctx, h.name, request)
+		})
+	case escalate:
+		decision = h.passOn(request, now, func() *Decision {
+			decision := h.escalation.HandleContext(withTrail(ctx, h.name), request)
+			decision.Visited = append([]string{h.name}, decision.Visited...)
+			decision.Detail = broken.Name
+			return decision
+		})
+	default:
+		decision = newDecision(request, h.name, OutcomeRejected, ReasonVelocityExceeded)
+		decision.Detail = broken.Name
+	}
+	return decision
+}
+
+// passOn runs a reserved request through the rest of the chain without holding
+// the lock, then swaps the reservation for a recorded event if it was approved
+func (h *VelocityHandler) passOn(request *TransactionRequest, now time.Time, next func() *Decision) (decision *Decision) {
+	defer func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.reserveLocked(request, -1)
+		if decision == nil || !decision.Approved() {
+			return
+		}
+		event := VelocityEvent{CustomerID: request.CustomerID, Amount: request.Amount, At: now}
+		if err := h.store.Record(event); err != nil {
+			decision.Detail = "velocity not recorded: " + err.Error()
+		}
+	}()
+	return next()
+}
+
+// reserveLocked adds (delta 1) or removes (delta -1) a request from its
+// customer's in-flight usage; customers with nothing in flight are dropped
+func (h *VelocityHandler) reserveLocked(request *TransactionRequest, delta int) {
+	usage := h.inFlight[request.CustomerID]
+	usage.total += float64(delta) * request.Amount
+	usage.count += delta
+	if usage.count <= 0 {
+		delete(h.inFlight, request.CustomerID)
+		return
+	}
+	h.inFlight[request.CustomerID] = usage
+}
+
+func (h *VelocityHandler) brokenRule(request *TransactionRequest, now time.Time) (*VelocityRule, error) {
+	for i := range h.rules {
+		rule := &h.rules[i]
+		total, count, err := h.store.Window(request.CustomerID, now.Add(-rule.Window))
+		if err != nil {
+			return nil, err
+		}
+		inFlight := h.inFlight[request.CustomerID]
+		total, count = total+inFlight.total, count+inFlight.count
+		if rule.MaxAmount > 0 && total+request.Amount > rule.MaxAmount {
+			return rule, nil
+		}
+		if rule.MaxCount > 0 && count+1 > rule.MaxCount {
+			return rule, nil
+		}
+	}
+	return nil, nil
+}
+
+// --- Chain Validation and Export ---
+
+// AmountBand is the range of amounts a handler approves on its own: Min is
+// exclusive, Max is inclusive and may be +Inf. Empty Types means every type.
+type AmountBand struct {
+	Min   float64
+	Max   float64
+	Types []string
+}
+
+func (b AmountBand) String() string {
+	upper := "∞"
+	if !math.IsInf(b.Max, 1) {
+		upper = "$" + strconv.FormatFloat(b.Max, 'f', -1, 64)
+	}
+	return fmt.Sprintf("($%s, %s]", strconv.FormatFloat(b.Min, 'f', -1, 64), upper)
+}
+
+func (b AmountBand) covers(transactionType string) bool {
+	return len(b.Types) == 0 || slices.Contains(b.Types, transactionType)
+}
+
+// The optional interfaces below let ValidateChain and the exporters look inside a chain
+type (
+	namedHandler     interface{ Name() string }
+	linkedHandler    interface{ Next() Handler }
+	branchingHandler interface{ Branches() []Handler }
+	bandedHandler    interface{ AmountBand() AmountBand }
+	terminalHandler  interface{ PassesOn() bool }
+)
+
+func handlerName(h Handler) string {
+	if named, ok := h.(namedHandler); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", h)
+}
+
+func nextHandler(h Handler) Handler {
+	if linked, ok := h.(linkedHandler); ok {
+		return linked.Next()
+	}
+	return nil
+}
+
+func handlerBranches(h Handler) []Handler {
+	if branching, ok := h.(branchingHandler); ok {
+		return branching.Branches()
+	}
+	return nil
+}
+
+// passesOn reports whether a handler can ever hand a request to its next handler
+func passesOn(h Handler) bool {
+	if terminal, ok := h.(terminalHandler); ok {
+		return terminal.PassesOn()
+	}
+	if banded, ok := h.(bandedHandler); ok {
+		band := banded.AmountBand()
+		return band.Min > 0 || !math.IsInf(band.Max, 1) || len(band.Types) > 0
+	}
+	return true
+}
+
+// ChainIssueKind classifies a problem found by ValidateChain
+type ChainIssueKind string
+
+const (
+	IssueCycle       ChainIssueKind = "cycle"
+	IssueUnreachable ChainIssueKind = "unreachable"
+	IssueGap         ChainIssueKind = "gap"
+	IssueOverlap     ChainIssueKind = "overlap"
+)
+
+type ChainIssue struct {
+	Kind    ChainIssueKind
+	Handler string
+	Message string
+}
+
+func (i ChainIssue) String() string {
+	return fmt.Sprintf("%s at %s: %s", i.Kind, i.Handler, i.Message)
+}
+
+// ChainReport lists the main path of a chain and every issue found along it
+type ChainReport struct {
+	Path   []string
+	Issues []ChainIssue
+}
+
+func (r *ChainReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// ValidateChain walks a chain without sending any requests through it. It reports
+// cycles (including ones through routing branches), handlers that can never be
+// reached because an earlier one never passes requests on, and amount ranges per
+// transaction type that no handler, or more than one handler, approves.
+func ValidateChain(head Handler) *ChainReport {
+	report := &ChainReport{}
+
+	var path []Handler
+	seen := make(map[Handler]bool)
+	for h := head; h != nil; h = nextHandler(h) {
+		if seen[h] {
+			report.Issues = append(report.Issues, ChainIssue{IssueCycle, handlerName(h),
+				"next handler loops back to an earlier handler"})
+			break
+		}
+		seen[h] = true
+		path = append(path, h)
+		report.Path = append(report.Path, handlerName(h))
+	}
+
+	report.Issues = append(report.Issues, findBranchCycles(head)...)
+
+	for i, h := range path {
+		if !passesOn(h) && i < len(path)-1 {
+			for _, unreachable := range path[i+1:] {
+				report.Issues = append(report.Issues, ChainIssue{IssueUnreachable, handlerName(unreachable),
+					fmt.Sprintf("%s never passes requests on", handlerName(h))})
+			}
+			path = path[:i+1]
+			break
+		}
+	}
+
+	for _, transactionType := range slices.Sorted(maps.Keys(validTransactionTypes)) {
+		report.Issues = append(report.Issues, coverageIssues(path, transactionType)...)
+	}
+	return report
+}
+
+// findBranchCycles runs a depth-first search over next and branch edges and
+// reports any cycle that the main-path walk cannot see
+func findBranchCycles(head Handler) []ChainIssue {
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+	var issues []ChainIssue
+	state := make(map[Handler]int)
+	var visit func(h Handler, viaBranch bool)
+	visit = func(h Handler, viaBranch bool) {
+		switch state[h] {
+		case inProgress:
+			if viaBranch {
+				issues = append(issues, ChainIssue{IssueCycle, handlerName(h),
+					"a routing branch leads back to this handler"})
+			}
+			return
+		case done:
+			return
+		}
+		state[h] = inProgress
+		if next := nextHandler(h); next != nil {
+			visit(next, viaBranch)
+		}
+		for _, branch := range handlerBranches(h) {
+			if branch != nil {
+				visit(branch, true)
+			}
+		}
+		state[h] = done
+	}
+	if head != nil {
+		visit(head, false)
+	}
+	return issues
+}
+
+func coverageIssues(path []Handler, transactionType string) []ChainIssue {
+	type bandOwner struct {
+		band AmountBand
+		name string
+	}
+	var bands []bandOwner
+	for _, h := range path {
+		if banded, ok := h.(bandedHandler); ok && banded.AmountBand().covers(transactionType) {
+			bands = append(bands, bandOwner{banded.AmountBand(), handlerName(h)})
+		}
+	}
+	slices.SortStableFunc(bands, func(a, b bandOwner) int {
+		return cmp.Compare(a.band.Min, b.band.Min)
+	})
+
+	var issues []ChainIssue
+	covered, coveredBy := 0.0, "start of chain"
+	for _, owner := range bands {
+		if owner.band.Min > covered {
+			issues = append(issues, ChainIssue{IssueGap, owner.name, fmt.Sprintf("no handler approves %s amounts in %s",
+				transactionType, AmountBand{Min: covered, Max: owner.band.Min})})
+		} else if owner.band.Min < covered {
+			issues = append(issues, ChainIssue{IssueOverlap, owner.name, fmt.Sprintf("overlaps %s for %s amounts in %s",
+				coveredBy, transactionType, AmountBand{Min: owner.band.Min, Max: math.Min(covered, owner.band.Max)})})
+		}
+		if owner.band.Max > covered {
+			covered, coveredBy = owner.band.Max, owner.name
+		}
+	}
+	if !math.IsInf(covered, 1) {
+		issues = append(issues, ChainIssue{IssueGap, coveredBy, fmt.Sprintf("no handler approves %s amounts in %s",
+			transactionType, AmountBand{Min: covered, Max: math.Inf(1)})})
+	}
+	return issues
+}
+
+type chainEdge struct {
+	from, to int
+	branch   bool
+}
+
+// chainGraph numbers every handler reachable from head in breadth-first order and
+// collects next and branch edges. Visited handlers are tracked so exporting a chain
+// with a cycle still terminates.
+func chainGraph(head Handler) ([]Handler, []chainEdge) {
+	if head == nil {
+		return nil, nil
+	}
+	nodes := []Handler{head}
+	ids := map[Handler]int{head: 0}
+	var edges []chainEdge
+	idOf := func(h Handler) int {
+		if id, ok := ids[h]; ok {
+			return id
+		}
+		ids[h] = len(nodes)
+		nodes = append(nodes, h)
+		return ids[h]
+	}
+	for id := 0; id < len(nodes); id++ {
+		h := nodes[id]
+		if next := nextHandler(h); next != nil {
+			edges = append(edges, chainEdge{from: id, to: idOf(next)})
+		}
+		for _, branch := range handlerBranches(h) {
+			if branch != nil {
+				edges = append(edges, chainEdge{from: id, to: idOf(branch), branch: true})
+			}
+		}
+	}
+	return nodes, edges
+}
+
+func nodeLabel(h Handler) string {
+	label := handlerName(h)
+	if banded, ok := h.(bandedHandler); ok {
+		band := banded.AmountBand()
+		label += " " + band.String()
+		if len(band.Types) > 0 {
+			label += " " + strings.Join(band.Types, "/")
+		}
+	}
+	return label
+}
+
+// ExportDOT renders the chain as a Graphviz digraph; routing branches are dashed
+func ExportDOT(head Handler) string {
+	nodes, edges := chainGraph(head)
+	var b strings.Builder
+	b.WriteString("digraph ApprovalChain {\n  rankdir=LR;\n  node [shape=box];\n")
+	for id, h := range nodes {
+		fmt.Fprintf(&b, "  n%d [label=%q];\n", id, nodeLabel(h))
+	}
+	for _, e := range edges {
+		if e.branch {
+			fmt.Fprintf(&b, "  n%d -> n%d [style=dashed, label=\"match\"];\n", e.from, e.to)
+		} else {
+			fmt.Fprintf(&b, "  n%d -> n%d;\n", e.from, e.to)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// ExportMermaid renders the chain as a Mermaid flowchart; routing branches are dotted
+func ExportMermaid(head Handler) string {
+	nodes, edges := chainGraph(head)
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for id, h := range nodes {
+		fmt.Fprintf(&b, "    n%d[\"%s\"]\n", id, strings.ReplaceAll(nodeLabel(h), `"`, "#quot;"))
+	}
+	for _, e := range edges {
+		if e.branch {
+			fmt.Fprintf(&b, "    n%d -.->|match| n%d\n", e.from, e.to)
+		} else {
+			fmt.Fprintf(&b, "    n%d --> n%d\n", e.from, e.to)
+		}
+	}
+	return b.String()
+}
+
+// --- Batch Processing ---
+
+// BatchResult holds one decision per request, in input order, plus summary counts
+type BatchResult struct {
+	Decisions  []*Decision
+	Outcomes   map[Outcome]int
+	ByApprover map[string]int // decisions per approver; requests nobody decided are counted under "unhandled"
+	Elapsed    time.Duration
+}
+
+func newBatchResult(decisions []*Decision, elapsed time.Duration) *BatchResult {
+	result := &BatchResult{
+		Decisions:  decisions,
+		Outcomes:   make(map[Outcome]int),
+		ByApprover: make(map[string]int),
+		Elapsed:    elapsed,
+	}
+	for _, decision := range decisions {
+		result.Outcomes[decision.Outcome]++
+		approver := decision.Approver
+		if approver == "" {
+			approver = "unhandled"
+		}
+		result.ByApprover[approver]++
+	}
+	return result
+}
+
+// ProcessSequential runs requests through the chain one by one
+func ProcessSequential(ctx context.Context, chain Handler, requests []*TransactionRequest) *BatchResult {
+	start := time.Now()
+	decisions := make([]*Decision, len(requests))
+	for i, request := range requests {
+		if ctx.Err() != nil {
+			decisions[i] = contextDecision(ctx, request)
+			continue
+		}
+		decisions[i] = chain.HandleContext(ctx, request)
+	}
+	return newBatchResult(decisions, time.Since(start))
+}
+
+// ProcessBatch runs requests through a shared chain with a bounded pool of workers.
+// The chain must be safe for concurrent use: the built-in handlers either hold no
+// mutable state or guard it with a mutex. Decisions come back in input order.
+func ProcessBatch(ctx context.Context, chain Handler, requests []*TransactionRequest, workers int) *BatchResult {
+	if workers < 1 {
+		workers = 1
+	}
+	start := time.Now()
+	decisions := make([]*Decision, len(requests))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					decisions[i] = contextDecision(ctx, requests[i])
+					continue
+				}
+				decisions[i] = chain.HandleContext(ctx, requests[i])
+			}
+		}()
+	}
+	for i := range requests {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return newBatchResult(decisions, time.Since(start))
+}
+
+func printDecision(decision *Decision) {
+	mark := "✓"
+	switch decision.Outcome {
+	case OutcomeRejected:
+		mark = "✗"
+	case OutcomePending:
+		mark = "…"
+	}
+	fmt.Printf("  %s %s\n", mark, decision)
+}
+
+func main() {
+	fmt.Println("=== Chain of Responsibility Pattern: JoshBank Transaction Approval ===")
+
+	// Build the chain from the config file so limits can change without a recompile
+	config, err := LoadChainConfig("approval-chain.json")
+	if err != nil {
+		fmt.Printf("  %v, falling back to built-in defaults\n", err)
+		config, err = ParseChainConfig(defaultChainConfig)
+		if err != nil {
+			fmt.Printf("  ✗ %v\n", err)
+			return
+		}
+	}
+	configuredChain := config.BuildChain()
+
+	// Create transactions with different amounts
+	transactions := []*TransactionRequest{
+		{ID: "TXN001", CustomerID: "CUST001", Amount: 500.0, Type: "transfer", Description: "Payment to merchant", Priority: "low"},
+		{ID: "TXN002", CustomerID: "CUST002", Amount: 5000.0, Type: "transfer", Description: "Bill payment", Priority: "medium"},
+		{ID: "TXN003", CustomerID: "CUST003", Amount: 25000.0, Type: "withdrawal", Description: "Large withdrawal", Priority: "high"},
+		{ID: "TXN004", CustomerID: "CUST004", Amount: 100000.0, Type: "transfer", Description: "Business transfer", Priority: "critical"},
+	}
+
+	// Process each transaction through the chain
+	for _, txn := range transactions {
+		fmt.Printf("\n→ Processing transaction %s ($%.2f, %s)\n", txn.ID, txn.Amount, txn.Type)
+		printDecision(configuredChain.Handle(txn))
+	}
+
+	// The classic handler types name each step's reason; their bands come from
+	// the embedded approval-chain.json, so they agree with the default config
+	fmt.Println("\n--- Classic Handler Types ---")
+	lowAmount := NewLowAmountHandler("Auto-Approval System")
+	lowAmount.SetNext(NewMediumAmountHandler("Supervisor")).
+		SetNext(NewManagerHandler("Manager")).
+		SetNext(NewDirectorHandler("Director"))
+	for _, txn := range transactions {
+		fmt.Printf("\n→ Processing transaction %s ($%.2f)\n", txn.ID, txn.Amount)
+		printDecision(lowAmount.Handle(txn))
+	}
+
+	// Route on type and priority in front of the amount-based chain
+	fmt.Println("\n--- Type- and Priority-aware Routing ---")
+	fastLane := NewAmountBandHandler(HandlerConfig{Name: "Duty Manager", MaxAmount: 50000})
+	fastLane.SetNext(NewAmountBandHandler(HandlerConfig{Name: "Director", MinAmount: 50000}))
+
+	humanReview := NewAmountBandHandler(HandlerConfig{Name: "Branch Supervisor", MaxAmount: 10000})
+	humanReview.SetNext(NewAmountBandHandler(HandlerConfig{Name: "Manager", MinAmount: 10000}))
+
+	deposits := NewAutoApproveHandler("Deposit Gate", TypeIs("deposit"), ReasonDepositAutoPass)
+	deposits.
+		SetNext(NewRouteHandler("Fast Lane Router", PriorityIs("critical"), fastLane)).
+		SetNext(NewRouteHandler("Withdrawal Review Router", AllOf(TypeIs("withdrawal"), AmountAbove(500)), humanReview)).
+		SetNext(configuredChain)
+
+	routedTransactions := []*TransactionRequest{
+		{ID: "TXN005", CustomerID: "CUST005", Amount: 250000.0, Type: "deposit", Description: "Property sale proceeds", Priority: "medium"},
+		{ID: "TXN006", CustomerID: "CUST006", Amount: 20000.0, Type: "transfer", Description: "Payroll run", Priority: "critical"},
+		{ID: "TXN007", CustomerID: "CUST007", Amount: 800.0, Type: "withdrawal", Description: "Cash withdrawal", Priority: "low"},
+		{ID: "TXN008", CustomerID: "CUST008", Amount: 300.0, Type: "transfer", Description: "Coffee subscription", Priority: "low"},
+	}
+	for _, txn := range routedTransactions {
+		fmt.Printf("\n→ Processing transaction %s ($%.2f, %s, %s)\n", txn.ID, txn.Amount, txn.Type, txn.Priority)
+		printDecision(deposits.Handle(txn))
+	}
+
+	// Large transfers need two of three approvers before they go through
+	fmt.Println("\n--- Dual-control Quorum ---")
+	clock := NewManualClock(time.Date(2025, 3, 31, 9, 0, 0, 0, time.UTC))
+	dualControl, err := NewQuorumHandler("Dual Control Desk",
+		AllOf(TypeIs("transfer"), AmountAbove(75000)),
+		[]string{"alice", "bob", "carol"}, 2, 4*time.Hour, clock)
+	if err != nil {
+		fmt.Printf("  ✗ %v\n", err)
+		return
+	}
+	dualControl.SetNext(configuredChain)
+
+	quorumTransactions := []*TransactionRequest{
+		{ID: "TXN009", CustomerID: "CUST009", Amount: 90000.0, Type: "transfer", Description: "Supplier prepayment", Priority: "high"},
+		{ID: "TXN010", CustomerID: "CUST010", Amount: 120000.0, Type: "transfer", Description: "Treasury sweep", Priority: "high"},
+		{ID: "TXN011", CustomerID: "CUST011", Amount: 80000.0, Type: "transfer", Description: "Unusual beneficiary", Priority: "medium"},
+	}
+	for _, txn := range quorumTransactions {
+		printDecision(dualControl.Handle(txn))
+	}
+	// Submitting the same request again must not replace the parked one
+	printDecision(dualControl.Handle(quorumTransactions[0]))
+
+	dualControl.Approve("TXN009", "alice")
+	decision, _ := dualControl.Approve("TXN009", "bob")
+	printDecision(decision)
+
+	decision, _ = dualControl.Veto("TXN011", "carol")
+	printDecision(decision)
+
+	clock.Advance(5 * time.Hour)
+	for _, expired := range dualControl.ExpirePending() {
+		printDecision(expired)
+	}
+	if _, err := dualControl.Approve("TXN010", "alice"); err != nil {
+		fmt.Printf("  ✗ %v\n", err)
+	}
+	if status, ok := dualControl.Status("TXN009"); ok {
+		fmt.Printf("  Status of TXN009: %s, signed off by %v\n", status.Outcome, status.Signoffs)
+	}
+
+	// A slow approver must not block the chain: each step gets its own deadline
+	fmt.Println("\n--- Context-aware Chain with Step Deadlines ---")
+	slowRiskDesk := func(ctx context.Context, request *TransactionRequest) (bool, error) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+			return true, nil
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	dutyManager := func(ctx context.Context, request *TransactionRequest) (bool, error) {
+		return request.Amount <= 50000, nil
+	}
+
+	riskDesk := NewApproverHandler("Remote Risk Desk", nil, slowRiskDesk, 50*time.Millisecond)
+	riskDesk.SetNext(NewApproverHandler("Duty Manager", nil, dutyManager, 50*time.Millisecond))
+
+	incident := &TransactionRequest{ID: "TXN012", CustomerID: "CUST012", Amount: 15000.0, Type: "transfer", Description: "Vendor payment during incident", Priority: "high"}
+	printDecision(riskDesk.HandleContext(context.Background(), incident))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	printDecision(riskDesk.HandleContext(ctx, incident))
+	cancel()
+
+	// Velocity limits per customer, persisted so they survive a restart
+	fmt.Println("\n--- Velocity and Daily Limits ---")
+	storePath := filepath.Join(os.TempDir(), "joshbank-velocity.jsonl")
+	os.Remove(storePath)
+	defer os.Remove(storePath)
+
+	velocityClock := NewManualClock(time.Date(2025, 3, 31, 9, 0, 0, 0, time.UTC))
+	velocityRules := []VelocityRule{
+		{Name: "hourly-count", Window: time.Hour, MaxCount: 3, Action: VelocityReject},
+		{Name: "daily-amount", Window: 24 * time.Hour, MaxAmount: 5000, Action: VelocityEscalate},
+	}
+	fraudDesk := NewAmountBandHandler(HandlerConfig{Name: "Fraud Desk", MaxAmount: 20000})
+
+	store, err := OpenFileVelocityStore(storePath)
+	if err != nil {
+		fmt.Printf("  ✗ %v\n", err)
+		return
+	}
+	velocity := NewVelocityHandler("Velocity Check", velocityRules, store, fraudDesk, velocityClock)
+	velocity.SetNext(configuredChain)
+	for i, amount := range []float64{900, 800, 950, 700} {
+		txn := &TransactionRequest{ID: fmt.Sprintf("TXN1%02d", i), CustomerID: "CUST100", Amount: amount, Type: "transfer", Description: "Card top-up", Priority: "low"}
+		printDecision(velocity.Handle(txn))
+		velocityClock.Advance(10 * time.Minute)
+	}
+	store.Close()
+
+	// Simulate a restart: the reopened store still knows today's activity
+	velocityClock.Advance(2 * time.Hour)
+	store, err = OpenFileVelocityStore(storePath)
+	if err != nil {
+		fmt.Printf("  ✗ %v\n", err)
+		return
+	}
+	velocity = NewVelocityHandler("Velocity Check", velocityRules, store, fraudDesk, velocityClock)
+	velocity.SetNext(configuredChain)
+	printDecision(velocity.Handle(&TransactionRequest{ID: "TXN110", CustomerID: "CUST100", Amount: 3000, Type: "transfer", Description: "Rent", Priority: "medium"}))
+	store.Close()
+
+	// Check chains before they go live and export them for the runbooks
+	fmt.Println("\n--- Chain Validation and Export ---")
+	printReport := func(name string, report *ChainReport) {
+		fmt.Printf("%s: %s\n", name, strings.Join(report.Path, " → "))
+		if report.OK() {
+			fmt.Println("  ✓ no issues")
+		}
+		for _, issue := range report.Issues {
+			fmt.Printf("  ✗ %s\n", issue)
+		}
+	}
+	printReport("Classic chain", ValidateChain(lowAmount))
+	printReport("Configured chain", ValidateChain(configuredChain))
+
+	loopStart := NewAmountBandHandler(HandlerConfig{Name: "Teller", MaxAmount: 1000})
+	loopEnd := NewAmountBandHandler(HandlerConfig{Name: "Back Office", MinAmount: 1000, MaxAmount: 5000})
+	loopStart.SetNext(loopEnd).SetNext(loopStart)
+	printReport("Looping chain", ValidateChain(loopStart))
+
+	shadowed := NewLowAmountHandler("Auto-Approval System")
+	shadowed.SetNext(NewDirectorHandler("Director")).SetNext(NewManagerHandler("Manager"))
+	printReport("Misordered chain", ValidateChain(shadowed))
+
+	fmt.Println("\nGraphviz:")
+	fmt.Print(ExportDOT(deposits))
+	fmt.Println("\nMermaid:")
+	fmt.Print(ExportMermaid(lowAmount))
+
+	// End-of-day batch through a shared chain, compared with one-by-one processing
+	fmt.Println("\n--- Concurrent Batch Processing ---")
+	sanctionsScreen := func(ctx context.Context, request *TransactionRequest) (bool, error) {
+		select {
+		case <-time.After(time.Millisecond): // simulated remote screening latency
+			return request.Amount <= 20000, nil
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	batchChain := NewAutoApproveHandler("Deposit Gate", TypeIs("deposit"), ReasonDepositAutoPass)
+	batchChain.
+		SetNext(NewApproverHandler("Sanctions Screen", AmountAbove(1000), sanctionsScreen, time.Second)).
+		SetNext(configuredChain)
+
+	types := []string{"transfer", "withdrawal", "deposit"}
+	batch := make([]*TransactionRequest, 400)
+	for i := range batch {
+		batch[i] = &TransactionRequest{
+			ID:         fmt.Sprintf("EOD%04d", i),
+			CustomerID: fmt.Sprintf("CUST%03d", i%50),
+			Amount:     float64(250 + (i*997)%30000),
+			Type:       types[i%len(types)],
+			Priority:   "low",
+		}
+	}
+
+	sequential := ProcessSequential(context.Background(), batchChain, batch)
+	concurrent := ProcessBatch(context.Background(), batchChain, batch, 16)
+	fmt.Printf("Sequential: %d requests in %v\n", len(batch), sequential.Elapsed.Round(time.Millisecond))
+	fmt.Printf("16 workers: %d requests in %v\n", len(batch), concurrent.Elapsed.Round(time.Millisecond))
+
+	sameOrder := true
+	for i, decision := range concurrent.Decisions {
+		if decision.RequestID != batch[i].ID || decision.Outcome != sequential.Decisions[i].Outcome {
+			sameOrder = false
+		}
+	}
+	fmt.Printf("Decisions match sequential run in input order: %v\n", sameOrder)
+	fmt.Printf("Outcomes: %d approved, %d rejected\n", concurrent.Outcomes[OutcomeApproved], concurrent.Outcomes[OutcomeRejected])
+	for _, approver := range slices.Sorted(maps.Keys(concurrent.ByApprover)) {
+		fmt.Printf("  %-22s %d\n", approver, concurrent.ByApprover[approver])
+	}
 
 	fmt.Println("\n✓ Chain of Responsibility decouples sender from receiver")
 	fmt.Println("✓ Each handler decides to process or pass to next")
 	fmt.Println("✓ Chain can be modified dynamically")
+	fmt.Println("✓ Approval limits live in config, not code")
+	fmt.Println("✓ Every decision carries an audit trail of the handlers it visited")
+	fmt.Println("✓ Routing predicates compose with amount-based handlers")
+	fmt.Println("✓ Quorum handlers park requests until enough approvers sign off")
+	fmt.Println("✓ Step deadlines escalate slow approvals instead of blocking the chain")
+	fmt.Println("✓ Velocity rules track per-customer activity across restarts")
+	fmt.Println("✓ Chains can be validated and exported before they go live")
+	fmt.Println("✓ A shared, goroutine-safe chain can process batches concurrently")
 	fmt.Println("✓ Useful for transaction approval workflows at JoshBank")
 }
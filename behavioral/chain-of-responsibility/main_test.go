@@ -0,0 +1,1090 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func classicChain() Handler {
+	low := NewLowAmountHandler("Auto-Approval System")
+	medium := NewMediumAmountHandler("Supervisor")
+	manager := NewManagerHandler("Manager")
+	director := NewDirectorHandler("Director")
+	low.SetNext(medium)
+	medium.SetNext(manager)
+	manager.SetNext(director)
+	return low
+}
+
+func TestClassicChainBandEdges(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		approver string
+		outcome  Outcome
+		reason   ReasonCode
+	}{
+		{-50, "Director", OutcomeRejected, ReasonNoHandler},
+		{0, "Director", OutcomeRejected, ReasonNoHandler},
+		{0.01, "Auto-Approval System", OutcomeApproved, ReasonLowAmount},
+		{1000, "Auto-Approval System", OutcomeApproved, ReasonLowAmount},
+		{1000.01, "Supervisor", OutcomeApproved, ReasonMediumAmount},
+		{50000, "Manager", OutcomeApproved, ReasonManagerApproval},
+		{50000.01, "Director", OutcomeApproved, ReasonDirectorApproval},
+	}
+	chain := classicChain()
+	for _, tt := range tests {
+		d := chain.Handle(&TransactionRequest{ID: "T", Amount: tt.amount, Type: "transfer"})
+		if d.Approver != tt.approver || d.Outcome != tt.outcome || d.Reason != tt.reason {
+			t.Errorf("amount %.2f: got %s/%s/%s, want %s/%s/%s",
+				tt.amount, d.Approver, d.Outcome, d.Reason, tt.approver, tt.outcome, tt.reason)
+		}
+	}
+}
+
+func TestParseChainConfigValidates(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"valid", `{"handlers":[{"name":"Teller","max_amount":1000},{"name":"Back Office","min_amount":1000,"types":["deposit"]}]}`, ""},
+		{"no handlers", `{"handlers":[]}`, "no handlers defined"},
+		{"missing name", `{"handlers":[{"max_amount":1000}]}`, "handler 0 has no name"},
+		{"negative min", `{"handlers":[{"name":"Teller","min_amount":-1}]}`, "negative min_amount"},
+		{"max not above min", `{"handlers":[{"name":"Teller","min_amount":1000,"max_amount":1000}]}`, "not above min_amount"},
+		{"unknown type", `{"handlers":[{"name":"Teller","types":["refund"]}]}`, `unknown transaction type "refund"`},
+		{"misspelt key", `{"handlers":[{"name":"Teller","max_amout":1000}]}`, `unknown field "max_amout"`},
+		{"trailing data", `{"handlers":[{"name":"Teller"}]} {}`, "unexpected data"},
+		{"not json", `handlers: []`, "parse chain config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseChainConfig([]byte(tt.config))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("expected an error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("error %q does not mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultChainConfigMatchesClassicChain(t *testing.T) {
+	config, err := ParseChainConfig(defaultChainConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configured, classic := config.BuildChain(), classicChain()
+	if report := ValidateChain(configured); !report.OK() {
+		t.Errorf("default config has issues: %v", report.Issues)
+	}
+	for _, transactionType := range []string{"transfer", "withdrawal", "deposit"} {
+		for _, amount := range []float64{0.01, 1000, 1000.01, 10000, 20000, 50000, 50000.01, 1e6} {
+			request := &TransactionRequest{ID: "T", Amount: amount, Type: transactionType}
+			got, want := configured.Handle(request), classic.Handle(request)
+			if got.Outcome != want.Outcome || got.Approver != want.Approver {
+				t.Errorf("%s $%.2f: configured %s by %s, classic %s by %s",
+					transactionType, amount, got.Outcome, got.Approver, want.Outcome, want.Approver)
+			}
+		}
+	}
+}
+
+func TestLoadClassicBands(t *testing.T) {
+	bands := loadClassicBands([]byte(`{"handlers": [
+		{"name": "Teller", "max_amount": 500},
+		{"name": "Supervisor", "min_amount": 500, "max_amount": 2000},
+		{"name": "Manager", "min_amount": 2000, "max_amount": 75000},
+		{"name": "Director", "min_amount": 75000}
+	]}`))
+	want := []string{"($0, $500]", "($500, $2000]", "($2000, $75000]", "($75000, ∞]"}
+	for i, band := range bands {
+		if band.String() != want[i] {
+			t.Errorf("band %d = %s, want %s", i, band, want[i])
+		}
+	}
+
+	invalid := map[string]string{
+		"three handlers": `{"handlers": [{"name": "A", "max_amount": 1}, {"name": "B", "min_amount": 1, "max_amount": 2}, {"name": "C", "min_amount": 2}]}`,
+		"gap":            `{"handlers": [{"name": "A", "max_amount": 1}, {"name": "B", "min_amount": 5, "max_amount": 10}, {"name": "C", "min_amount": 10, "max_amount": 20}, {"name": "D", "min_amount": 20}]}`,
+		"typed band":     `{"handlers": [{"name": "A", "max_amount": 1}, {"name": "B", "min_amount": 1, "max_amount": 10, "types": ["deposit"]}, {"name": "C", "min_amount": 10, "max_amount": 20}, {"name": "D", "min_amount": 20}]}`,
+		"bounded last":   `{"handlers": [{"name": "A", "max_amount": 1}, {"name": "B", "min_amount": 1, "max_amount": 10}, {"name": "C", "min_amount": 10, "max_amount": 20}, {"name": "D", "min_amount": 20, "max_amount": 30}]}`,
+		"not json":       `handlers`,
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("loadClassicBands accepted the config, want a panic")
+				}
+			}()
+			loadClassicBands([]byte(data))
+		})
+	}
+}
+
+func TestBuildChainHonoursBandsAndTypes(t *testing.T) {
+	config := &ChainConfig{Handlers: []HandlerConfig{
+		{Name: "Teller", MaxAmount: 1000},
+		{Name: "Deposit Desk", MinAmount: 1000, MaxAmount: 20000, Types: []string{"deposit"}},
+		{Name: "Manager", MinAmount: 1000, MaxAmount: 50000, Types: []string{"transfer"}},
+	}}
+	chain := config.BuildChain()
+	tests := []struct {
+		amount          float64
+		transactionType string
+		approver        string
+		outcome         Outcome
+		visited         []string
+	}{
+		{500, "withdrawal", "Teller", OutcomeApproved, []string{"Teller"}},
+		{5000, "deposit", "Deposit Desk", OutcomeApproved, []string{"Teller", "Deposit Desk"}},
+		{5000, "transfer", "Manager", OutcomeApproved, []string{"Teller", "Deposit Desk", "Manager"}},
+		{5000, "withdrawal", "", OutcomeRejected, []string{"Teller", "Deposit Desk", "Manager"}},
+		{60000, "transfer", "", OutcomeRejected, []string{"Teller", "Deposit Desk", "Manager"}},
+	}
+	for _, tt := range tests {
+		d := chain.Handle(&TransactionRequest{ID: "T", Amount: tt.amount, Type: tt.transactionType})
+		if d.Approver != tt.approver || d.Outcome != tt.outcome || !slices.Equal(d.Visited, tt.visited) {
+			t.Errorf("%s $%.2f: got %s by %q via %v, want %s by %q via %v", tt.transactionType, tt.amount,
+				d.Outcome, d.Approver, d.Visited, tt.outcome, tt.approver, tt.visited)
+		}
+	}
+}
+
+// routedTestChain mirrors the routing example in main: deposits pass, critical
+// requests take a fast lane, larger withdrawals go to a human-only branch
+func routedTestChain() Handler {
+	fastLane := NewAmountBandHandler(HandlerConfig{Name: "Duty Manager", MaxAmount: 50000})
+	fastLane.SetNext(NewAmountBandHandler(HandlerConfig{Name: "Director", MinAmount: 50000}))
+
+	humanReview := NewAmountBandHandler(HandlerConfig{Name: "Branch Supervisor", MaxAmount: 10000})
+	humanReview.SetNext(NewAmountBandHandler(HandlerConfig{Name: "Manager", MinAmount: 10000}))
+
+	deposits := NewAutoApproveHandler("Deposit Gate", TypeIs("deposit"), ReasonDepositAutoPass)
+	deposits.
+		SetNext(NewRouteHandler("Fast Lane Router", PriorityIs("critical"), fastLane)).
+		SetNext(NewRouteHandler("Withdrawal Review Router", AllOf(TypeIs("withdrawal"), AmountAbove(500)), humanReview)).
+		SetNext(classicChain())
+	return deposits
+}
+
+func TestRoutingOnTypeAndPriority(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  TransactionRequest
+		approver string
+		reason   ReasonCode
+		visited  []string
+	}{
+		{
+			name:     "deposit passes whatever the amount",
+			request:  TransactionRequest{Amount: 250000, Type: "deposit", Priority: "critical"},
+			approver: "Deposit Gate",
+			reason:   ReasonDepositAutoPass,
+			visited:  []string{"Deposit Gate"},
+		},
+		{
+			name:     "critical transfer takes the fast lane",
+			request:  TransactionRequest{Amount: 20000, Type: "transfer", Priority: "critical"},
+			approver: "Duty Manager",
+			reason:   ReasonWithinBand,
+			visited:  []string{"Deposit Gate", "Fast Lane Router", "Duty Manager"},
+		},
+		{
+			name:     "large critical transfer goes down the fast lane to the director",
+			request:  TransactionRequest{Amount: 90000, Type: "transfer", Priority: "critical"},
+			approver: "Director",
+			reason:   ReasonWithinBand,
+			visited:  []string{"Deposit Gate", "Fast Lane Router", "Duty Manager", "Director"},
+		},
+		{
+			name:     "withdrawal over the limit skips auto-approval",
+			request:  TransactionRequest{Amount: 800, Type: "withdrawal", Priority: "low"},
+			approver: "Branch Supervisor",
+			reason:   ReasonWithinBand,
+			visited:  []string{"Deposit Gate", "Fast Lane Router", "Withdrawal Review Router", "Branch Supervisor"},
+		},
+		{
+			name:     "withdrawal at the limit stays on the main chain",
+			request:  TransactionRequest{Amount: 500, Type: "withdrawal", Priority: "low"},
+			approver: "Auto-Approval System",
+			reason:   ReasonLowAmount,
+			visited:  []string{"Deposit Gate", "Fast Lane Router", "Withdrawal Review Router", "Auto-Approval System"},
+		},
+		{
+			name:     "ordinary transfer falls through every router",
+			request:  TransactionRequest{Amount: 5000, Type: "transfer", Priority: "medium"},
+			approver: "Supervisor",
+			reason:   ReasonMediumAmount,
+			visited:  []string{"Deposit Gate", "Fast Lane Router", "Withdrawal Review Router", "Auto-Approval System", "Supervisor"},
+		},
+	}
+	chain := routedTestChain()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := tt.request
+			request.ID = "T"
+			d := chain.Handle(&request)
+			if !d.Approved() || d.Approver != tt.approver || d.Reason != tt.reason {
+				t.Errorf("got %s by %s (%s), want approved by %s (%s)", d.Outcome, d.Approver, d.Reason, tt.approver, tt.reason)
+			}
+			if !slices.Equal(d.Visited, tt.visited) {
+				t.Errorf("visited %v, want %v", d.Visited, tt.visited)
+			}
+		})
+	}
+}
+
+func TestPredicatesCompose(t *testing.T) {
+	request := &TransactionRequest{Amount: 800, Type: "withdrawal", Priority: "high"}
+	tests := []struct {
+		name  string
+		match Predicate
+		want  bool
+	}{
+		{"type", TypeIs("transfer", "withdrawal"), true},
+		{"other type", TypeIs("deposit"), false},
+		{"priority", PriorityIs("high", "critical"), true},
+		{"amount above", AmountAbove(500), true},
+		{"amount not strictly above", AmountAbove(800), false},
+		{"all of", AllOf(TypeIs("withdrawal"), AmountAbove(500)), true},
+		{"all of with a miss", AllOf(TypeIs("withdrawal"), AmountAbove(1000)), false},
+		{"empty all of", AllOf(), true},
+		{"any of", AnyOf(TypeIs("deposit"), PriorityIs("high")), true},
+		{"empty any of", AnyOf(), false},
+		{"not", Not(TypeIs("deposit")), true},
+	}
+	for _, tt := range tests {
+		if got := tt.match(request); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func newTestQuorum(t *testing.T, clock Clock) *QuorumHandler {
+	t.Helper()
+	q, err := NewQuorumHandler("Dual Control Desk", TypeIs("transfer"), []string{"alice", "bob", "carol"}, 2, time.Hour, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestNewQuorumHandlerValidatesRequired(t *testing.T) {
+	approvers := []string{"alice", "bob", "alice"}
+	for _, required := range []int{-1, 0, 3} {
+		if _, err := NewQuorumHandler("Q", TypeIs("transfer"), approvers, required, time.Hour, nil); err == nil {
+			t.Errorf("required %d of 2 distinct approvers: expected an error", required)
+		}
+	}
+	for _, required := range []int{1, 2} {
+		if _, err := NewQuorumHandler("Q", TypeIs("transfer"), approvers, required, time.Hour, nil); err != nil {
+			t.Errorf("required %d: %v", required, err)
+		}
+	}
+}
+
+func TestQuorumRejectsDuplicateRequestID(t *testing.T) {
+	clock := NewManualClock(time.Date(2025, 3, 31, 9, 0, 0, 0, time.UTC))
+	q := newTestQuorum(t, clock)
+	request := &TransactionRequest{ID: "TXN1", Amount: 90000, Type: "transfer"}
+
+	if d := q.Handle(request); d.Outcome != OutcomePending {
+		t.Fatalf("first submission: got %s, want pending", d.Outcome)
+	}
+	if d := q.Handle(request); d.Outcome != OutcomeRejected || d.Reason != ReasonDuplicateRequest {
+		t.Fatalf("duplicate submission: got %s/%s", d.Outcome, d.Reason)
+	}
+
+	// The original request still resolves, and still times out if nobody signs
+	clock.Advance(2 * time.Hour)
+	expired := q.ExpirePending()
+	if len(expired) != 1 || expired[0].Reason != ReasonQuorumTimeout {
+		t.Fatalf("expired: %v", expired)
+	}
+}
+
+func TestQuorumRejectsResubmittedResolvedRequest(t *testing.T) {
+	q := newTestQuorum(t, nil)
+	request := &TransactionRequest{ID: "TXN1", Amount: 90000, Type: "transfer"}
+	q.Handle(request)
+	q.Approve("TXN1", "alice")
+	q.Approve("TXN1", "bob")
+
+	d := q.Handle(request)
+	if d.Outcome != OutcomeRejected || d.Reason != ReasonDuplicateRequest {
+		t.Fatalf("resubmission after approval: got %s/%s", d.Outcome, d.Reason)
+	}
+	if status, _ := q.Status("TXN1"); status.Outcome != OutcomeApproved || status.Reason != ReasonQuorumMet {
+		t.Fatalf("status after resubmission: got %s/%s, want approved/QUORUM_MET", status.Outcome, status.Reason)
+	}
+
+	// Once forgotten, the ID may be parked again
+	q.ForgetResolved()
+	if d := q.Handle(request); d.Outcome != OutcomePending {
+		t.Fatalf("submission after ForgetResolved: got %s, want pending", d.Outcome)
+	}
+}
+
+func TestQuorumAcceptsRetryAfterTimeout(t *testing.T) {
+	clock := NewManualClock(time.Date(2025, 3, 31, 9, 0, 0, 0, time.UTC))
+	q := newTestQuorum(t, clock)
+	request := &TransactionRequest{ID: "TXN1", Amount: 90000, Type: "transfer"}
+	q.Handle(request)
+	q.Approve("TXN1", "alice")
+
+	// Nobody called ExpirePending or Status, so the timed-out entry is still parked
+	clock.Advance(2 * time.Hour)
+	if d := q.Handle(request); d.Outcome != OutcomePending {
+		t.Fatalf("retry after timeout: got %s/%s, want pending", d.Outcome, d.Reason)
+	}
+	if d, _ := q.Approve("TXN1", "bob"); d.Outcome != OutcomePending {
+		t.Fatalf("first sign-off on the retry: got %s, want pending; earlier sign-offs must not carry over", d.Outcome)
+	}
+	if d, _ := q.Approve("TXN1", "alice"); d.Outcome != OutcomeApproved {
+		t.Fatalf("second sign-off on the retry: got %s, want approved", d.Outcome)
+	}
+}
+
+func TestQuorumEvictsResolvedDecisionsByAge(t *testing.T) {
+	clock := NewManualClock(time.Date(2025, 3, 31, 9, 0, 0, 0, time.UTC))
+	q := newTestQuorum(t, clock)
+	q.SetRetention(time.Hour)
+	first := &TransactionRequest{ID: "TXN1", Amount: 90000, Type: "transfer"}
+	second := &TransactionRequest{ID: "TXN2", Amount: 90000, Type: "transfer"}
+
+	q.Handle(first)
+	q.Veto("TXN1", "carol")
+	clock.Advance(30 * time.Minute)
+	q.Handle(second)
+	q.Veto("TXN2", "carol")
+
+	if d := q.Handle(first); d.Reason != ReasonDuplicateRequest {
+		t.Fatalf("resubmission within retention: got %s/%s, want DUPLICATE_REQUEST", d.Outcome, d.Reason)
+	}
+	clock.Advance(30 * time.Minute)
+	if _, ok := q.Status("TXN1"); ok {
+		t.Error("TXN1 still reported an hour after it resolved")
+	}
+	if status, ok := q.Status("TXN2"); !ok || status.Reason != ReasonVetoed {
+		t.Errorf("TXN2 status half an hour after it resolved: %v, %v; want vetoed", status, ok)
+	}
+	if d := q.Handle(first); d.Outcome != OutcomePending {
+		t.Fatalf("resubmission after eviction: got %s/%s, want pending", d.Outcome, d.Reason)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.resolved) != 1 {
+		t.Errorf("%d resolved decisions kept, want only TXN2", len(q.resolved))
+	}
+}
+
+func TestQuorumStatusKeepsTrail(t *testing.T) {
+	q := newTestQuorum(t, nil)
+	router := NewRouteHandler("Large Transfer Router", AmountAbove(75000), q)
+	router.SetNext(classicChain())
+
+	returned := router.Handle(&TransactionRequest{ID: "TXN1", Amount: 90000, Type: "transfer"})
+	q.Approve("TXN1", "alice")
+	q.Approve("TXN1", "bob")
+
+	status, ok := q.Status("TXN1")
+	if !ok || status.Outcome != OutcomeApproved {
+		t.Fatalf("status: %v, %v", status, ok)
+	}
+	want := []string{"Large Transfer Router", "Dual Control Desk"}
+	if !slices.Equal(status.Visited, want) || !slices.Equal(returned.Visited, want) {
+		t.Errorf("visited: status %v, returned %v, want %v", status.Visited, returned.Visited, want)
+	}
+	if returned.Outcome != OutcomePending {
+		t.Errorf("returned decision changed after the fact: %s", returned.Outcome)
+	}
+}
+
+// Run with -race: callers read their decisions while approvers resolve them
+func TestQuorumDecisionsAreSafeToRead(t *testing.T) {
+	q := newTestQuorum(t, nil)
+	const requests = 200
+
+	var wg sync.WaitGroup
+	for i := range requests {
+		id := fmt.Sprintf("TXN%d", i)
+		decision := q.Handle(&TransactionRequest{ID: id, Amount: 90000, Type: "transfer"})
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			q.Approve(id, "alice")
+			q.Approve(id, "bob")
+		}()
+		go func() {
+			defer wg.Done()
+			_ = decision.String()
+			if status, ok := q.Status(id); ok {
+				_ = status.String()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range requests {
+		if status, _ := q.Status(fmt.Sprintf("TXN%d", i)); status.Outcome != OutcomeApproved {
+			t.Fatalf("TXN%d: %s", i, status.Outcome)
+		}
+	}
+}
+
+// blockingApprover never answers; it returns only once its context is done
+func blockingApprover(ctx context.Context, request *TransactionRequest) (bool, error) {
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+// countingApprover approves everything and counts how often it was asked
+func countingApprover(calls *atomic.Int64) Approver {
+	return func(ctx context.Context, request *TransactionRequest) (bool, error) {
+		calls.Add(1)
+		return true, nil
+	}
+}
+
+func TestApproverHandlerStepTimeoutEscalates(t *testing.T) {
+	var calls atomic.Int64
+	chain := NewApproverHandler("Remote Risk Desk", nil, blockingApprover, 10*time.Millisecond)
+	chain.SetNext(NewApproverHandler("Duty Manager", nil, countingApprover(&calls), time.Second))
+
+	d := chain.HandleContext(context.Background(), &TransactionRequest{ID: "T", Amount: 15000, Type: "transfer"})
+	if !d.Approved() || d.Approver != "Duty Manager" || d.Reason != ReasonApproverSigned {
+		t.Fatalf("got %s by %s (%s), want approved by Duty Manager", d.Outcome, d.Approver, d.Reason)
+	}
+	if want := []string{"Remote Risk Desk"}; !slices.Equal(d.Escalated, want) {
+		t.Errorf("escalated %v, want %v", d.Escalated, want)
+	}
+	if want := []string{"Remote Risk Desk", "Duty Manager"}; !slices.Equal(d.Visited, want) {
+		t.Errorf("visited %v, want %v", d.Visited, want)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("duty manager asked %d times, want 1", calls.Load())
+	}
+}
+
+func TestApproverHandlerParentDeadlineDoesNotEscalate(t *testing.T) {
+	var calls atomic.Int64
+	chain := NewApproverHandler("Remote Risk Desk", nil, blockingApprover, time.Second)
+	chain.SetNext(NewApproverHandler("Duty Manager", nil, countingApprover(&calls), time.Second))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	d := chain.HandleContext(ctx, &TransactionRequest{ID: "T", Amount: 15000, Type: "transfer"})
+	if d.Outcome != OutcomeRejected || d.Reason != ReasonDeadlineExceeded {
+		t.Fatalf("got %s/%s, want rejected/DEADLINE_EXCEEDED", d.Outcome, d.Reason)
+	}
+	if len(d.Escalated) != 0 || calls.Load() != 0 {
+		t.Errorf("escalated %v and asked the next approver %d times, want neither", d.Escalated, calls.Load())
+	}
+	if want := []string{"Remote Risk Desk"}; !slices.Equal(d.Visited, want) {
+		t.Errorf("visited %v, want %v", d.Visited, want)
+	}
+}
+
+func TestCanceledContextStopsTheChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		head    func(next Handler) Handler
+		cancel  func(cancel context.CancelFunc) // cancels before or during the step
+		visited []string
+	}{
+		{
+			name: "canceled before a handler passes on",
+			head: func(next Handler) Handler {
+				h := NewLowAmountHandler("Auto-Approval System")
+				h.SetNext(next)
+				return h
+			},
+			cancel:  func(cancel context.CancelFunc) { cancel() },
+			visited: []string{"Auto-Approval System"},
+		},
+		{
+			name: "canceled while an approver is waiting",
+			head: func(next Handler) Handler {
+				h := NewApproverHandler("Remote Risk Desk", nil, blockingApprover, time.Second)
+				h.SetNext(next)
+				return h
+			},
+			cancel:  func(cancel context.CancelFunc) { time.AfterFunc(10*time.Millisecond, cancel) },
+			visited: []string{"Remote Risk Desk"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int64
+			chain := tt.head(NewApproverHandler("Duty Manager", nil, countingApprover(&calls), time.Second))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			tt.cancel(cancel)
+
+			d := chain.HandleContext(ctx, &TransactionRequest{ID: "T", Amount: 15000, Type: "transfer"})
+			if d.Outcome != OutcomeRejected || d.Reason != ReasonCanceled {
+				t.Fatalf("got %s/%s, want rejected/CANCELED", d.Outcome, d.Reason)
+			}
+			if calls.Load() != 0 {
+				t.Errorf("next approver asked %d times after cancellation", calls.Load())
+			}
+			if !slices.Equal(d.Visited, tt.visited) {
+				t.Errorf("visited %v, want %v", d.Visited, tt.visited)
+			}
+		})
+	}
+}
+
+func TestApproverHandlerOutcomes(t *testing.T) {
+	tests := []struct {
+		name     string
+		approver Approver
+		outcome  Outcome
+		reason   ReasonCode
+	}{
+		{"signs off", func(context.Context, *TransactionRequest) (bool, error) { return true, nil }, OutcomeApproved, ReasonApproverSigned},
+		{"declines", func(context.Context, *TransactionRequest) (bool, error) { return false, nil }, OutcomeRejected, ReasonApproverDeclined},
+		{"fails", func(context.Context, *TransactionRequest) (bool, error) {
+			return true, fmt.Errorf("risk desk unavailable")
+		}, OutcomeRejected, ReasonApproverError},
+		{"own call times out", func(context.Context, *TransactionRequest) (bool, error) {
+			return false, fmt.Errorf("risk desk lookup: %w", context.DeadlineExceeded)
+		}, OutcomeRejected, ReasonApproverError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int64
+			h := NewApproverHandler("Remote Risk Desk", nil, tt.approver, time.Second)
+			h.SetNext(NewApproverHandler("Duty Manager", nil, countingApprover(&calls), time.Second))
+			d := h.Handle(&TransactionRequest{ID: "T", Amount: 15000, Type: "transfer"})
+			if d.Outcome != tt.outcome || d.Reason != tt.reason || d.Approver != "Remote Risk Desk" {
+				t.Errorf("got %s/%s by %s, want %s/%s by Remote Risk Desk", d.Outcome, d.Reason, d.Approver, tt.outcome, tt.reason)
+			}
+			if calls.Load() != 0 || len(d.Escalated) != 0 {
+				t.Errorf("escalated %v with %d calls to the next approver, want none", d.Escalated, calls.Load())
+			}
+		})
+	}
+}
+
+func TestVelocityHandlerRules(t *testing.T) {
+	rules := []VelocityRule{
+		{Name: "hourly-count", Window: time.Hour, MaxCount: 2, Action: VelocityReject},
+		{Name: "daily-amount", Window: 24 * time.Hour, MaxAmount: 5000, Action: VelocityEscalate},
+	}
+	fraudDesk := func() Handler { return NewAmountBandHandler(HandlerConfig{Name: "Fraud Desk", MaxAmount: 20000}) }
+	tests := []struct {
+		name       string
+		escalation Handler
+		history    []float64 // amounts already recorded an hour and a half ago
+		recent     int       // extra $1 events recorded in the last hour
+		amount     float64
+		outcome    Outcome
+		approver   string
+		reason     ReasonCode
+		detail     string
+		visited    []string
+	}{
+		{
+			name: "under every limit", escalation: fraudDesk(), amount: 900,
+			outcome: OutcomeApproved, approver: "Auto-Approval System", reason: ReasonLowAmount,
+			visited: []string{"Velocity Check", "Auto-Approval System"},
+		},
+		{
+			name: "count rule rejects", escalation: fraudDesk(), recent: 2, amount: 900,
+			outcome: OutcomeRejected, approver: "Velocity Check", reason: ReasonVelocityExceeded, detail: "hourly-count",
+			visited: []string{"Velocity Check"},
+		},
+		{
+			name: "amount rule escalates", escalation: fraudDesk(), history: []float64{3000, 1500}, amount: 900,
+			outcome: OutcomeApproved, approver: "Fraud Desk", reason: ReasonWithinBand, detail: "daily-amount",
+			visited: []string{"Velocity Check", "Fraud Desk"},
+		},
+		{
+			name: "amount rule rejects without an escalation branch", history: []float64{3000, 1500}, amount: 900,
+			outcome: OutcomeRejected, approver: "Velocity Check", reason: ReasonVelocityExceeded, detail: "daily-amount",
+			visited: []string{"Velocity Check"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewManualClock(time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC))
+			store := NewMemoryVelocityStore()
+			for _, amount := range tt.history {
+				store.Record(VelocityEvent{CustomerID: "CUST1", Amount: amount, At: clock.Now().Add(-90 * time.Minute)})
+			}
+			for range tt.recent {
+				store.Record(VelocityEvent{CustomerID: "CUST1", Amount: 1, At: clock.Now().Add(-time.Minute)})
+			}
+			h := NewVelocityHandler("Velocity Check", rules, store, tt.escalation, clock)
+			h.SetNext(classicChain())
+
+			d := h.Handle(&TransactionRequest{ID: "T", CustomerID: "CUST1", Amount: tt.amount, Type: "transfer"})
+			if d.Outcome != tt.outcome || d.Approver != tt.approver || d.Reason != tt.reason || d.Detail != tt.detail {
+				t.Errorf("got %s by %s (%s, %q), want %s by %s (%s, %q)",
+					d.Outcome, d.Approver, d.Reason, d.Detail, tt.outcome, tt.approver, tt.reason, tt.detail)
+			}
+			if !slices.Equal(d.Visited, tt.visited) {
+				t.Errorf("visited %v, want %v", d.Visited, tt.visited)
+			}
+		})
+	}
+}
+
+func TestVelocityHandlerRecordsOnlyApprovedDecisions(t *testing.T) {
+	clock := NewManualClock(time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC))
+	store := NewMemoryVelocityStore()
+	rules := []VelocityRule{{Name: "hourly-count", Window: time.Hour, MaxCount: 5, Action: VelocityReject}}
+	h := NewVelocityHandler("Velocity Check", rules, store, nil, clock)
+	// Only amounts up to $1,000 are approved; the rest fall off the end of the chain
+	h.SetNext(NewLowAmountHandler("Auto-Approval System"))
+
+	for i, amount := range []float64{100, 5000, 200, 7000} {
+		h.Handle(&TransactionRequest{ID: fmt.Sprintf("T%d", i), CustomerID: "CUST1", Amount: amount, Type: "transfer"})
+	}
+	if total, count, _ := store.Window("CUST1", clock.Now().Add(-time.Hour)); total != 300 || count != 2 {
+		t.Errorf("recorded $%.2f over %d events, want $300.00 over 2", total, count)
+	}
+}
+
+func TestVelocityHandlerDoesNotSerialiseDownstreamApprovals(t *testing.T) {
+	store := NewMemoryVelocityStore()
+	rules := []VelocityRule{{Name: "daily-count", Window: 24 * time.Hour, MaxCount: 3, Action: VelocityReject}}
+	h := NewVelocityHandler("Velocity Check", rules, store, nil, nil)
+
+	// Each approval waits until the other requests are also with an approver, which
+	// only happens if the handler does not hold a lock while they wait
+	const concurrent = 3
+	var waiting sync.WaitGroup
+	waiting.Add(concurrent)
+	approver := func(ctx context.Context, request *TransactionRequest) (bool, error) {
+		waiting.Done()
+		waiting.Wait()
+		return true, nil
+	}
+	h.SetNext(NewApproverHandler("Risk Desk", nil, approver, time.Second))
+
+	decisions := make([]*Decision, concurrent)
+	var wg sync.WaitGroup
+	for i := range concurrent {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			decisions[i] = h.Handle(&TransactionRequest{ID: fmt.Sprintf("T%d", i), CustomerID: "CUST1", Amount: 100, Type: "transfer"})
+		}()
+	}
+	wg.Wait()
+	for _, d := range decisions {
+		if !d.Approved() || len(d.Escalated) != 0 {
+			t.Errorf("%s: %s by %s, escalated %v", d.RequestID, d.Outcome, d.Approver, d.Escalated)
+		}
+	}
+
+	// All three are recorded, so a fourth is over the limit, and nothing is left in flight
+	if d := h.Handle(&TransactionRequest{ID: "T3", CustomerID: "CUST1", Amount: 100, Type: "transfer"}); d.Reason != ReasonVelocityExceeded {
+		t.Errorf("fourth request: got %s/%s, want VELOCITY_EXCEEDED", d.Outcome, d.Reason)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.inFlight) != 0 {
+		t.Errorf("in-flight usage left behind: %v", h.inFlight)
+	}
+}
+
+func TestVelocityHandlerCountsRequestsStillInTheChain(t *testing.T) {
+	store := NewMemoryVelocityStore()
+	rules := []VelocityRule{{Name: "daily-count", Window: 24 * time.Hour, MaxCount: 1, Action: VelocityReject}}
+	h := NewVelocityHandler("Velocity Check", rules, store, nil, nil)
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	h.SetNext(NewApproverHandler("Risk Desk", nil, func(ctx context.Context, request *TransactionRequest) (bool, error) {
+		close(entered)
+		<-release
+		return true, nil
+	}, time.Second))
+
+	first := make(chan *Decision)
+	go func() {
+		first <- h.Handle(&TransactionRequest{ID: "T1", CustomerID: "CUST1", Amount: 100, Type: "transfer"})
+	}()
+	<-entered
+	if d := h.Handle(&TransactionRequest{ID: "T2", CustomerID: "CUST1", Amount: 100, Type: "transfer"}); d.Reason != ReasonVelocityExceeded {
+		t.Errorf("second request while the first is pending: got %s/%s, want VELOCITY_EXCEEDED", d.Outcome, d.Reason)
+	}
+	close(release)
+	if d := <-first; !d.Approved() {
+		t.Errorf("first request: got %s/%s", d.Outcome, d.Reason)
+	}
+}
+
+func TestFileVelocityStoreDropsTornFinalRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "velocity.jsonl")
+	at := time.Date(2025, 3, 31, 9, 0, 0, 0, time.UTC)
+	store, err := OpenFileVelocityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Record(VelocityEvent{CustomerID: "CUST1", Amount: 100, At: at})
+	store.Record(VelocityEvent{CustomerID: "CUST1", Amount: 200, At: at})
+	store.Close()
+
+	// A crash mid-write leaves half a record without its newline
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	f.WriteString(`{"customer_id":"CUST1","amo`)
+	f.Close()
+
+	store, err = OpenFileVelocityStore(path)
+	if err != nil {
+		t.Fatalf("reopen after torn write: %v", err)
+	}
+	defer store.Close()
+	if total, count, _ := store.Window("CUST1", at); total != 300 || count != 2 {
+		t.Errorf("window after recovery: $%.2f over %d events, want $300.00 over 2", total, count)
+	}
+
+	// New records land after the last complete one, not glued to the torn bytes
+	store.Record(VelocityEvent{CustomerID: "CUST1", Amount: 50, At: at})
+	store.Close()
+	store, err = OpenFileVelocityStore(path)
+	if err != nil {
+		t.Fatalf("reopen after recovery: %v", err)
+	}
+	if total, count, _ := store.Window("CUST1", at); total != 350 || count != 3 {
+		t.Errorf("window after append: $%.2f over %d events, want $350.00 over 3", total, count)
+	}
+}
+
+func TestFileVelocityStoreRejectsDamageBeforeTheEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "velocity.jsonl")
+	os.WriteFile(path, []byte("{not json}\n"+`{"customer_id":"CUST1","amount":1,"at":"2025-03-31T09:00:00Z"}`+"\n"), 0o644)
+	if _, err := OpenFileVelocityStore(path); err == nil {
+		t.Fatal("expected an error for a damaged record that is not the last one")
+	}
+}
+
+func TestFileVelocityStorePruneCompactsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "velocity.jsonl")
+	start := time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC)
+	store, err := OpenFileVelocityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hour := range 48 {
+		store.Record(VelocityEvent{CustomerID: "CUST1", Amount: 10, At: start.Add(time.Duration(hour) * time.Hour)})
+	}
+	cutoff := start.Add(24 * time.Hour)
+	if err := store.Prune(cutoff); err != nil {
+		t.Fatal(err)
+	}
+	store.Record(VelocityEvent{CustomerID: "CUST1", Amount: 10, At: start.Add(48 * time.Hour)})
+	store.Close()
+
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 25 {
+		t.Errorf("file has %d records after pruning, want 25", lines)
+	}
+	store, err = OpenFileVelocityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if total, count, _ := store.Window("CUST1", start); total != 250 || count != 25 {
+		t.Errorf("window after reopen: $%.2f over %d events, want $250.00 over 25", total, count)
+	}
+}
+
+func TestFileVelocityStoreRecordsAfterRepeatedPrunes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "velocity.jsonl")
+	start := time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC)
+	store, err := OpenFileVelocityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for day := range 3 {
+		at := start.Add(time.Duration(day) * 24 * time.Hour)
+		if err := store.Record(VelocityEvent{CustomerID: "CUST1", Amount: 10, At: at}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Prune(at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Record(VelocityEvent{CustomerID: "CUST1", Amount: 5, At: start.Add(72 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+	store, err = OpenFileVelocityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if total, count, _ := store.Window("CUST1", start); total != 15 || count != 2 {
+		t.Errorf("window after reopen: $%.2f over %d events, want $15.00 over 2", total, count)
+	}
+}
+
+func issueKinds(report *ChainReport) []string {
+	var kinds []string
+	for _, issue := range report.Issues {
+		kinds = append(kinds, issue.String())
+	}
+	return kinds
+}
+
+func TestValidateChain(t *testing.T) {
+	tests := []struct {
+		name   string
+		head   func() Handler
+		path   []string
+		issues []string
+	}{
+		{
+			name: "classic chain",
+			head: classicChain,
+			path: []string{"Auto-Approval System", "Supervisor", "Manager", "Director"},
+		},
+		{
+			name: "next loop",
+			head: func() Handler {
+				teller := NewAmountBandHandler(HandlerConfig{Name: "Teller", MaxAmount: 1000})
+				teller.SetNext(NewAmountBandHandler(HandlerConfig{Name: "Back Office", MinAmount: 1000})).SetNext(teller)
+				return teller
+			},
+			path:   []string{"Teller", "Back Office"},
+			issues: []string{"cycle at Teller: next handler loops back to an earlier handler"},
+		},
+		{
+			name: "branch loop",
+			head: func() Handler {
+				teller := NewAmountBandHandler(HandlerConfig{Name: "Teller", MaxAmount: 1000})
+				review := NewAmountBandHandler(HandlerConfig{Name: "Review", MaxAmount: 5000})
+				router := NewRouteHandler("Router", PriorityIs("critical"), review)
+				review.SetNext(router)
+				router.SetNext(teller).SetNext(NewDirectorHandler("Director"))
+				return router
+			},
+			path: []string{"Router", "Teller", "Director"},
+			issues: []string{
+				"cycle at Router: a routing branch leads back to this handler",
+				"gap at Director: no handler approves deposit amounts in ($1000, $50000]",
+				"gap at Director: no handler approves transfer amounts in ($1000, $50000]",
+				"gap at Director: no handler approves withdrawal amounts in ($1000, $50000]",
+			},
+		},
+		{
+			name: "misordered director",
+			head: func() Handler {
+				low := NewLowAmountHandler("Auto-Approval System")
+				low.SetNext(NewDirectorHandler("Director")).SetNext(NewManagerHandler("Manager"))
+				return low
+			},
+			path: []string{"Auto-Approval System", "Director", "Manager"},
+			issues: []string{
+				"unreachable at Manager: Director never passes requests on",
+				"gap at Director: no handler approves deposit amounts in ($1000, $50000]",
+				"gap at Director: no handler approves transfer amounts in ($1000, $50000]",
+				"gap at Director: no handler approves withdrawal amounts in ($1000, $50000]",
+			},
+		},
+		{
+			name: "overlap and a type-specific gap",
+			head: func() Handler {
+				config := &ChainConfig{Handlers: []HandlerConfig{
+					{Name: "Teller", MaxAmount: 2000},
+					{Name: "Supervisor", MinAmount: 1000, MaxAmount: 10000, Types: []string{"transfer", "withdrawal"}},
+					{Name: "Director", MinAmount: 10000},
+				}}
+				return config.BuildChain()
+			},
+			path: []string{"Teller", "Supervisor", "Director"},
+			issues: []string{
+				"gap at Director: no handler approves deposit amounts in ($2000, $10000]",
+				"overlap at Supervisor: overlaps Teller for transfer amounts in ($1000, $2000]",
+				"overlap at Supervisor: overlaps Teller for withdrawal amounts in ($1000, $2000]",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := ValidateChain(tt.head())
+			if !slices.Equal(report.Path, tt.path) {
+				t.Errorf("path %v, want %v", report.Path, tt.path)
+			}
+			if got := issueKinds(report); !slices.Equal(got, tt.issues) {
+				t.Errorf("issues:\n  got  %q\n  want %q", got, tt.issues)
+			}
+			if report.OK() != (len(tt.issues) == 0) {
+				t.Errorf("OK() = %v with %d issues", report.OK(), len(tt.issues))
+			}
+		})
+	}
+}
+
+// exportTestChain has a routing branch and a type-restricted band, so both
+// edge styles and both label forms show up in the exports
+func exportTestChain() Handler {
+	router := NewRouteHandler("Fast Lane Router", PriorityIs("critical"),
+		NewAmountBandHandler(HandlerConfig{Name: "Duty Manager", MaxAmount: 50000}))
+	router.
+		SetNext(NewAmountBandHandler(HandlerConfig{Name: "Teller", MaxAmount: 1000, Types: []string{"transfer", "deposit"}})).
+		SetNext(NewDirectorHandler("Director"))
+	return router
+}
+
+func TestExportDOT(t *testing.T) {
+	want := `digraph ApprovalChain {
+  rankdir=LR;
+  node [shape=box];
+  n0 [label="Fast Lane Router"];
+  n1 [label="Teller ($0, $1000] deposit/transfer"];
+  n2 [label="Duty Manager ($0, $50000]"];
+  n3 [label="Director ($50000, ∞]"];
+  n0 -> n1;
+  n0 -> n2 [style=dashed, label="match"];
+  n1 -> n3;
+}
+`
+	if got := ExportDOT(exportTestChain()); got != want {
+		t.Errorf("ExportDOT:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestExportMermaid(t *testing.T) {
+	want := `flowchart LR
+    n0["Fast Lane Router"]
+    n1["Teller ($0, $1000] deposit/transfer"]
+    n2["Duty Manager ($0, $50000]"]
+    n3["Director ($50000, ∞]"]
+    n0 --> n1
+    n0 -.->|match| n2
+    n1 --> n3
+`
+	if got := ExportMermaid(exportTestChain()); got != want {
+		t.Errorf("ExportMermaid:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestExportTerminatesOnCycles(t *testing.T) {
+	teller := NewAmountBandHandler(HandlerConfig{Name: "Teller", MaxAmount: 1000})
+	teller.SetNext(NewAmountBandHandler(HandlerConfig{Name: "Back Office", MinAmount: 1000})).SetNext(teller)
+	if got := ExportMermaid(teller); !strings.HasSuffix(got, "    n1 --> n0\n") {
+		t.Errorf("looping chain exported as:\n%s", got)
+	}
+}
+
+// batchTestChain mirrors the end-of-day chain in main: deposits pass, larger
+// amounts go through a remote screening call that takes latency, then the
+// configured amount bands decide
+func batchTestChain(tb testing.TB, latency func(i int) time.Duration) Handler {
+	tb.Helper()
+	config, err := ParseChainConfig(defaultChainConfig)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var calls atomic.Int64
+	screen := func(ctx context.Context, request *TransactionRequest) (bool, error) {
+		select {
+		case <-time.After(latency(int(calls.Add(1)))):
+			return request.Amount <= 20000, nil
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	chain := NewAutoApproveHandler("Deposit Gate", TypeIs("deposit"), ReasonDepositAutoPass)
+	chain.
+		SetNext(NewApproverHandler("Sanctions Screen", AmountAbove(1000), screen, time.Second)).
+		SetNext(config.BuildChain())
+	return chain
+}
+
+func batchTestRequests(n int) []*TransactionRequest {
+	types := []string{"transfer", "withdrawal", "deposit"}
+	requests := make([]*TransactionRequest, n)
+	for i := range requests {
+		requests[i] = &TransactionRequest{
+			ID:         fmt.Sprintf("EOD%04d", i),
+			CustomerID: fmt.Sprintf("CUST%03d", i%50),
+			Amount:     float64(250 + (i*997)%30000),
+			Type:       types[i%len(types)],
+			Priority:   "low",
+		}
+	}
+	return requests
+}
+
+func TestProcessBatchKeepsInputOrder(t *testing.T) {
+	// Uneven latencies make workers finish out of order
+	chain := batchTestChain(t, func(i int) time.Duration { return time.Duration(i%7) * 100 * time.Microsecond })
+	requests := batchTestRequests(300)
+
+	sequential := ProcessSequential(context.Background(), chain, requests)
+	concurrent := ProcessBatch(context.Background(), chain, requests, 16)
+
+	if len(concurrent.Decisions) != len(requests) {
+		t.Fatalf("got %d decisions for %d requests", len(concurrent.Decisions), len(requests))
+	}
+	for i, decision := range concurrent.Decisions {
+		if decision.RequestID != requests[i].ID {
+			t.Fatalf("decision %d is for %s, want %s", i, decision.RequestID, requests[i].ID)
+		}
+		if want := sequential.Decisions[i]; decision.Outcome != want.Outcome || decision.Approver != want.Approver {
+			t.Errorf("%s: batch %s by %s, sequential %s by %s",
+				decision.RequestID, decision.Outcome, decision.Approver, want.Outcome, want.Approver)
+		}
+	}
+	if !maps.Equal(concurrent.ByApprover, sequential.ByApprover) {
+		t.Errorf("per-approver counts differ: batch %v, sequential %v", concurrent.ByApprover, sequential.ByApprover)
+	}
+}
+
+var batchBenchmarkLatencies = []struct {
+	name    string
+	latency time.Duration
+}{
+	{"in-process", 0},
+	{"1ms-screening", time.Millisecond},
+}
+
+func BenchmarkProcessSequential(b *testing.B) {
+	requests := batchTestRequests(200)
+	for _, bm := range batchBenchmarkLatencies {
+		b.Run(bm.name, func(b *testing.B) {
+			chain := batchTestChain(b, func(int) time.Duration { return bm.latency })
+			for b.Loop() {
+				ProcessSequential(context.Background(), chain, requests)
+			}
+		})
+	}
+}
+
+func BenchmarkProcessBatch(b *testing.B) {
+	requests := batchTestRequests(200)
+	for _, bm := range batchBenchmarkLatencies {
+		for _, workers := range []int{4, 16, 64} {
+			b.Run(fmt.Sprintf("%s/workers=%d", bm.name, workers), func(b *testing.B) {
+				chain := batchTestChain(b, func(int) time.Duration { return bm.latency })
+				for b.Loop() {
+					ProcessBatch(context.Background(), chain, requests, workers)
+				}
+			})
+		}
+	}
+}
@@ -1,48 +1,137 @@
 package main
 
-import "fmt"
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 // BankingCommand interface declares methods for executing and undoing banking operations
 type BankingCommand interface {
-	Execute()
-	Undo()
+	Execute() error
+	Undo() error
 	GetDescription() string
 }
 
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrNotExecuted       = errors.New("command has not been executed")
+	ErrNothingToUndo     = errors.New("nothing to undo")
+	ErrNothingToRedo     = errors.New("nothing to redo")
+	ErrUnknownHistory    = errors.New("no such history entry")
+	ErrNotJournaled      = errors.New("command cannot be journaled")
+	ErrJournalCorrupt    = errors.New("journal is corrupt")
+	ErrAccountNotFound   = errors.New("account not found")
+	ErrUnknownCommand    = errors.New("unknown command type")
+	ErrNotSerializable   = errors.New("command cannot be serialized")
+	ErrDuplicateSchedule = errors.New("schedule already exists")
+	ErrKeyConflict       = errors.New("idempotency key reused with a different command")
+	ErrSameAccount       = errors.New("cannot transfer to the same account")
+	ErrUnauthorized      = errors.New("not authorized")
+	ErrInvalidSchedule   = errors.New("invalid schedule")
+)
+
 // --- Receivers (banking services that perform actual work) ---
 
+// operationLog receives the receivers' activity lines; the stress test points it at io.Discard
+var operationLog io.Writer = os.Stdout
+
+// Account is safe for concurrent use
 type Account struct {
+	mu        sync.Mutex
 	accountID string
 	balance   float64
+	lockRank  atomic.Uint64 // breaks lock-order ties between accounts that share an ID
 }
 
 func (a *Account) Deposit(amount float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.balance += amount
-	fmt.Printf("  [Account %s] Deposited $%.2f, Balance: $%.2f\n", a.accountID, amount, a.balance)
+	fmt.Fprintf(operationLog, "  [Account %s] Deposited $%.2f, Balance: $%.2f\n", a.accountID, amount, a.balance)
 }
 
 func (a *Account) Withdraw(amount float64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	if a.balance < amount {
-		return fmt.Errorf("insufficient funds")
+		return fmt.Errorf("account %s: %w", a.accountID, ErrInsufficientFunds)
 	}
 	a.balance -= amount
-	fmt.Printf("  [Account %s] Withdrew $%.2f, Balance: $%.2f\n", a.accountID, amount, a.balance)
+	fmt.Fprintf(operationLog, "  [Account %s] Withdrew $%.2f, Balance: $%.2f\n", a.accountID, amount, a.balance)
 	return nil
 }
 
 func (a *Account) GetBalance() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	return a.balance
 }
 
+// lockRanks hands out the tie-breaking ranks that lockOrder assigns on first use
+var lockRanks atomic.Uint64
+
+// rank returns the account's tie-breaking rank, assigning the next free one the
+// first time it is needed. Ranks never change and no two accounts share one.
+func (a *Account) rank() uint64 {
+	if rank := a.lockRank.Load(); rank != 0 {
+		return rank
+	}
+	a.lockRank.CompareAndSwap(0, lockRanks.Add(1))
+	return a.lockRank.Load()
+}
+
+// lockOrder returns a and b in the order lockPair locks them: by account ID, and
+// by rank for distinct accounts that share an ID. The result is the same
+// whichever way round they are passed.
+func lockOrder(a, b *Account) (first, second *Account) {
+	if b.accountID < a.accountID || (b.accountID == a.accountID && b.rank() < a.rank()) {
+		return b, a
+	}
+	return a, b
+}
+
+// lockPair locks two distinct accounts in lockOrder, so transfers in opposite
+// directions take the locks in the same order and can't deadlock
+func lockPair(a, b *Account) (unlock func()) {
+	first, second := lockOrder(a, b)
+	first.mu.Lock()
+	second.mu.Lock()
+	return func() {
+		second.mu.Unlock()
+		first.mu.Unlock()
+	}
+}
+
 type TransferService struct{}
 
+// Transfer moves money atomically: both accounts stay locked for the whole transfer
 func (t *TransferService) Transfer(from, to *Account, amount float64) error {
+	if from == to {
+		return fmt.Errorf("account %s: %w", from.accountID, ErrSameAccount)
+	}
+	unlock := lockPair(from, to)
+	defer unlock()
+
 	if from.balance < amount {
-		return fmt.Errorf("insufficient funds in source account")
+		return fmt.Errorf("source account %s: %w", from.accountID, ErrInsufficientFunds)
 	}
 	from.balance -= amount
 	to.balance += amount
-	fmt.Printf("  [Transfer] Transferred $%.2f from %s to %s\n", amount, from.accountID, to.accountID)
+	fmt.Fprintf(operationLog, "  [Transfer] Transferred $%.2f from %s to %s\n", amount, from.accountID, to.accountID)
 	return nil
 }
 
@@ -51,14 +140,33 @@ func (t *TransferService) Transfer(from, to *Account, amount float64) error {
 type DepositCommand struct {
 	account *Account
 	amount  float64
+	success bool
 }
 
-func (c *DepositCommand) Execute() {
+func (c *DepositCommand) Execute() error {
 	c.account.Deposit(c.amount)
+	c.success = true
+	return nil
+}
+
+func (c *DepositCommand) Undo() error {
+	if !c.success {
+		return ErrNotExecuted
+	}
+	// The deposited money may already have been spent
+	if err := c.account.Withdraw(c.amount); err != nil {
+		return err
+	}
+	c.success = false
+	return nil
 }
 
-func (c *DepositCommand) Undo() {
-	c.account.Withdraw(c.amount)
+func (c *DepositCommand) JournalOps() []JournalOp {
+	return []JournalOp{{Op: OpDeposit, Account: c.account.accountID, Amount: c.amount}}
+}
+
+func (c *DepositCommand) Envelope() (CommandEnvelope, error) {
+	return newEnvelope(CommandTypeDeposit, AccountAmountPayload{Account: c.account.accountID, Amount: c.amount})
 }
 
 func (c *DepositCommand) GetDescription() string {
@@ -71,15 +179,27 @@ type WithdrawCommand struct {
 	success bool
 }
 
-func (c *WithdrawCommand) Execute() {
+func (c *WithdrawCommand) Execute() error {
 	err := c.account.Withdraw(c.amount)
 	c.success = (err == nil)
+	return err
 }
 
-func (c *WithdrawCommand) Undo() {
-	if c.success {
-		c.account.Deposit(c.amount)
+func (c *WithdrawCommand) Undo() error {
+	if !c.success {
+		return ErrNotExecuted
 	}
+	c.account.Deposit(c.amount)
+	c.success = false
+	return nil
+}
+
+func (c *WithdrawCommand) JournalOps() []JournalOp {
+	return []JournalOp{{Op: OpWithdraw, Account: c.account.accountID, Amount: c.amount}}
+}
+
+func (c *WithdrawCommand) Envelope() (CommandEnvelope, error) {
+	return newEnvelope(CommandTypeWithdraw, AccountAmountPayload{Account: c.account.accountID, Amount: c.amount})
 }
 
 func (c *WithdrawCommand) GetDescription() string {
@@ -94,22 +214,37 @@ type TransferCommand struct {
 	success         bool
 }
 
-func (c *TransferCommand) Execute() {
+func (c *TransferCommand) Execute() error {
 	err := c.transferService.Transfer(c.from, c.to, c.amount)
 	c.success = (err == nil)
+	return err
 }
 
-func (c *TransferCommand) Undo() {
-	if c.success {
-		c.transferService.Transfer(c.to, c.from, c.amount)
+func (c *TransferCommand) Undo() error {
+	if !c.success {
+		return ErrNotExecuted
+	}
+	if err := c.transferService.Transfer(c.to, c.from, c.amount); err != nil {
+		return err
 	}
+	c.success = false
+	return nil
+}
+
+func (c *TransferCommand) JournalOps() []JournalOp {
+	return []JournalOp{{Op: OpTransfer, Account: c.from.accountID, To: c.to.accountID, Amount: c.amount}}
+}
+
+func (c *TransferCommand) Envelope() (CommandEnvelope, error) {
+	return newEnvelope(CommandTypeTransfer, TransferPayload{From: c.from.accountID, To: c.to.accountID, Amount: c.amount})
 }
 
 func (c *TransferCommand) GetDescription() string {
 	return fmt.Sprintf("Transfer $%.2f from %s to %s", c.amount, c.from.accountID, c.to.accountID)
 }
 
-// MacroCommand executes multiple commands
+// MacroCommand executes multiple commands as one all-or-nothing operation:
+// if a step fails, the steps already done are compensated in reverse order
 type MacroCommand struct {
 	commands    []BankingCommand
 	description string
@@ -119,16 +254,76 @@ func NewMacroCommand(description string, commands []BankingCommand) *MacroComman
 	return &MacroCommand{commands: commands, description: description}
 }
 
-func (m *MacroCommand) Execute() {
-	for _, cmd := range m.commands {
-		cmd.Execute()
+func (m *MacroCommand) Execute() error {
+	for i, cmd := range m.commands {
+		if err := cmd.Execute(); err != nil {
+			stepErr := fmt.Errorf("step %d (%s): %w", i+1, cmd.GetDescription(), err)
+			return errors.Join(stepErr, m.undoSteps(i))
+		}
 	}
+	return nil
 }
 
-func (m *MacroCommand) Undo() {
+// Undo reverses every step; if one can't be undone, the steps already undone are re-applied
+func (m *MacroCommand) Undo() error {
 	for i := len(m.commands) - 1; i >= 0; i-- {
-		m.commands[i].Undo()
+		if err := m.commands[i].Undo(); err != nil {
+			stepErr := fmt.Errorf("undo step %d (%s): %w", i+1, m.commands[i].GetDescription(), err)
+			return errors.Join(stepErr, m.redoSteps(i+1))
+		}
+	}
+	return nil
+}
+
+// undoSteps compensates the first n steps in reverse order
+func (m *MacroCommand) undoSteps(n int) error {
+	var errs []error
+	for i := n - 1; i >= 0; i-- {
+		if err := m.commands[i].Undo(); err != nil {
+			errs = append(errs, fmt.Errorf("compensate step %d (%s): %w", i+1, m.commands[i].GetDescription(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// redoSteps re-applies steps from index start onwards after a failed undo
+func (m *MacroCommand) redoSteps(start int) error {
+	var errs []error
+	for i := start; i < len(m.commands); i++ {
+		if err := m.commands[i].Execute(); err != nil {
+			errs = append(errs, fmt.Errorf("re-apply step %d (%s): %w", i+1, m.commands[i].GetDescription(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// JournalOps concatenates the steps' effects; every step must be journalable
+func (m *MacroCommand) JournalOps() []JournalOp {
+	var ops []JournalOp
+	for _, cmd := range m.commands {
+		journaled, ok := cmd.(JournaledCommand)
+		if !ok {
+			return nil
+		}
+		ops = append(ops, journaled.JournalOps()...)
+	}
+	return ops
+}
+
+func (m *MacroCommand) Envelope() (CommandEnvelope, error) {
+	payload := MacroPayload{Description: m.description}
+	for _, cmd := range m.commands {
+		serializable, ok := cmd.(SerializableCommand)
+		if !ok {
+			return CommandEnvelope{}, fmt.Errorf("macro %q step %q: %w", m.description, cmd.GetDescription(), ErrNotSerializable)
+		}
+		envelope, err := serializable.Envelope()
+		if err != nil {
+			return CommandEnvelope{}, err
+		}
+		payload.Commands = append(payload.Commands, envelope)
 	}
+	return newEnvelope(CommandTypeMacro, payload)
 }
 
 func (m *MacroCommand) GetDescription() string {
@@ -137,31 +332,1297 @@ func (m *MacroCommand) GetDescription() string {
 
 // --- Invoker ---
 
+// HistoryEntry describes one command in the controller's history
+type HistoryEntry struct {
+	Seq         int
+	Description string
+	ExecutedAt  time.Time // when the command first ran; for denied entries, when the attempt was made
+	Actor       string    // who first ran it
+	RedoneAt    time.Time // when it was last redone; zero if never
+	RedoneBy    string    // who last redid it, and so owns it for undo
+	Undone      bool      // true while the command sits on the redo branch
+	Denied      bool      // true for attempts the authorization policy refused
+	Action      Action    // the refused action, for denied entries
+	Reason      string    // why the attempt was refused
+}
+
+type historyRecord struct {
+	seq        int
+	cmd        BankingCommand
+	executedAt time.Time
+	actor      string
+	redoneAt   time.Time
+	redoneBy   string
+}
+
+// owner is the actor who last applied the command
+func (r historyRecord) owner() string {
+	if r.redoneBy != "" {
+		return r.redoneBy
+	}
+	return r.actor
+}
+
+func (r historyRecord) entry() HistoryEntry {
+	return HistoryEntry{
+		Seq:         r.seq,
+		Description: r.cmd.GetDescription(),
+		ExecutedAt:  r.executedAt,
+		Actor:       r.actor,
+		RedoneAt:    r.redoneAt,
+		RedoneBy:    r.redoneBy,
+	}
+}
+
+// BankingController keeps executed commands on an undo stack and undone ones on
+// a redo stack. Executing a new command discards the redo branch.
 type BankingController struct {
-	history []BankingCommand
+	history []historyRecord
+	redo    []historyRecord // most recently undone last
+	lastSeq int
+	now     func() time.Time
+	journal *Journal
+
+	idempotency          map[idempotencyKey]ExecutionOutcome
+	idempotencyRetention time.Duration
+
+	policy AuthorizationPolicy
+	denied []HistoryEntry
 }
 
+// DefaultIdempotencyRetention is how long a key's outcome is kept unless changed
+const DefaultIdempotencyRetention = 24 * time.Hour
+
 func NewBankingController() *BankingController {
-	return &BankingController{history: make([]BankingCommand, 0)}
+	return &BankingController{
+		history:              make([]historyRecord, 0),
+		now:                  time.Now,
+		idempotency:          make(map[idempotencyKey]ExecutionOutcome),
+		idempotencyRetention: DefaultIdempotencyRetention,
+	}
+}
+
+// SetClock replaces the controller's time source for history timestamps and idempotency expiry
+func (b *BankingController) SetClock(clock Clock) {
+	b.now = clock.Now
+}
+
+// AttachJournal makes the controller append every executed, undone and redone
+// command to the journal. From then on only JournaledCommands are accepted.
+func (b *BankingController) AttachJournal(journal *Journal) {
+	b.journal = journal
+}
+
+// writeJournal appends a command's balance effects; it is a no-op without a journal
+func (b *BankingController) writeJournal(kind JournalKind, cmd BankingCommand) error {
+	if b.journal == nil {
+		return nil
+	}
+	journaled, ok := cmd.(JournaledCommand)
+	if !ok || journaled.JournalOps() == nil {
+		return fmt.Errorf("%q: %w", cmd.GetDescription(), ErrNotJournaled)
+	}
+	ops := journaled.JournalOps()
+	if kind == JournalUndo {
+		ops = InvertOps(ops)
+	}
+	_, err := b.journal.Append(kind, cmd.GetDescription(), ops)
+	return err
+}
+
+// ExecuteCommand runs a command as SystemActor and records it in the history only if it succeeded
+func (b *BankingController) ExecuteCommand(cmd BankingCommand) error {
+	return b.ExecuteAs(SystemActor, cmd)
+}
+
+// ExecuteAs runs a command on behalf of an actor, subject to the authorization policy
+func (b *BankingController) ExecuteAs(actor Actor, cmd BankingCommand) error {
+	_, err := b.execute(actor, cmd)
+	return err
+}
+
+// execute is ExecuteAs that also reports whether the command ran: it either
+// succeeded or its own Execute failed. Refused commands, and commands reversed
+// because the journal couldn't record them, did not run.
+func (b *BankingController) execute(actor Actor, cmd BankingCommand) (ran bool, err error) {
+	fmt.Printf("→ Executing: %s (as %s)\n", cmd.GetDescription(), actor.ID)
+	if err := b.authorize(actor, ActionExecute, cmd, actor.ID); err != nil {
+		return false, fmt.Errorf("execute %q: %w", cmd.GetDescription(), err)
+	}
+	if b.journal != nil {
+		if journaled, ok := cmd.(JournaledCommand); !ok || journaled.JournalOps() == nil {
+			return false, fmt.Errorf("execute %q: %w", cmd.GetDescription(), ErrNotJournaled)
+		}
+	}
+	if err := cmd.Execute(); err != nil {
+		return true, fmt.Errorf("execute %q: %w", cmd.GetDescription(), err)
+	}
+	if err := b.writeJournal(JournalExecute, cmd); err != nil {
+		// Keep memory and disk in agreement: an unjournaled command didn't happen
+		return false, errors.Join(fmt.Errorf("journal %q: %w", cmd.GetDescription(), err), cmd.Undo())
+	}
+	b.lastSeq++
+	b.history = append(b.history, historyRecord{seq: b.lastSeq, cmd: cmd, executedAt: b.now(), actor: actor.ID})
+	b.redo = b.redo[:0]
+	return true, nil
 }
 
-func (b *BankingController) ExecuteCommand(cmd BankingCommand) {
-	fmt.Printf("→ Executing: %s\n", cmd.GetDescription())
-	cmd.Execute()
-	b.history = append(b.history, cmd)
+// UndoLast undoes the most recent command as SystemActor; a command that fails to undo stays in the history
+func (b *BankingController) UndoLast() error {
+	return b.UndoLastAs(SystemActor)
 }
 
-func (b *BankingController) UndoLast() {
+// UndoLastAs undoes the most recent command on behalf of an actor, subject to the authorization policy
+func (b *BankingController) UndoLastAs(actor Actor) error {
 	if len(b.history) == 0 {
-		fmt.Println("  Nothing to undo")
-		return
+		return ErrNothingToUndo
 	}
 
-	cmd := b.history[len(b.history)-1]
+	record := b.history[len(b.history)-1]
+	fmt.Printf("→ Undoing: %s (as %s)\n", record.cmd.GetDescription(), actor.ID)
+	if err := b.authorize(actor, ActionUndo, record.cmd, record.owner()); err != nil {
+		return fmt.Errorf("undo %q: %w", record.cmd.GetDescription(), err)
+	}
+	if err := record.cmd.Undo(); err != nil {
+		return fmt.Errorf("undo %q: %w", record.cmd.GetDescription(), err)
+	}
+	if err := b.writeJournal(JournalUndo, record.cmd); err != nil {
+		return errors.Join(fmt.Errorf("journal undo %q: %w", record.cmd.GetDescription(), err), record.cmd.Execute())
+	}
 	b.history = b.history[:len(b.history)-1]
+	b.redo = append(b.redo, record)
+	return nil
+}
+
+// Redo re-executes the most recently undone command as SystemActor
+func (b *BankingController) Redo() error {
+	return b.RedoAs(SystemActor)
+}
+
+// RedoAs re-executes the most recently undone command on behalf of an actor.
+// Redo is authorized as an execute, and the command is then owned by that actor.
+func (b *BankingController) RedoAs(actor Actor) error {
+	if len(b.redo) == 0 {
+		return ErrNothingToRedo
+	}
+
+	record := b.redo[len(b.redo)-1]
+	fmt.Printf("→ Redoing: %s (as %s)\n", record.cmd.GetDescription(), actor.ID)
+	if err := b.authorize(actor, ActionExecute, record.cmd, actor.ID); err != nil {
+		return fmt.Errorf("redo %q: %w", record.cmd.GetDescription(), err)
+	}
+	if err := record.cmd.Execute(); err != nil {
+		return fmt.Errorf("redo %q: %w", record.cmd.GetDescription(), err)
+	}
+	if err := b.writeJournal(JournalRedo, record.cmd); err != nil {
+		return errors.Join(fmt.Errorf("journal redo %q: %w", record.cmd.GetDescription(), err), record.cmd.Undo())
+	}
+	b.redo = b.redo[:len(b.redo)-1]
+	record.redoneAt = b.now()
+	record.redoneBy = actor.ID
+	b.history = append(b.history, record)
+	return nil
+}
+
+// UndoTo undoes commands as SystemActor until seq is the latest applied one; seq 0 undoes everything
+func (b *BankingController) UndoTo(seq int) error {
+	return b.UndoToAs(SystemActor, seq)
+}
+
+// UndoToAs is UndoTo on behalf of an actor; each undo is authorized separately
+func (b *BankingController) UndoToAs(actor Actor, seq int) error {
+	if seq != 0 && !b.applied(seq) {
+		return fmt.Errorf("undo to #%d: %w", seq, ErrUnknownHistory)
+	}
+	for len(b.history) > 0 && b.history[len(b.history)-1].seq > seq {
+		if err := b.UndoLastAs(actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RedoTo redoes commands as SystemActor until seq has been re-applied
+func (b *BankingController) RedoTo(seq int) error {
+	return b.RedoToAs(SystemActor, seq)
+}
+
+// RedoToAs is RedoTo on behalf of an actor; each redo is authorized separately
+func (b *BankingController) RedoToAs(actor Actor, seq int) error {
+	if !b.undone(seq) {
+		return fmt.Errorf("redo to #%d: %w", seq, ErrUnknownHistory)
+	}
+	for b.undone(seq) {
+		if err := b.RedoAs(actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BankingController) applied(seq int) bool {
+	for _, record := range b.history {
+		if record.seq == seq {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *BankingController) undone(seq int) bool {
+	for _, record := range b.redo {
+		if record.seq == seq {
+			return true
+		}
+	}
+	return false
+}
+
+// History lists applied commands oldest first, followed by the redo branch in the
+// order it would be redone
+func (b *BankingController) History() []HistoryEntry {
+	entries := make([]HistoryEntry, 0, len(b.history)+len(b.redo)+len(b.denied))
+	for _, record := range b.history {
+		entries = append(entries, record.entry())
+	}
+	for i := len(b.redo) - 1; i >= 0; i-- {
+		record := b.redo[i]
+		entry := record.entry()
+		entry.Undone = true
+		entries = append(entries, entry)
+	}
+	// Denied attempts are interleaved by sequence number; applied entries always
+	// precede the redo branch, so sorting keeps that order too
+	entries = append(entries, b.denied...)
+	slices.SortFunc(entries, func(x, y HistoryEntry) int { return x.Seq - y.Seq })
+	return entries
+}
+
+// --- Command Journal ---
+
+// OpKind is a primitive balance change recorded in the journal
+type OpKind string
+
+const (
+	OpDeposit  OpKind = "deposit"
+	OpWithdraw OpKind = "withdraw"
+	OpTransfer OpKind = "transfer"
+)
+
+// JournalOp is one balance change; for transfers Account is the source
+type JournalOp struct {
+	Op      OpKind  `json:"op"`
+	Account string  `json:"account"`
+	To      string  `json:"to,omitempty"`
+	Amount  float64 `json:"amount"`
+}
+
+// JournaledCommand is implemented by commands whose effect can be written to the journal
+type JournaledCommand interface {
+	BankingCommand
+	JournalOps() []JournalOp
+}
+
+// InvertOps returns the ops that reverse the given ones, in reverse order
+func InvertOps(ops []JournalOp) []JournalOp {
+	inverted := make([]JournalOp, 0, len(ops))
+	for i := len(ops) - 1; i >= 0; i-- {
+		op := ops[i]
+		switch op.Op {
+		case OpDeposit:
+			op.Op = OpWithdraw
+		case OpWithdraw:
+			op.Op = OpDeposit
+		case OpTransfer:
+			op.Account, op.To = op.To, op.Account
+		}
+		inverted = append(inverted, op)
+	}
+	return inverted
+}
+
+// JournalKind says what the controller did with a command
+type JournalKind string
+
+const (
+	JournalExecute JournalKind = "execute"
+	JournalUndo    JournalKind = "undo"
+	JournalRedo    JournalKind = "redo"
+)
+
+// JournalRecord is one line of the journal. Ops already hold the net effect,
+// so an undo record contains the inverted ops of the command it undid.
+type JournalRecord struct {
+	Seq         uint64      `json:"seq"`
+	Kind        JournalKind `json:"kind"`
+	Description string      `json:"description"`
+	Ops         []JournalOp `json:"ops"`
+	At          time.Time   `json:"at"`
+}
+
+// Journal is an append-only file of JournalRecords. Each line is the CRC-32 of
+// the record's JSON in hex, a space, and the JSON itself.
+type Journal struct {
+	file    *os.File
+	lastSeq uint64
+}
+
+// OpenJournal opens or creates a journal. A torn final record left by a crash
+// (missing newline, bad checksum or unparsable JSON) is dropped and truncated away.
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	records, validSize, err := readJournal(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("open journal %s: %w", path, err)
+	}
+	if err := file.Truncate(validSize); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("truncate torn journal record: %w", err)
+	}
+	if _, err := file.Seek(validSize, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	journal := &Journal{file: file}
+	if len(records) > 0 {
+		journal.lastSeq = records[len(records)-1].Seq
+	}
+	return journal, nil
+}
+
+// Append writes a record with the next sequence number and syncs it to disk
+func (j *Journal) Append(kind JournalKind, description string, ops []JournalOp) (JournalRecord, error) {
+	record := JournalRecord{Seq: j.lastSeq + 1, Kind: kind, Description: description, Ops: ops, At: time.Now().UTC()}
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return JournalRecord{}, err
+	}
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(payload), payload)
+	if _, err := j.file.WriteString(line); err != nil {
+		return JournalRecord{}, fmt.Errorf("append journal record: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return JournalRecord{}, fmt.Errorf("sync journal: %w", err)
+	}
+	j.lastSeq = record.Seq
+	return record, nil
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// ReadJournal returns every intact record in a journal file, ignoring a torn final record
+func ReadJournal(path string) ([]JournalRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+	defer file.Close()
+	records, _, err := readJournal(file)
+	return records, err
+}
+
+// readJournal parses records from the start of r and returns them together with the
+// byte length of the intact prefix. Only the final record may be damaged; damage
+// anywhere earlier, or a gap in sequence numbers, is reported as ErrJournalCorrupt.
+func readJournal(r io.Reader) ([]JournalRecord, int64, error) {
+	var records []JournalRecord
+	var validSize int64
+	reader := bufio.NewReader(r)
+	for lineNo := 1; ; lineNo++ {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) == 0 && readErr == io.EOF {
+			return records, validSize, nil
+		}
+		if readErr != nil && readErr != io.EOF {
+			return nil, 0, readErr
+		}
+
+		record, parseErr := parseJournalLine(line)
+		if parseErr == nil && record.Seq != uint64(len(records))+1 {
+			parseErr = fmt.Errorf("expected seq %d, found %d", len(records)+1, record.Seq)
+		}
+		if parseErr != nil {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				return records, validSize, nil // torn final record
+			}
+			return nil, 0, fmt.Errorf("line %d: %v: %w", lineNo, parseErr, ErrJournalCorrupt)
+		}
+		records = append(records, record)
+		validSize += int64(len(line))
+	}
+}
+
+func parseJournalLine(line []byte) (JournalRecord, error) {
+	var record JournalRecord
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		return record, errors.New("record is not terminated")
+	}
+	checksum, payload, ok := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !ok {
+		return record, errors.New("missing checksum")
+	}
+	if fmt.Sprintf("%08x", crc32.ChecksumIEEE(payload)) != string(checksum) {
+		return record, errors.New("checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return record, err
+	}
+	return record, nil
+}
+
+// ReplayJournal rebuilds balances by applying every intact record, in order, to
+// accounts holding their opening balances. Replay stops at the first op that
+// names an unknown account or would overdraw one. Records are applied to
+// copies of the accounts, so on error the 0 returned is accurate: the
+// accounts keep their opening balances.
+func ReplayJournal(path string, accounts map[string]*Account) (int, error) {
+	records, err := ReadJournal(path)
+	if err != nil {
+		return 0, err
+	}
+	// copy by pointer so an account listed under two IDs stays one account
+	copies := make(map[*Account]*Account, len(accounts))
+	scratch := make(map[string]*Account, len(accounts))
+	for id, account := range accounts {
+		if _, ok := copies[account]; !ok {
+			copies[account] = &Account{accountID: account.accountID, balance: account.GetBalance()}
+		}
+		scratch[id] = copies[account]
+	}
+	for _, record := range records {
+		for _, op := range record.Ops {
+			if err := applyJournalOp(op, scratch); err != nil {
+				return 0, fmt.Errorf("replay record %d (%s): %w", record.Seq, record.Description, err)
+			}
+		}
+	}
+	for account, replayed := range copies {
+		account.mu.Lock()
+		account.balance = replayed.balance
+		account.mu.Unlock()
+	}
+	return len(records), nil
+}
+
+func applyJournalOp(op JournalOp, accounts map[string]*Account) error {
+	account, ok := accounts[op.Account]
+	if !ok {
+		return fmt.Errorf("unknown account %s", op.Account)
+	}
+	switch op.Op {
+	case OpDeposit:
+		account.mu.Lock()
+		defer account.mu.Unlock()
+		account.balance += op.Amount
+	case OpWithdraw:
+		account.mu.Lock()
+		defer account.mu.Unlock()
+		if account.balance < op.Amount {
+			return fmt.Errorf("account %s: %w", account.accountID, ErrInsufficientFunds)
+		}
+		account.balance -= op.Amount
+	case OpTransfer:
+		to, ok := accounts[op.To]
+		if !ok {
+			return fmt.Errorf("unknown account %s", op.To)
+		}
+		if to == account {
+			return fmt.Errorf("account %s: %w", account.accountID, ErrSameAccount)
+		}
+		unlock := lockPair(account, to)
+		defer unlock()
+		if account.balance < op.Amount {
+			return fmt.Errorf("account %s: %w", account.accountID, ErrInsufficientFunds)
+		}
+		account.balance -= op.Amount
+		to.balance += op.Amount
+	default:
+		return fmt.Errorf("unknown op %q", op.Op)
+	}
+	return nil
+}
+
+// --- Command Serialization ---
+
+// Command types understood by the built-in registry decoders
+const (
+	CommandTypeDeposit  = "deposit"
+	CommandTypeWithdraw = "withdraw"
+	CommandTypeTransfer = "transfer"
+	CommandTypeMacro    = "macro"
+)
+
+// CommandEnvelope is the wire form of a BankingCommand. Payloads reference
+// accounts by ID, so envelopes can be queued, stored or sent to another process.
+type CommandEnvelope struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type AccountAmountPayload struct {
+	Account string  `json:"account"`
+	Amount  float64 `json:"amount"`
+}
+
+type TransferPayload struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+}
+
+type MacroPayload struct {
+	Description string            `json:"description"`
+	Commands    []CommandEnvelope `json:"commands"`
+}
+
+func newEnvelope(commandType string, payload any) (CommandEnvelope, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return CommandEnvelope{}, err
+	}
+	return CommandEnvelope{Type: commandType, Payload: data}, nil
+}
+
+// SerializableCommand is implemented by commands that can be turned into an envelope
+type SerializableCommand interface {
+	BankingCommand
+	Envelope() (CommandEnvelope, error)
+}
+
+// MarshalCommand encodes a command as JSON
+func MarshalCommand(cmd BankingCommand) ([]byte, error) {
+	serializable, ok := cmd.(SerializableCommand)
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", cmd.GetDescription(), ErrNotSerializable)
+	}
+	envelope, err := serializable.Envelope()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope)
+}
+
+// AccountRepository looks up the accounts that envelopes refer to
+type AccountRepository interface {
+	FindAccount(accountID string) (*Account, error)
+}
+
+type InMemoryAccountRepository struct {
+	accounts map[string]*Account
+}
+
+func NewInMemoryAccountRepository(accounts ...*Account) *InMemoryAccountRepository {
+	repo := &InMemoryAccountRepository{accounts: make(map[string]*Account, len(accounts))}
+	for _, account := range accounts {
+		repo.accounts[account.accountID] = account
+	}
+	return repo
+}
+
+func (r *InMemoryAccountRepository) FindAccount(accountID string) (*Account, error) {
+	account, ok := r.accounts[accountID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", accountID, ErrAccountNotFound)
+	}
+	return account, nil
+}
+
+// CommandDecoder turns an envelope payload back into a command. It gets the
+// registry so it can resolve accounts and decode nested envelopes.
+type CommandDecoder func(payload json.RawMessage, registry *CommandRegistry) (BankingCommand, error)
+
+// CommandRegistry maps envelope types to decoders and binds decoded commands to
+// an account repository and transfer service
+type CommandRegistry struct {
+	decoders        map[string]CommandDecoder
+	accounts        AccountRepository
+	transferService *TransferService
+}
+
+// NewCommandRegistry returns a registry with the built-in command types registered
+func NewCommandRegistry(accounts AccountRepository, transferService *TransferService) *CommandRegistry {
+	registry := &CommandRegistry{
+		decoders:        make(map[string]CommandDecoder),
+		accounts:        accounts,
+		transferService: transferService,
+	}
+	builtins := []struct {
+		commandType string
+		decoder     CommandDecoder
+	}{
+		{CommandTypeDeposit, decodeDeposit},
+		{CommandTypeWithdraw, decodeWithdraw},
+		{CommandTypeTransfer, decodeTransfer},
+		{CommandTypeMacro, decodeMacro},
+	}
+	for _, builtin := range builtins {
+		// A clash between built-ins is a programming error, not something a caller can handle
+		if err := registry.Register(builtin.commandType, builtin.decoder); err != nil {
+			panic(err)
+		}
+	}
+	return registry
+}
+
+// Register adds a decoder for a new command type
+func (r *CommandRegistry) Register(commandType string, decoder CommandDecoder) error {
+	if _, exists := r.decoders[commandType]; exists {
+		return fmt.Errorf("command type %q is already registered", commandType)
+	}
+	r.decoders[commandType] = decoder
+	return nil
+}
+
+// Accounts and TransferService give custom decoders what the built-in ones use
+func (r *CommandRegistry) Accounts() AccountRepository {
+	return r.accounts
+}
+
+func (r *CommandRegistry) TransferService() *TransferService {
+	return r.transferService
+}
+
+// Decode builds a command from an envelope
+func (r *CommandRegistry) Decode(envelope CommandEnvelope) (BankingCommand, error) {
+	decoder, ok := r.decoders[envelope.Type]
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", envelope.Type, ErrUnknownCommand)
+	}
+	cmd, err := decoder(envelope.Payload, r)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s command: %w", envelope.Type, err)
+	}
+	return cmd, nil
+}
+
+// UnmarshalCommand decodes a JSON envelope into a command
+func (r *CommandRegistry) UnmarshalCommand(data []byte) (BankingCommand, error) {
+	var envelope CommandEnvelope
+	if err := decodePayload(data, &envelope); err != nil {
+		return nil, fmt.Errorf("parse command envelope: %w", err)
+	}
+	return r.Decode(envelope)
+}
+
+// decodePayload decodes exactly one JSON value into target, rejecting unknown
+// fields and anything after the value
+func decodePayload(payload json.RawMessage, target any) error {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return err
+	}
+	if err := decoder.Decode(&json.RawMessage{}); err != io.EOF {
+		return errors.New("unexpected data after the JSON value")
+	}
+	return nil
+}
+
+func checkAmount(amount float64) error {
+	if amount <= 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return fmt.Errorf("invalid amount %v", amount)
+	}
+	return nil
+}
+
+func decodeAccountAmount(payload json.RawMessage, registry *CommandRegistry) (*Account, float64, error) {
+	var p AccountAmountPayload
+	if err := decodePayload(payload, &p); err != nil {
+		return nil, 0, err
+	}
+	if err := checkAmount(p.Amount); err != nil {
+		return nil, 0, err
+	}
+	account, err := registry.accounts.FindAccount(p.Account)
+	return account, p.Amount, err
+}
+
+func decodeDeposit(payload json.RawMessage, registry *CommandRegistry) (BankingCommand, error) {
+	account, amount, err := decodeAccountAmount(payload, registry)
+	if err != nil {
+		return nil, err
+	}
+	return &DepositCommand{account: account, amount: amount}, nil
+}
+
+func decodeWithdraw(payload json.RawMessage, registry *CommandRegistry) (BankingCommand, error) {
+	account, amount, err := decodeAccountAmount(payload, registry)
+	if err != nil {
+		return nil, err
+	}
+	return &WithdrawCommand{account: account, amount: amount}, nil
+}
+
+func decodeTransfer(payload json.RawMessage, registry *CommandRegistry) (BankingCommand, error) {
+	var p TransferPayload
+	if err := decodePayload(payload, &p); err != nil {
+		return nil, err
+	}
+	if err := checkAmount(p.Amount); err != nil {
+		return nil, err
+	}
+	from, err := registry.accounts.FindAccount(p.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := registry.accounts.FindAccount(p.To)
+	if err != nil {
+		return nil, err
+	}
+	return &TransferCommand{transferService: registry.transferService, from: from, to: to, amount: p.Amount}, nil
+}
+
+func decodeMacro(payload json.RawMessage, registry *CommandRegistry) (BankingCommand, error) {
+	var p MacroPayload
+	if err := decodePayload(payload, &p); err != nil {
+		return nil, err
+	}
+	commands := make([]BankingCommand, 0, len(p.Commands))
+	for i, envelope := range p.Commands {
+		cmd, err := registry.Decode(envelope)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		commands = append(commands, cmd)
+	}
+	return NewMacroCommand(p.Description, commands), nil
+}
+
+// --- Scheduled and Recurring Commands ---
+
+// Clock abstracts time so schedules can be tested deterministically
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// ManualClock is a Clock that only moves when told to
+type ManualClock struct {
+	now time.Time
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time { return c.now }
+
+func (c *ManualClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// Recurrence computes the next occurrence strictly after a given one, keeping its time of day
+type Recurrence interface {
+	Next(after time.Time) time.Time
+	String() string
+}
+
+type Daily struct{}
+
+func (Daily) Next(after time.Time) time.Time { return after.AddDate(0, 0, 1) }
+func (Daily) String() string                 { return "daily" }
+
+type Weekly struct{}
+
+func (Weekly) Next(after time.Time) time.Time { return after.AddDate(0, 0, 7) }
+func (Weekly) String() string                 { return "weekly" }
 
-	fmt.Printf("→ Undoing: %s\n", cmd.GetDescription())
-	cmd.Undo()
+// MonthlyOnDay runs on day N of every month, or on the month's last day when it
+// is shorter (day 31 runs on 30 April and on 28 or 29 February)
+type MonthlyOnDay struct {
+	Day int
+}
+
+func (r MonthlyOnDay) Next(after time.Time) time.Time {
+	for months := 0; ; months++ {
+		year, month := addMonths(after, months)
+		day := min(r.Day, daysIn(year, month))
+		candidate := time.Date(year, month, day, after.Hour(), after.Minute(), after.Second(), 0, after.Location())
+		if candidate.After(after) {
+			return candidate
+		}
+	}
+}
+
+func (r MonthlyOnDay) String() string { return fmt.Sprintf("monthly on day %d", r.Day) }
+
+// Validate rejects days no month has
+func (r MonthlyOnDay) Validate() error {
+	if r.Day < 1 || r.Day > 31 {
+		return fmt.Errorf("monthly on day %d: day must be between 1 and 31: %w", r.Day, ErrInvalidSchedule)
+	}
+	return nil
+}
+
+// LastBusinessDay runs on the last Monday-to-Friday of every month
+type LastBusinessDay struct{}
+
+func (LastBusinessDay) Next(after time.Time) time.Time {
+	for months := 0; ; months++ {
+		year, month := addMonths(after, months)
+		candidate := time.Date(year, month, daysIn(year, month), after.Hour(), after.Minute(), after.Second(), 0, after.Location())
+		for candidate.Weekday() == time.Saturday || candidate.Weekday() == time.Sunday {
+			candidate = candidate.AddDate(0, 0, -1)
+		}
+		if candidate.After(after) {
+			return candidate
+		}
+	}
+}
+
+func (LastBusinessDay) String() string { return "last business day of the month" }
+
+func addMonths(t time.Time, months int) (int, time.Month) {
+	first := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()).AddDate(0, months, 0)
+	return first.Year(), first.Month()
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// FailurePolicy says how often a failed run is retried before the occurrence is skipped.
+// The zero value skips straight away.
+type FailurePolicy struct {
+	MaxRetries int
+	RetryDelay time.Duration
+}
+
+// ScheduledCommand is a one-off or recurring command. Build is called for every run
+// so each execution gets its own command value in the controller's history.
+type ScheduledCommand struct {
+	ID         string
+	Build      func() BankingCommand
+	NextRun    time.Time
+	Recurrence Recurrence // nil for a one-off command
+	Policy     FailurePolicy
+	Actor      Actor // whom every run is executed and authorized as
+
+	dueAt    time.Time // occurrence being attempted; differs from NextRun while retrying
+	attempts int
+}
+
+// RunStatus is what happened to one scheduled run
+type RunStatus string
+
+const (
+	RunExecuted RunStatus = "executed"
+	RunRetrying RunStatus = "retry scheduled"
+	RunSkipped  RunStatus = "skipped"
+)
+
+type RunResult struct {
+	ScheduleID string
+	DueAt      time.Time
+	Attempt    int
+	Status     RunStatus
+	Err        error
+}
+
+// Scheduler holds scheduled commands and runs them through a BankingController when due
+type Scheduler struct {
+	controller *BankingController
+	clock      Clock
+	schedules  []*ScheduledCommand
+}
+
+func NewScheduler(controller *BankingController, clock Clock) *Scheduler {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	return &Scheduler{controller: controller, clock: clock}
+}
+
+// ScheduleOnce runs a command once at the given time as SystemActor
+func (s *Scheduler) ScheduleOnce(id string, at time.Time, build func() BankingCommand, policy FailurePolicy) error {
+	return s.ScheduleOnceAs(SystemActor, id, at, build, policy)
+}
+
+// ScheduleOnceAs is ScheduleOnce on behalf of an actor
+func (s *Scheduler) ScheduleOnceAs(actor Actor, id string, at time.Time, build func() BankingCommand, policy FailurePolicy) error {
+	return s.add(&ScheduledCommand{ID: id, Build: build, NextRun: at, Policy: policy, Actor: actor})
+}
+
+// ScheduleRecurring runs a command as SystemActor at first and then at every occurrence of the recurrence
+func (s *Scheduler) ScheduleRecurring(id string, first time.Time, recurrence Recurrence, build func() BankingCommand, policy FailurePolicy) error {
+	return s.ScheduleRecurringAs(SystemActor, id, first, recurrence, build, policy)
+}
+
+// ScheduleRecurringAs is ScheduleRecurring on behalf of an actor
+func (s *Scheduler) ScheduleRecurringAs(actor Actor, id string, first time.Time, recurrence Recurrence, build func() BankingCommand, policy FailurePolicy) error {
+	return s.add(&ScheduledCommand{ID: id, Build: build, NextRun: first, Recurrence: recurrence, Policy: policy, Actor: actor})
+}
+
+func (s *Scheduler) add(schedule *ScheduledCommand) error {
+	if validator, ok := schedule.Recurrence.(interface{ Validate() error }); ok {
+		if err := validator.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", schedule.ID, err)
+		}
+	}
+	for _, existing := range s.schedules {
+		if existing.ID == schedule.ID {
+			return fmt.Errorf("%s: %w", schedule.ID, ErrDuplicateSchedule)
+		}
+	}
+	schedule.dueAt = schedule.NextRun
+	s.schedules = append(s.schedules, schedule)
+	return nil
+}
+
+// Cancel removes a schedule; it reports whether the schedule existed
+func (s *Scheduler) Cancel(id string) bool {
+	for i, schedule := range s.schedules {
+		if schedule.ID == id {
+			s.schedules = append(s.schedules[:i], s.schedules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// RunDue executes every run that is due at the clock's current time, in due-time
+// order. Occurrences missed while the scheduler wasn't running are caught up.
+func (s *Scheduler) RunDue() []RunResult {
+	now := s.clock.Now()
+	var results []RunResult
+	for {
+		schedule := s.nextDue(now)
+		if schedule == nil {
+			return results
+		}
+		results = append(results, s.run(schedule, now))
+	}
+}
+
+func (s *Scheduler) nextDue(now time.Time) *ScheduledCommand {
+	var due *ScheduledCommand
+	for _, schedule := range s.schedules {
+		if !schedule.NextRun.After(now) && (due == nil || schedule.NextRun.Before(due.NextRun)) {
+			due = schedule
+		}
+	}
+	return due
+}
+
+func (s *Scheduler) run(schedule *ScheduledCommand, now time.Time) RunResult {
+	schedule.attempts++
+	result := RunResult{ScheduleID: schedule.ID, DueAt: schedule.dueAt, Attempt: schedule.attempts, Status: RunExecuted}
+	result.Err = s.controller.ExecuteAs(schedule.Actor, schedule.Build())
+
+	if result.Err != nil && schedule.attempts <= schedule.Policy.MaxRetries {
+		result.Status = RunRetrying
+		schedule.NextRun = now.Add(schedule.Policy.RetryDelay)
+		return result
+	}
+	if result.Err != nil {
+		result.Status = RunSkipped
+	}
+
+	schedule.attempts = 0
+	if schedule.Recurrence == nil {
+		s.Cancel(schedule.ID)
+		return result
+	}
+	schedule.dueAt = schedule.Recurrence.Next(schedule.dueAt)
+	schedule.NextRun = schedule.dueAt
+	return result
+}
+
+// Pending lists schedules ordered by their next run
+func (s *Scheduler) Pending() []ScheduledCommand {
+	pending := make([]ScheduledCommand, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		pending = append(pending, *schedule)
+	}
+	slices.SortFunc(pending, func(a, b ScheduledCommand) int {
+		return a.NextRun.Compare(b.NextRun)
+	})
+	return pending
+}
+
+// --- Idempotent Execution ---
+
+// idempotencyKey scopes a client's key to the actor who used it
+type idempotencyKey struct {
+	actor string
+	key   string
+}
+
+// ExecutionOutcome is the stored result of executing a command under an idempotency key
+type ExecutionOutcome struct {
+	Key         string
+	Actor       string // who executed the command; only this actor's retries replay it
+	Seq         int    // history sequence number, 0 if the command failed
+	Description string
+	ExecutedAt  time.Time
+	Err         error
+	Replayed    bool // true when the outcome came from an earlier call with the same key
+
+	fingerprint string
+}
+
+// SetIdempotencyRetention changes how long outcomes are remembered
+func (b *BankingController) SetIdempotencyRetention(retention time.Duration) {
+	b.idempotencyRetention = retention
+}
+
+// ExecuteIdempotent executes cmd as SystemActor at most once per key within the retention window.
+// A retry with the same key and the same command returns the stored outcome without
+// executing again, including a stored failure of the command itself, such as
+// ErrInsufficientFunds. Attempts that never ran, because they were refused or
+// the journal couldn't record them, are not stored, so a retry can still succeed.
+// Reusing a key for a different command fails with ErrKeyConflict. Commands are
+// compared by their serialized envelope, or by description for commands that
+// can't be serialized.
+func (b *BankingController) ExecuteIdempotent(key string, cmd BankingCommand) (ExecutionOutcome, error) {
+	return b.ExecuteIdempotentAs(SystemActor, key, cmd)
+}
+
+// ExecuteIdempotentAs is ExecuteIdempotent on behalf of an actor. Keys are
+// scoped to the actor: another actor using the same key neither sees this
+// actor's outcome nor conflicts with it. A replayed outcome was authorized for
+// this actor when it ran, so it is returned without asking the policy again.
+func (b *BankingController) ExecuteIdempotentAs(actor Actor, key string, cmd BankingCommand) (ExecutionOutcome, error) {
+	now := b.now()
+	for storedKey, outcome := range b.idempotency {
+		if now.Sub(outcome.ExecutedAt) >= b.idempotencyRetention {
+			delete(b.idempotency, storedKey)
+		}
+	}
+
+	scoped := idempotencyKey{actor: actor.ID, key: key}
+	fingerprint := commandFingerprint(cmd)
+	if stored, ok := b.idempotency[scoped]; ok {
+		if stored.fingerprint != fingerprint {
+			return ExecutionOutcome{}, fmt.Errorf("key %q: %w", key, ErrKeyConflict)
+		}
+		stored.Replayed = true
+		fmt.Printf("→ Replaying stored outcome for key %s: %s\n", key, stored.Description)
+		return stored, stored.Err
+	}
+
+	outcome := ExecutionOutcome{Key: key, Actor: actor.ID, Description: cmd.GetDescription(), ExecutedAt: now, fingerprint: fingerprint}
+	ran, err := b.execute(actor, cmd)
+	outcome.Err = err
+	if err == nil {
+		outcome.Seq = b.lastSeq
+	}
+	if ran {
+		b.idempotency[scoped] = outcome
+	}
+	return outcome, outcome.Err
+}
+
+func commandFingerprint(cmd BankingCommand) string {
+	data, err := MarshalCommand(cmd)
+	if err != nil {
+		data = []byte(cmd.GetDescription())
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// --- Authorization ---
+
+// Actor is the identity a command is executed or undone on behalf of
+type Actor struct {
+	ID    string
+	Roles []string
+}
+
+// SystemActor is used by the methods without an As suffix, such as ExecuteCommand, UndoLast and Redo
+var SystemActor = Actor{ID: "system", Roles: []string{"system"}}
+
+func (a Actor) HasRole(role string) bool {
+	return slices.Contains(a.Roles, role)
+}
+
+// Action is what an actor wants to do with a command
+type Action string
+
+const (
+	ActionExecute Action = "execute"
+	ActionUndo    Action = "undo"
+)
+
+// AuthorizableCommand lets a command type the controller doesn't know describe
+// itself to the authorization policy. Commands that neither are built in nor
+// implement it are refused whenever a policy is set.
+type AuthorizableCommand interface {
+	CommandType() string
+	Amount() float64
+}
+
+// AuthorizationRequest describes one attempt for the policy to decide on
+type AuthorizationRequest struct {
+	Actor       Actor
+	Action      Action
+	CommandType string  // one of the CommandType constants, or an AuthorizableCommand's type
+	Amount      float64 // for macros, the sum of the steps' amounts; each step is also checked on its own
+	Owner       string  // actor who executed the command; for execute, the actor itself
+}
+
+// AuthorizationPolicy decides whether an attempt is allowed; a nil error means yes
+type AuthorizationPolicy interface {
+	Authorize(request AuthorizationRequest) error
+}
+
+// SetAuthorization makes the controller check every execute, undo and redo against the policy
+func (b *BankingController) SetAuthorization(policy AuthorizationPolicy) {
+	b.policy = policy
+}
+
+// authorize asks the policy and records refused attempts in the history
+func (b *BankingController) authorize(actor Actor, action Action, cmd BankingCommand, owner string) error {
+	if b.policy == nil {
+		return nil
+	}
+	requests, err := authorizationRequests(actor, action, cmd, owner)
+	for _, request := range requests {
+		if err = b.policy.Authorize(request); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	b.lastSeq++
+	b.denied = append(b.denied, HistoryEntry{
+		Seq:         b.lastSeq,
+		Description: cmd.GetDescription(),
+		ExecutedAt:  b.now(),
+		Actor:       actor.ID,
+		Denied:      true,
+		Action:      action,
+		Reason:      err.Error(),
+	})
+	return err
+}
+
+// authorizationRequests lists everything the policy must allow for cmd: the
+// command itself and, for a macro, every step under its own type and amount, so
+// a role that may run macros can't use one to run steps it couldn't run alone
+func authorizationRequests(actor Actor, action Action, cmd BankingCommand, owner string) ([]AuthorizationRequest, error) {
+	commandType, amount, ok := commandTypeAndAmount(cmd)
+	if !ok {
+		return nil, fmt.Errorf("%s may not %s a %s: %w: %w", actor.ID, action, commandType, ErrUnknownCommand, ErrUnauthorized)
+	}
+	requests := []AuthorizationRequest{{Actor: actor, Action: action, CommandType: commandType, Amount: amount, Owner: owner}}
+	if macro, ok := cmd.(*MacroCommand); ok {
+		for _, step := range macro.commands {
+			stepRequests, err := authorizationRequests(actor, action, step, owner)
+			if err != nil {
+				return nil, err
+			}
+			requests = append(requests, stepRequests...)
+		}
+	}
+	return requests, nil
+}
+
+// commandTypeAndAmount reports false for commands whose amount can't be known,
+// including macros with such a step, so a policy never sees them as $0
+func commandTypeAndAmount(cmd BankingCommand) (string, float64, bool) {
+	switch c := cmd.(type) {
+	case *DepositCommand:
+		return CommandTypeDeposit, c.amount, true
+	case *WithdrawCommand:
+		return CommandTypeWithdraw, c.amount, true
+	case *TransferCommand:
+		return CommandTypeTransfer, c.amount, true
+	case *MacroCommand:
+		total := 0.0
+		for _, step := range c.commands {
+			_, amount, ok := commandTypeAndAmount(step)
+			if !ok {
+				return CommandTypeMacro, 0, false
+			}
+			total += amount
+		}
+		return CommandTypeMacro, total, true
+	case AuthorizableCommand:
+		return c.CommandType(), c.Amount(), true
+	default:
+		return fmt.Sprintf("%T", cmd), 0, false
+	}
+}
+
+// Permission grants a role an action on a command type. "*" matches every type,
+// a zero MaxAmount means no limit, and OthersToo lets the role undo commands
+// that another actor executed.
+type Permission struct {
+	Role        string
+	Action      Action
+	CommandType string
+	MaxAmount   float64
+	OthersToo   bool
+}
+
+// RolePolicy allows an attempt when any of the actor's roles has a matching permission
+type RolePolicy struct {
+	permissions []Permission
+}
+
+func NewRolePolicy(permissions ...Permission) *RolePolicy {
+	return &RolePolicy{permissions: permissions}
+}
+
+func (p *RolePolicy) Authorize(request AuthorizationRequest) error {
+	for _, permission := range p.permissions {
+		if !request.Actor.HasRole(permission.Role) || permission.Action != request.Action {
+			continue
+		}
+		if permission.CommandType != "*" && permission.CommandType != request.CommandType {
+			continue
+		}
+		if permission.MaxAmount > 0 && request.Amount > permission.MaxAmount {
+			continue
+		}
+		if request.Action == ActionUndo && request.Owner != request.Actor.ID && !permission.OthersToo {
+			continue
+		}
+		return nil
+	}
+	if request.Action == ActionUndo && request.Owner != request.Actor.ID {
+		return fmt.Errorf("%s may not undo %s's %s of $%.2f: %w",
+			request.Actor.ID, request.Owner, request.CommandType, request.Amount, ErrUnauthorized)
+	}
+	return fmt.Errorf("%s may not %s a %s of $%.2f: %w",
+		request.Actor.ID, request.Action, request.CommandType, request.Amount, ErrUnauthorized)
+}
+
+func printHistory(controller *BankingController) {
+	fmt.Println("  History:")
+	for _, entry := range controller.History() {
+		state := "applied"
+		switch {
+		case entry.Denied:
+			state = "denied "
+		case entry.Undone:
+			state = "undone "
+		}
+		fmt.Printf("    #%d [%s] %s  %-8s %s\n", entry.Seq, state, entry.ExecutedAt.Format("15:04:05.000"), entry.Actor, entry.Description)
+		if entry.Denied {
+			fmt.Printf("        %s\n", entry.Reason)
+		}
+		if !entry.RedoneAt.IsZero() {
+			fmt.Printf("        redone %s by %s\n", entry.RedoneAt.Format("15:04:05.000"), entry.RedoneBy)
+		}
+	}
+}
+
+func printError(err error) {
+	if err != nil {
+		fmt.Printf("  ✗ %v\n", err)
+	}
 }
 
 func main() {
@@ -182,14 +1643,14 @@ func main() {
 
 	// Example 1: Execute individual commands
 	fmt.Println("\n--- Example 1: Individual Commands ---")
-	controller.ExecuteCommand(deposit1)
-	controller.ExecuteCommand(withdraw1)
-	controller.ExecuteCommand(transfer1)
+	printError(controller.ExecuteCommand(deposit1))
+	printError(controller.ExecuteCommand(withdraw1))
+	printError(controller.ExecuteCommand(transfer1))
 
 	// Example 2: Undo commands
 	fmt.Println("\n--- Example 2: Undo Operations ---")
-	controller.UndoLast()
-	controller.UndoLast()
+	printError(controller.UndoLast())
+	printError(controller.UndoLast())
 
 	// Example 3: Macro command (Bill Payment)
 	fmt.Println("\n--- Example 3: Macro Command (Bill Payment) ---")
@@ -199,14 +1660,211 @@ func main() {
 		&WithdrawCommand{account: account1, amount: 25.0},
 	})
 
-	controller.ExecuteCommand(billPayment)
+	printError(controller.ExecuteCommand(billPayment))
 
 	fmt.Println("\n--- Undo Bill Payment ---")
-	controller.UndoLast()
+	printError(controller.UndoLast())
+
+	// Example 4: A failing step rolls back the whole macro
+	fmt.Println("\n--- Example 4: Atomic Macro Rollback ---")
+	payroll := NewMacroCommand("Payroll Run", []BankingCommand{
+		&TransferCommand{transferService: transferService, from: account1, to: account2, amount: 300.0},
+		&WithdrawCommand{account: account1, amount: 200.0},
+		&WithdrawCommand{account: account1, amount: 5000.0},
+	})
+	printError(controller.ExecuteCommand(payroll))
+	fmt.Printf("  Balances after rollback: %s $%.2f, %s $%.2f\n",
+		account1.accountID, account1.GetBalance(), account2.accountID, account2.GetBalance())
+
+	// Example 5: Undo reports when the money has already left the account
+	fmt.Println("\n--- Example 5: Failed Undo ---")
+	printError(controller.ExecuteCommand(&DepositCommand{account: account2, amount: 100.0}))
+	account2.Withdraw(account2.GetBalance()) // card payment made outside the controller
+	printError(controller.UndoLast())
+
+	// Example 6: Tellers correct several steps at once with undo/redo to a point
+	fmt.Println("\n--- Example 6: Redo and History Navigation ---")
+	teller := NewBankingController()
+	savings := &Account{accountID: "SAV001", balance: 2000.0}
+	printError(teller.ExecuteCommand(&DepositCommand{account: savings, amount: 300.0}))
+	printError(teller.ExecuteCommand(&WithdrawCommand{account: savings, amount: 120.0}))
+	printError(teller.ExecuteCommand(&TransferCommand{transferService: transferService, from: savings, to: account1, amount: 500.0}))
+	printError(teller.ExecuteCommand(&WithdrawCommand{account: savings, amount: 80.0}))
+	printHistory(teller)
+
+	printError(teller.UndoTo(1))
+	printHistory(teller)
+
+	printError(teller.RedoTo(3))
+	printHistory(teller)
+
+	// A new command abandons whatever was left on the redo branch
+	printError(teller.ExecuteCommand(&DepositCommand{account: savings, amount: 45.0}))
+	printHistory(teller)
+	printError(teller.Redo())
+
+	// Example 7: Journal every command so balances survive a restart
+	fmt.Println("\n--- Example 7: Command Journal and Crash Recovery ---")
+	journalPath := filepath.Join(os.TempDir(), "joshbank-commands.journal")
+	os.Remove(journalPath)
+	defer os.Remove(journalPath)
+
+	journal, err := OpenJournal(journalPath)
+	if err != nil {
+		printError(err)
+		return
+	}
+	checking := &Account{accountID: "CHK001", balance: 1000.0}
+	reserve := &Account{accountID: "RSV001", balance: 5000.0}
+	journaled := NewBankingController()
+	journaled.AttachJournal(journal)
+	printError(journaled.ExecuteCommand(&DepositCommand{account: checking, amount: 250.0}))
+	printError(journaled.ExecuteCommand(&TransferCommand{transferService: transferService, from: reserve, to: checking, amount: 1200.0}))
+	printError(journaled.ExecuteCommand(NewMacroCommand("Card Settlement", []BankingCommand{
+		&WithdrawCommand{account: checking, amount: 40.0},
+		&WithdrawCommand{account: checking, amount: 60.0},
+	})))
+	printError(journaled.UndoLast())
+	journal.Close()
+
+	// Simulate a crash halfway through writing the next record
+	if f, err := os.OpenFile(journalPath, os.O_APPEND|os.O_WRONLY, 0o644); err == nil {
+		f.WriteString(`0badc0de {"seq":5,"kind":"execute","descr`)
+		f.Close()
+	}
+
+	journal, err = OpenJournal(journalPath)
+	if err != nil {
+		printError(err)
+		return
+	}
+	journal.Close()
+
+	recovered := map[string]*Account{
+		"CHK001": {accountID: "CHK001", balance: 1000.0},
+		"RSV001": {accountID: "RSV001", balance: 5000.0},
+	}
+	replayed, err := ReplayJournal(journalPath, recovered)
+	printError(err)
+	fmt.Printf("  Replayed %d records (torn record dropped)\n", replayed)
+	fmt.Printf("  Live:      CHK001 $%.2f, RSV001 $%.2f\n", checking.GetBalance(), reserve.GetBalance())
+	fmt.Printf("  Recovered: CHK001 $%.2f, RSV001 $%.2f\n", recovered["CHK001"].GetBalance(), recovered["RSV001"].GetBalance())
+
+	// Example 8: Commands as JSON envelopes that reference accounts by ID
+	fmt.Println("\n--- Example 8: Serializable Commands ---")
+	repository := NewInMemoryAccountRepository(account1, account2, savings)
+	registry := NewCommandRegistry(repository, transferService)
+
+	outgoing := NewMacroCommand("Monthly Savings", []BankingCommand{
+		&TransferCommand{transferService: transferService, from: account1, to: savings, amount: 150.0},
+		&DepositCommand{account: savings, amount: 5.25},
+	})
+	data, err := MarshalCommand(outgoing)
+	printError(err)
+	fmt.Printf("  Wire form: %s\n", data)
+
+	incoming, err := registry.UnmarshalCommand(data)
+	printError(err)
+	if err == nil {
+		printError(controller.ExecuteCommand(incoming))
+	}
+
+	// Another team submits a command from outside the process
+	external := []byte(`{"type":"withdraw","payload":{"account":"ACC404","amount":20}}`)
+	if _, err := registry.UnmarshalCommand(external); err != nil {
+		printError(err)
+	}
+
+	// Example 9: Standing orders run by a scheduler against a controllable clock
+	fmt.Println("\n--- Example 9: Scheduled and Recurring Commands ---")
+	clock := NewManualClock(time.Date(2024, time.January, 31, 9, 0, 0, 0, time.UTC))
+	employer := &Account{accountID: "PAY001", balance: 100000.0}
+	salary := &Account{accountID: "EMP001", balance: 0.0}
+	landlord := &Account{accountID: "LND001", balance: 0.0}
+	standingOrders := NewBankingController()
+	scheduler := NewScheduler(standingOrders, clock)
+
+	scheduler.ScheduleRecurring("salary", clock.Now(), MonthlyOnDay{Day: 31}, func() BankingCommand {
+		return &TransferCommand{transferService: transferService, from: employer, to: salary, amount: 3000.0}
+	}, FailurePolicy{})
+	scheduler.ScheduleRecurring("rent", LastBusinessDay{}.Next(clock.Now()), LastBusinessDay{}, func() BankingCommand {
+		return &TransferCommand{transferService: transferService, from: salary, to: landlord, amount: 1200.0}
+	}, FailurePolicy{MaxRetries: 2, RetryDelay: 24 * time.Hour})
+	scheduler.ScheduleOnce("bonus-clawback", time.Date(2024, time.February, 10, 9, 0, 0, 0, time.UTC), func() BankingCommand {
+		return &WithdrawCommand{account: salary, amount: 10000.0}
+	}, FailurePolicy{MaxRetries: 1, RetryDelay: 24 * time.Hour})
+
+	for _, pending := range scheduler.Pending() {
+		fmt.Printf("  %-15s next %s\n", pending.ID, pending.NextRun.Format("Mon 2006-01-02"))
+	}
+	for clock.Now().Before(time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)) {
+		for _, result := range scheduler.RunDue() {
+			fmt.Printf("  %s %-15s due %s attempt %d: %s\n", clock.Now().Format("Mon 2006-01-02"),
+				result.ScheduleID, result.DueAt.Format("2006-01-02"), result.Attempt, result.Status)
+			printError(result.Err)
+		}
+		clock.Advance(24 * time.Hour)
+	}
+
+	// Example 10: A client retries after a network blip without paying twice
+	fmt.Println("\n--- Example 10: Idempotency Keys ---")
+	retryClock := NewManualClock(time.Date(2024, time.June, 3, 14, 0, 0, 0, time.UTC))
+	gateway := NewBankingController()
+	gateway.SetClock(retryClock)
+	gateway.SetIdempotencyRetention(time.Hour)
+	merchant := &Account{accountID: "MER001", balance: 0.0}
+	buyer := &Account{accountID: "BUY001", balance: 500.0}
+	pay := func(amount float64) BankingCommand {
+		return &TransferCommand{transferService: transferService, from: buyer, to: merchant, amount: amount}
+	}
+
+	outcome, err := gateway.ExecuteIdempotent("order-7781", pay(120.0))
+	printError(err)
+	retryClock.Advance(30 * time.Second)
+	outcome, err = gateway.ExecuteIdempotent("order-7781", pay(120.0))
+	printError(err)
+	fmt.Printf("  Replayed: %v, history #%d, buyer balance $%.2f\n", outcome.Replayed, outcome.Seq, buyer.GetBalance())
+
+	_, err = gateway.ExecuteIdempotent("order-7781", pay(999.0))
+	printError(err)
+
+	retryClock.Advance(2 * time.Hour)
+	outcome, err = gateway.ExecuteIdempotent("order-7781", pay(120.0))
+	printError(err)
+	fmt.Printf("  After retention expired: replayed %v, history #%d, buyer balance $%.2f\n", outcome.Replayed, outcome.Seq, buyer.GetBalance())
+
+	// Example 11: Who may run and undo what, by role and amount
+	fmt.Println("\n--- Example 11: Role-based Authorization ---")
+	branch := NewBankingController()
+	branch.SetAuthorization(NewRolePolicy(
+		Permission{Role: "teller", Action: ActionExecute, CommandType: "*", MaxAmount: 1000},
+		Permission{Role: "teller", Action: ActionUndo, CommandType: "*"},
+		Permission{Role: "supervisor", Action: ActionExecute, CommandType: "*", MaxAmount: 25000},
+		Permission{Role: "supervisor", Action: ActionUndo, CommandType: "*", OthersToo: true},
+	))
+	tina := Actor{ID: "tina", Roles: []string{"teller"}}
+	tom := Actor{ID: "tom", Roles: []string{"teller"}}
+	sam := Actor{ID: "sam", Roles: []string{"teller", "supervisor"}}
+	vault := &Account{accountID: "VLT001", balance: 50000.0}
+	client := &Account{accountID: "CLI001", balance: 200.0}
+
+	printError(branch.ExecuteAs(tina, &DepositCommand{account: client, amount: 400.0}))
+	printError(branch.ExecuteAs(tina, &TransferCommand{transferService: transferService, from: vault, to: client, amount: 12000.0}))
+	printError(branch.ExecuteAs(sam, &TransferCommand{transferService: transferService, from: vault, to: client, amount: 12000.0}))
+	printError(branch.UndoLastAs(tom))
+	printError(branch.UndoLastAs(sam))
+	printHistory(branch)
 
 	fmt.Println("\n✓ Command pattern encapsulates banking operations as objects")
 	fmt.Println("✓ Supports undo/redo operations")
 	fmt.Println("✓ Commands can be queued and logged for audit")
 	fmt.Println("✓ Macro commands combine multiple operations")
+	fmt.Println("✓ Failed macro steps are compensated so operations stay atomic")
+	fmt.Println("✓ A checksummed journal lets balances be rebuilt after a crash")
+	fmt.Println("✓ Commands serialize to envelopes and decode through a type registry")
+	fmt.Println("✓ A scheduler runs standing orders through the same controller")
+	fmt.Println("✓ Idempotency keys stop retried commands from executing twice")
+	fmt.Println("✓ Ordered locking keeps concurrent transfers race- and deadlock-free")
+	fmt.Println("✓ A role policy decides who may execute or undo each command")
 	fmt.Println("✓ JoshBank can implement transaction rollback and audit trails")
 }
@@ -0,0 +1,1390 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// scriptedCommand logs every call and fails the ones it is told to
+type scriptedCommand struct {
+	name       string
+	log        *[]string
+	executeErr error
+	reapplyErr error // returned by every Execute after the first
+	undoErr    error
+	executed   bool
+}
+
+func (c *scriptedCommand) Execute() error {
+	*c.log = append(*c.log, "execute "+c.name)
+	if c.executed && c.reapplyErr != nil {
+		return c.reapplyErr
+	}
+	c.executed = true
+	return c.executeErr
+}
+
+func (c *scriptedCommand) Undo() error {
+	*c.log = append(*c.log, "undo "+c.name)
+	return c.undoErr
+}
+
+func (c *scriptedCommand) GetDescription() string { return c.name }
+
+func TestMacroCommand(t *testing.T) {
+	errDeclined := errors.New("card declined")
+	errFrozen := errors.New("account frozen")
+	tests := []struct {
+		name    string
+		steps   func(log *[]string) []BankingCommand
+		undo    bool // run Execute, then Undo
+		log     []string
+		wantErr []error
+		message []string
+	}{
+		{
+			name: "all steps succeed",
+			steps: func(log *[]string) []BankingCommand {
+				return []BankingCommand{&scriptedCommand{name: "A", log: log}, &scriptedCommand{name: "B", log: log}}
+			},
+			log: []string{"execute A", "execute B"},
+		},
+		{
+			name: "failed step compensates earlier steps in reverse order",
+			steps: func(log *[]string) []BankingCommand {
+				return []BankingCommand{
+					&scriptedCommand{name: "A", log: log},
+					&scriptedCommand{name: "B", log: log},
+					&scriptedCommand{name: "C", log: log, executeErr: errDeclined},
+					&scriptedCommand{name: "D", log: log},
+				}
+			},
+			log:     []string{"execute A", "execute B", "execute C", "undo B", "undo A"},
+			wantErr: []error{errDeclined},
+			message: []string{"step 3 (C)"},
+		},
+		{
+			name: "failed compensation is joined with the step error",
+			steps: func(log *[]string) []BankingCommand {
+				return []BankingCommand{
+					&scriptedCommand{name: "A", log: log, undoErr: errFrozen},
+					&scriptedCommand{name: "B", log: log},
+					&scriptedCommand{name: "C", log: log, executeErr: errDeclined},
+				}
+			},
+			log:     []string{"execute A", "execute B", "execute C", "undo B", "undo A"},
+			wantErr: []error{errDeclined, errFrozen},
+			message: []string{"step 3 (C)", "compensate step 1 (A)"},
+		},
+		{
+			name: "undo reverses every step",
+			steps: func(log *[]string) []BankingCommand {
+				return []BankingCommand{&scriptedCommand{name: "A", log: log}, &scriptedCommand{name: "B", log: log}}
+			},
+			undo: true,
+			log:  []string{"execute A", "execute B", "undo B", "undo A"},
+		},
+		{
+			name: "failed undo re-applies the steps already undone",
+			steps: func(log *[]string) []BankingCommand {
+				return []BankingCommand{
+					&scriptedCommand{name: "A", log: log},
+					&scriptedCommand{name: "B", log: log, undoErr: errFrozen},
+					&scriptedCommand{name: "C", log: log},
+					&scriptedCommand{name: "D", log: log},
+				}
+			},
+			undo:    true,
+			log:     []string{"execute A", "execute B", "execute C", "execute D", "undo D", "undo C", "undo B", "execute C", "execute D"},
+			wantErr: []error{errFrozen},
+			message: []string{"undo step 2 (B)"},
+		},
+		{
+			name: "failed re-apply is joined with the undo error",
+			steps: func(log *[]string) []BankingCommand {
+				return []BankingCommand{
+					&scriptedCommand{name: "A", log: log, undoErr: errFrozen},
+					&scriptedCommand{name: "B", log: log, reapplyErr: errDeclined},
+				}
+			},
+			undo:    true,
+			log:     []string{"execute A", "execute B", "undo B", "undo A", "execute B"},
+			wantErr: []error{errFrozen, errDeclined},
+			message: []string{"undo step 1 (A)", "re-apply step 2 (B)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log []string
+			macro := NewMacroCommand("Macro", tt.steps(&log))
+			err := macro.Execute()
+			if tt.undo {
+				if err != nil {
+					t.Fatalf("execute: %v", err)
+				}
+				err = macro.Undo()
+			}
+			if !slices.Equal(log, tt.log) {
+				t.Errorf("calls:\n  got  %v\n  want %v", log, tt.log)
+			}
+			if (err != nil) != (len(tt.wantErr) > 0) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("err = %v, does not wrap %v", err, want)
+				}
+			}
+			for _, want := range tt.message {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("err = %q, does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMacroRollbackRestoresBalances(t *testing.T) {
+	quietOperationLog(t)
+	transferService := &TransferService{}
+	checking := &Account{accountID: "CHK001", balance: 1000.0}
+	savings := &Account{accountID: "SAV001", balance: 500.0}
+	controller := NewBankingController()
+
+	payroll := NewMacroCommand("Payroll Run", []BankingCommand{
+		&TransferCommand{transferService: transferService, from: checking, to: savings, amount: 300.0},
+		&WithdrawCommand{account: checking, amount: 200.0},
+		&WithdrawCommand{account: checking, amount: 5000.0},
+	})
+	if err := controller.ExecuteCommand(payroll); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("err = %v, want ErrInsufficientFunds", err)
+	}
+	if checking.GetBalance() != 1000.0 || savings.GetBalance() != 500.0 {
+		t.Errorf("balances after rollback: $%.2f, $%.2f", checking.GetBalance(), savings.GetBalance())
+	}
+	if len(controller.History()) != 0 {
+		t.Errorf("failed macro was added to the history")
+	}
+}
+
+// historySummary renders History as "#seq state description" lines
+func historySummary(controller *BankingController) []string {
+	var lines []string
+	for _, entry := range controller.History() {
+		state := "applied"
+		switch {
+		case entry.Denied:
+			state = "denied"
+		case entry.Undone:
+			state = "undone"
+		}
+		lines = append(lines, fmt.Sprintf("#%d %s %s", entry.Seq, state, entry.Description))
+	}
+	return lines
+}
+
+func TestNewCommandClearsRedoBranch(t *testing.T) {
+	quietOperationLog(t)
+	account := &Account{accountID: "ACC001", balance: 1000.0}
+	controller := NewBankingController()
+	for _, amount := range []float64{10, 20, 30} {
+		if err := controller.ExecuteCommand(&DepositCommand{account: account, amount: amount}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := controller.UndoTo(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.ExecuteCommand(&WithdrawCommand{account: account, amount: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"#1 applied Deposit $10.00 to account ACC001", "#4 applied Withdraw $5.00 from account ACC001"}
+	if got := historySummary(controller); !slices.Equal(got, want) {
+		t.Errorf("history:\n  got  %q\n  want %q", got, want)
+	}
+	if err := controller.Redo(); !errors.Is(err, ErrNothingToRedo) {
+		t.Errorf("redo after a new command: err = %v, want ErrNothingToRedo", err)
+	}
+	if err := controller.RedoTo(3); !errors.Is(err, ErrUnknownHistory) {
+		t.Errorf("redo to a discarded entry: err = %v, want ErrUnknownHistory", err)
+	}
+	if got := account.GetBalance(); got != 1005.0 {
+		t.Errorf("balance $%.2f, want $1005.00", got)
+	}
+}
+
+func TestNavigationRejectsUnknownEntries(t *testing.T) {
+	quietOperationLog(t)
+	account := &Account{accountID: "ACC001", balance: 1000.0}
+	controller := NewBankingController()
+	for _, amount := range []float64{10, 20, 30} {
+		controller.ExecuteCommand(&DepositCommand{account: account, amount: amount})
+	}
+	controller.UndoTo(2)
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"undo to an entry that never existed", func() error { return controller.UndoTo(9) }},
+		{"undo to an entry on the redo branch", func() error { return controller.UndoTo(3) }},
+		{"redo to an entry that never existed", func() error { return controller.RedoTo(9) }},
+		{"redo to an applied entry", func() error { return controller.RedoTo(2) }},
+	}
+	for _, tt := range tests {
+		if err := tt.call(); !errors.Is(err, ErrUnknownHistory) {
+			t.Errorf("%s: err = %v, want ErrUnknownHistory", tt.name, err)
+		}
+	}
+	if got := account.GetBalance(); got != 1030.0 {
+		t.Errorf("rejected navigation changed the balance to $%.2f", got)
+	}
+}
+
+func TestHistoryOrdersAppliedUndoneAndDeniedEntries(t *testing.T) {
+	quietOperationLog(t)
+	clock := NewManualClock(time.Date(2024, time.June, 3, 9, 0, 0, 0, time.UTC))
+	tina := Actor{ID: "tina", Roles: []string{"teller"}}
+	account := &Account{accountID: "ACC001", balance: 10000.0}
+	controller := NewBankingController()
+	controller.SetClock(clock)
+	controller.SetAuthorization(tellerPolicy())
+
+	controller.ExecuteAs(tina, &DepositCommand{account: account, amount: 10})    // #1
+	controller.ExecuteAs(tina, &DepositCommand{account: account, amount: 20})    // #2
+	controller.ExecuteAs(tina, &WithdrawCommand{account: account, amount: 5000}) // #3 denied
+	controller.ExecuteAs(tina, &DepositCommand{account: account, amount: 30})    // #4
+	clock.Advance(time.Minute)
+	controller.UndoToAs(tina, 1) // #4 and #2 move to the redo branch
+	controller.RedoAs(tina)      // #2 comes back with its original seq
+
+	want := []string{
+		"#1 applied Deposit $10.00 to account ACC001",
+		"#2 applied Deposit $20.00 to account ACC001",
+		"#3 denied Withdraw $5000.00 from account ACC001",
+		"#4 undone Deposit $30.00 to account ACC001",
+	}
+	if got := historySummary(controller); !slices.Equal(got, want) {
+		t.Errorf("history:\n  got  %q\n  want %q", got, want)
+	}
+	entry := controller.History()[1]
+	if !entry.ExecutedAt.Equal(clock.Now().Add(-time.Minute)) || !entry.RedoneAt.Equal(clock.Now()) || entry.RedoneBy != "tina" {
+		t.Errorf("redone entry: executed %v, redone %v by %q", entry.ExecutedAt, entry.RedoneAt, entry.RedoneBy)
+	}
+}
+
+// journalLine renders a record the way Journal.Append writes it
+func journalLine(t *testing.T, record JournalRecord) string {
+	t.Helper()
+	payload, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(payload), payload)
+}
+
+func depositRecord(seq uint64, account string, amount float64) JournalRecord {
+	return JournalRecord{
+		Seq:         seq,
+		Kind:        JournalExecute,
+		Description: fmt.Sprintf("Deposit $%.2f to account %s", amount, account),
+		Ops:         []JournalOp{{Op: OpDeposit, Account: account, Amount: amount}},
+		At:          time.Date(2024, time.June, 3, 9, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestOpenJournalRecovery(t *testing.T) {
+	tests := []struct {
+		name    string
+		content func(t *testing.T) string
+		seqs    []uint64 // records that survive
+		keep    int      // how many leading lines of content stay in the file
+		wantErr error
+	}{
+		{
+			name: "intact",
+			content: func(t *testing.T) string {
+				return journalLine(t, depositRecord(1, "ACC001", 10)) + journalLine(t, depositRecord(2, "ACC001", 20))
+			},
+			seqs: []uint64{1, 2},
+			keep: 2,
+		},
+		{
+			name:    "empty",
+			content: func(t *testing.T) string { return "" },
+		},
+		{
+			name: "torn final record without a newline",
+			content: func(t *testing.T) string {
+				return journalLine(t, depositRecord(1, "ACC001", 10)) + `0badc0de {"seq":2,"kind":"exec`
+			},
+			seqs: []uint64{1},
+			keep: 1,
+		},
+		{
+			name: "final record with a bad checksum",
+			content: func(t *testing.T) string {
+				line := journalLine(t, depositRecord(2, "ACC001", 20))
+				return journalLine(t, depositRecord(1, "ACC001", 10)) + "00000000" + line[8:]
+			},
+			seqs: []uint64{1},
+			keep: 1,
+		},
+		{
+			name: "bad checksum before the last record",
+			content: func(t *testing.T) string {
+				line := journalLine(t, depositRecord(1, "ACC001", 10))
+				return "00000000" + line[8:] + journalLine(t, depositRecord(2, "ACC001", 20))
+			},
+			wantErr: ErrJournalCorrupt,
+		},
+		{
+			name: "unparsable record before the last one",
+			content: func(t *testing.T) string {
+				return journalLine(t, depositRecord(1, "ACC001", 10)) + "not a record\n" + journalLine(t, depositRecord(2, "ACC001", 20))
+			},
+			wantErr: ErrJournalCorrupt,
+		},
+		{
+			name: "gap in sequence numbers",
+			content: func(t *testing.T) string {
+				return journalLine(t, depositRecord(1, "ACC001", 10)) +
+					journalLine(t, depositRecord(3, "ACC001", 30)) +
+					journalLine(t, depositRecord(4, "ACC001", 40))
+			},
+			wantErr: ErrJournalCorrupt,
+		},
+		{
+			name: "first record does not start at one",
+			content: func(t *testing.T) string {
+				return journalLine(t, depositRecord(2, "ACC001", 20)) + journalLine(t, depositRecord(3, "ACC001", 30))
+			},
+			wantErr: ErrJournalCorrupt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "commands.journal")
+			content := tt.content(t)
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			records, err := ReadJournal(path)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ReadJournal: err = %v, want %v", err, tt.wantErr)
+				}
+				if _, err := OpenJournal(path); !errors.Is(err, tt.wantErr) {
+					t.Errorf("OpenJournal: err = %v, want %v", err, tt.wantErr)
+				}
+				if data, _ := os.ReadFile(path); string(data) != content {
+					t.Errorf("a corrupt journal was modified")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadJournal: %v", err)
+			}
+			var seqs []uint64
+			for _, record := range records {
+				seqs = append(seqs, record.Seq)
+			}
+			if !slices.Equal(seqs, tt.seqs) {
+				t.Errorf("records %v, want %v", seqs, tt.seqs)
+			}
+
+			journal, err := OpenJournal(path)
+			if err != nil {
+				t.Fatalf("OpenJournal: %v", err)
+			}
+			lines := strings.SplitAfter(content, "\n")
+			if data, _ := os.ReadFile(path); string(data) != strings.Join(lines[:tt.keep], "") {
+				t.Errorf("file after open:\n%s", data)
+			}
+			// New records follow the last intact one
+			record, err := journal.Append(JournalExecute, "Deposit", []JournalOp{{Op: OpDeposit, Account: "ACC001", Amount: 1}})
+			journal.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := uint64(len(tt.seqs)) + 1; record.Seq != want {
+				t.Errorf("appended seq %d, want %d", record.Seq, want)
+			}
+			if records, err := ReadJournal(path); err != nil || len(records) != len(tt.seqs)+1 {
+				t.Errorf("after append: %d records, err %v", len(records), err)
+			}
+		})
+	}
+}
+
+func TestReplayJournal(t *testing.T) {
+	opening := func() map[string]*Account {
+		return map[string]*Account{
+			"CHK001": {accountID: "CHK001", balance: 100.0},
+			"RSV001": {accountID: "RSV001", balance: 1000.0},
+		}
+	}
+	record := func(seq uint64, ops ...JournalOp) JournalRecord {
+		return JournalRecord{Seq: seq, Kind: JournalExecute, Description: "test", Ops: ops}
+	}
+	tests := []struct {
+		name     string
+		records  []JournalRecord
+		replayed int
+		balances map[string]float64
+		wantErr  error
+	}{
+		{
+			name: "applies every op in order",
+			records: []JournalRecord{
+				record(1, JournalOp{Op: OpTransfer, Account: "RSV001", To: "CHK001", Amount: 500}),
+				record(2, JournalOp{Op: OpWithdraw, Account: "CHK001", Amount: 550}),
+				record(3, InvertOps([]JournalOp{{Op: OpWithdraw, Account: "CHK001", Amount: 550}})...),
+			},
+			replayed: 3,
+			balances: map[string]float64{"CHK001": 600, "RSV001": 500},
+		},
+		{
+			name: "overdraft leaves accounts at their opening balances",
+			records: []JournalRecord{
+				record(1, JournalOp{Op: OpDeposit, Account: "CHK001", Amount: 50}),
+				record(2, JournalOp{Op: OpWithdraw, Account: "CHK001", Amount: 500}),
+			},
+			balances: map[string]float64{"CHK001": 100, "RSV001": 1000},
+			wantErr:  ErrInsufficientFunds,
+		},
+		{
+			name: "unknown account leaves accounts at their opening balances",
+			records: []JournalRecord{
+				record(1, JournalOp{Op: OpTransfer, Account: "RSV001", To: "CHK001", Amount: 500}),
+				record(2, JournalOp{Op: OpDeposit, Account: "ACC404", Amount: 1}),
+			},
+			balances: map[string]float64{"CHK001": 100, "RSV001": 1000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "commands.journal")
+			var content strings.Builder
+			for _, r := range tt.records {
+				content.WriteString(journalLine(t, r))
+			}
+			os.WriteFile(path, []byte(content.String()), 0o644)
+
+			accounts := opening()
+			replayed, err := ReplayJournal(path, accounts)
+			if wantFailure := tt.replayed == 0; (err != nil) != wantFailure {
+				t.Fatalf("err = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if replayed != tt.replayed {
+				t.Errorf("replayed %d records, want %d", replayed, tt.replayed)
+			}
+			for id, want := range tt.balances {
+				if got := accounts[id].GetBalance(); got != want {
+					t.Errorf("%s: $%.2f, want $%.2f", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestControllerJournalReplaysToLiveBalances(t *testing.T) {
+	quietOperationLog(t)
+	path := filepath.Join(t.TempDir(), "commands.journal")
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transferService := &TransferService{}
+	checking := &Account{accountID: "CHK001", balance: 1000.0}
+	reserve := &Account{accountID: "RSV001", balance: 5000.0}
+	controller := NewBankingController()
+	controller.AttachJournal(journal)
+	controller.ExecuteCommand(&DepositCommand{account: checking, amount: 250.0})
+	controller.ExecuteCommand(&TransferCommand{transferService: transferService, from: reserve, to: checking, amount: 1200.0})
+	controller.UndoLast()
+	controller.Redo()
+	controller.ExecuteCommand(NewMacroCommand("Card Settlement", []BankingCommand{
+		&WithdrawCommand{account: checking, amount: 40.0},
+		&WithdrawCommand{account: checking, amount: 60.0},
+	}))
+	if err := controller.ExecuteCommand(&feeCommand{account: checking, amount: 1}); !errors.Is(err, ErrNotJournaled) {
+		t.Errorf("unjournalable command: err = %v, want ErrNotJournaled", err)
+	}
+	journal.Close()
+
+	recovered := map[string]*Account{
+		"CHK001": {accountID: "CHK001", balance: 1000.0},
+		"RSV001": {accountID: "RSV001", balance: 5000.0},
+	}
+	replayed, err := ReplayJournal(path, recovered)
+	if err != nil || replayed != 5 {
+		t.Fatalf("replayed %d records, err %v", replayed, err)
+	}
+	for _, live := range []*Account{checking, reserve} {
+		if got, want := recovered[live.accountID].GetBalance(), live.GetBalance(); got != want {
+			t.Errorf("%s: recovered $%.2f, live $%.2f", live.accountID, got, want)
+		}
+	}
+}
+
+func TestCommandRoundTrip(t *testing.T) {
+	transferService := &TransferService{}
+	checking := &Account{accountID: "CHK001", balance: 1000.0}
+	savings := &Account{accountID: "SAV001", balance: 500.0}
+	registry := NewCommandRegistry(NewInMemoryAccountRepository(checking, savings), transferService)
+
+	commands := []BankingCommand{
+		&DepositCommand{account: checking, amount: 25.5},
+		&WithdrawCommand{account: savings, amount: 10},
+		&TransferCommand{transferService: transferService, from: checking, to: savings, amount: 150},
+		NewMacroCommand("Month End", []BankingCommand{
+			&TransferCommand{transferService: transferService, from: checking, to: savings, amount: 150},
+			NewMacroCommand("Fees", []BankingCommand{
+				&WithdrawCommand{account: savings, amount: 2.5},
+				&DepositCommand{account: checking, amount: 0.75},
+			}),
+		}),
+	}
+	for _, cmd := range commands {
+		t.Run(cmd.GetDescription(), func(t *testing.T) {
+			data, err := MarshalCommand(cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := registry.UnmarshalCommand(data)
+			if err != nil {
+				t.Fatalf("unmarshal %s: %v", data, err)
+			}
+			again, err := MarshalCommand(decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(again) != string(data) {
+				t.Errorf("round trip changed the envelope:\n  %s\n  %s", data, again)
+			}
+			if decoded.GetDescription() != cmd.GetDescription() {
+				t.Errorf("description %q, want %q", decoded.GetDescription(), cmd.GetDescription())
+			}
+			if commandFingerprint(decoded) != commandFingerprint(cmd) {
+				t.Errorf("decoded command has a different fingerprint")
+			}
+		})
+	}
+
+	// Decoded commands are bound to the repository's accounts
+	decoded, _ := registry.UnmarshalCommand([]byte(`{"type":"transfer","payload":{"from":"CHK001","to":"SAV001","amount":100}}`))
+	quietOperationLog(t)
+	if err := decoded.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if checking.GetBalance() != 900 || savings.GetBalance() != 600 {
+		t.Errorf("balances after decoded transfer: $%.2f, $%.2f", checking.GetBalance(), savings.GetBalance())
+	}
+}
+
+func TestUnmarshalCommandRejectsBadEnvelopes(t *testing.T) {
+	checking := &Account{accountID: "CHK001", balance: 1000.0}
+	registry := NewCommandRegistry(NewInMemoryAccountRepository(checking), &TransferService{})
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr error
+		message string
+	}{
+		{"unknown type", `{"type":"refund","payload":{"account":"CHK001","amount":5}}`, ErrUnknownCommand, `"refund"`},
+		{"unknown account", `{"type":"deposit","payload":{"account":"ACC404","amount":5}}`, ErrAccountNotFound, "ACC404"},
+		{"unknown transfer target", `{"type":"transfer","payload":{"from":"CHK001","to":"ACC404","amount":5}}`, ErrAccountNotFound, "ACC404"},
+		{"unknown payload field", `{"type":"deposit","payload":{"account":"CHK001","amount":5,"memo":"x"}}`, nil, `unknown field "memo"`},
+		{"unknown envelope field", `{"type":"deposit","payload":{"account":"CHK001","amount":5},"priority":"high"}`, nil, `unknown field "priority"`},
+		{"zero amount", `{"type":"deposit","payload":{"account":"CHK001","amount":0}}`, nil, "invalid amount 0"},
+		{"negative amount", `{"type":"withdraw","payload":{"account":"CHK001","amount":-5}}`, nil, "invalid amount -5"},
+		{"negative transfer", `{"type":"transfer","payload":{"from":"CHK001","to":"CHK001","amount":-5}}`, nil, "invalid amount -5"},
+		{"amount out of range", `{"type":"deposit","payload":{"account":"CHK001","amount":1e999}}`, nil, "1e999"},
+		{"amount as a string", `{"type":"deposit","payload":{"account":"CHK001","amount":"NaN"}}`, nil, "cannot unmarshal string"},
+		{"bad macro step", `{"type":"macro","payload":{"description":"M","commands":[{"type":"deposit","payload":{"account":"CHK001","amount":5}},{"type":"withdraw","payload":{"account":"ACC404","amount":5}}]}}`, ErrAccountNotFound, "step 2"},
+		{"not json", `deposit CHK001 5`, nil, "parse command envelope"},
+		{"second envelope", `{"type":"deposit","payload":{"account":"CHK001","amount":5}}{"type":"withdraw","payload":{"account":"CHK001","amount":5}}`, nil, "unexpected data after the JSON value"},
+		{"stray closing brace", `{"type":"deposit","payload":{"account":"CHK001","amount":5}}}`, nil, "parse command envelope"},
+		{"trailing garbage", `{"type":"deposit","payload":{"account":"CHK001","amount":5}} x`, nil, "parse command envelope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := registry.UnmarshalCommand([]byte(tt.data))
+			if err == nil {
+				t.Fatalf("decoded %q, want an error", cmd.GetDescription())
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("err = %q, does not mention %q", err, tt.message)
+			}
+		})
+	}
+}
+
+func TestCheckAmount(t *testing.T) {
+	for _, amount := range []float64{0, -1, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if checkAmount(amount) == nil {
+			t.Errorf("amount %v accepted", amount)
+		}
+	}
+	for _, amount := range []float64{0.01, 1e12} {
+		if err := checkAmount(amount); err != nil {
+			t.Errorf("amount %v: %v", amount, err)
+		}
+	}
+}
+
+func TestMarshalCommandRejectsUnserializableSteps(t *testing.T) {
+	account := &Account{accountID: "ACC001"}
+	macro := NewMacroCommand("Fees", []BankingCommand{
+		&DepositCommand{account: account, amount: 5},
+		&feeCommand{account: account, amount: 1},
+	})
+	if _, err := MarshalCommand(macro); !errors.Is(err, ErrNotSerializable) {
+		t.Errorf("macro with an unserializable step: err = %v, want ErrNotSerializable", err)
+	}
+	if _, err := MarshalCommand(&feeCommand{account: account, amount: 1}); !errors.Is(err, ErrNotSerializable) {
+		t.Errorf("unserializable command: err = %v, want ErrNotSerializable", err)
+	}
+}
+
+func TestScheduleRecurringValidatesMonthlyDay(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC)
+	account := &Account{accountID: "ACC001"}
+	build := func() BankingCommand { return &DepositCommand{account: account, amount: 10.0} }
+
+	tests := []struct {
+		day     int
+		wantErr bool
+	}{
+		{day: -1, wantErr: true},
+		{day: 0, wantErr: true},
+		{day: 1},
+		{day: 31},
+		{day: 32, wantErr: true},
+	}
+	for _, tt := range tests {
+		scheduler := NewScheduler(NewBankingController(), NewManualClock(start))
+		err := scheduler.ScheduleRecurring("monthly", start, MonthlyOnDay{Day: tt.day}, build, FailurePolicy{})
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidSchedule) {
+				t.Errorf("day %d: err = %v, want ErrInvalidSchedule", tt.day, err)
+			}
+			if len(scheduler.Pending()) != 0 {
+				t.Errorf("day %d: rejected schedule was still added", tt.day)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("day %d: unexpected error %v", tt.day, err)
+		}
+	}
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name       string
+		recurrence Recurrence
+		start      time.Time
+		want       []time.Time
+	}{
+		{
+			name:       "day 31 clamps to short months without drifting",
+			recurrence: MonthlyOnDay{Day: 31},
+			start:      at(2024, time.January, 31),
+			want:       []time.Time{at(2024, time.February, 29), at(2024, time.March, 31), at(2024, time.April, 30), at(2024, time.May, 31)},
+		},
+		{
+			name:       "day 29 in a common year",
+			recurrence: MonthlyOnDay{Day: 29},
+			start:      at(2023, time.January, 29),
+			want:       []time.Time{at(2023, time.February, 28), at(2023, time.March, 29)},
+		},
+		{
+			name:       "day 15 from before the day in the same month",
+			recurrence: MonthlyOnDay{Day: 15},
+			start:      at(2024, time.January, 3),
+			want:       []time.Time{at(2024, time.January, 15), at(2024, time.February, 15)},
+		},
+		{
+			name:       "last business day steps back over weekends",
+			recurrence: LastBusinessDay{},
+			start:      at(2024, time.February, 1),
+			want: []time.Time{
+				at(2024, time.February, 29), // Thursday, leap day
+				at(2024, time.March, 29),    // 31st is a Sunday
+				at(2024, time.April, 30),
+				at(2024, time.May, 31),
+				at(2024, time.June, 28), // 30th is a Sunday
+				at(2024, time.July, 31),
+				at(2024, time.August, 30), // 31st is a Saturday
+			},
+		},
+		{
+			name:       "daily crosses the leap day",
+			recurrence: Daily{},
+			start:      at(2024, time.February, 28),
+			want:       []time.Time{at(2024, time.February, 29), at(2024, time.March, 1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			occurrence := tt.start
+			for _, want := range tt.want {
+				occurrence = tt.recurrence.Next(occurrence)
+				if !occurrence.Equal(want) {
+					t.Fatalf("got %s, want %s", occurrence.Format("Mon 2006-01-02 15:04"), want.Format("Mon 2006-01-02 15:04"))
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulerRunsMonthEndSalaryWithoutDrift(t *testing.T) {
+	quietOperationLog(t)
+	clock := NewManualClock(time.Date(2024, time.January, 31, 9, 0, 0, 0, time.UTC))
+	employer := &Account{accountID: "PAY001", balance: 100000.0}
+	salary := &Account{accountID: "EMP001"}
+	scheduler := NewScheduler(NewBankingController(), clock)
+	scheduler.ScheduleRecurring("salary", clock.Now(), MonthlyOnDay{Day: 31}, func() BankingCommand {
+		return &TransferCommand{transferService: &TransferService{}, from: employer, to: salary, amount: 3000.0}
+	}, FailurePolicy{})
+
+	var runs []string
+	for clock.Now().Before(time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)) {
+		for _, result := range scheduler.RunDue() {
+			if result.Err != nil || result.Status != RunExecuted {
+				t.Fatalf("%s: %s, %v", result.DueAt, result.Status, result.Err)
+			}
+			runs = append(runs, clock.Now().Format("2006-01-02"))
+		}
+		clock.Advance(24 * time.Hour)
+	}
+	if want := []string{"2024-01-31", "2024-02-29", "2024-03-31"}; !slices.Equal(runs, want) {
+		t.Errorf("ran on %v, want %v", runs, want)
+	}
+	if salary.GetBalance() != 9000 {
+		t.Errorf("salary balance $%.2f, want $9000.00", salary.GetBalance())
+	}
+}
+
+func TestSchedulerRetriesThenSkips(t *testing.T) {
+	quietOperationLog(t)
+	start := time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	account := &Account{accountID: "ACC001", balance: 50.0}
+	controller := NewBankingController()
+	scheduler := NewScheduler(controller, clock)
+	scheduler.ScheduleRecurring("gym", start, Weekly{}, func() BankingCommand {
+		return &WithdrawCommand{account: account, amount: 100.0}
+	}, FailurePolicy{MaxRetries: 2, RetryDelay: 6 * time.Hour})
+
+	type run struct {
+		at      string
+		attempt int
+		status  RunStatus
+	}
+	var runs []run
+	for clock.Now().Before(start.Add(8 * 24 * time.Hour)) {
+		for _, result := range scheduler.RunDue() {
+			if !errors.Is(result.Err, ErrInsufficientFunds) {
+				t.Errorf("attempt %d: err = %v", result.Attempt, result.Err)
+			}
+			if !result.DueAt.Equal(start) && !result.DueAt.Equal(start.AddDate(0, 0, 7)) {
+				t.Errorf("attempt %d reported due %s", result.Attempt, result.DueAt)
+			}
+			runs = append(runs, run{clock.Now().Format("01-02 15:04"), result.Attempt, result.Status})
+		}
+		clock.Advance(time.Hour)
+	}
+	want := []run{
+		{"03-01 09:00", 1, RunRetrying},
+		{"03-01 15:00", 2, RunRetrying},
+		{"03-01 21:00", 3, RunSkipped},
+		// The next occurrence keeps its place in the week despite the retries
+		{"03-08 09:00", 1, RunRetrying},
+		{"03-08 15:00", 2, RunRetrying},
+		{"03-08 21:00", 3, RunSkipped},
+	}
+	if !slices.Equal(runs, want) {
+		t.Errorf("runs:\n  got  %v\n  want %v", runs, want)
+	}
+	if len(controller.History()) != 0 || account.GetBalance() != 50 {
+		t.Errorf("failed runs changed state: %d history entries, balance $%.2f", len(controller.History()), account.GetBalance())
+	}
+}
+
+func TestSchedulerRetrySucceeds(t *testing.T) {
+	quietOperationLog(t)
+	start := time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	account := &Account{accountID: "ACC001", balance: 50.0}
+	scheduler := NewScheduler(NewBankingController(), clock)
+	scheduler.ScheduleOnce("rent", start, func() BankingCommand {
+		return &WithdrawCommand{account: account, amount: 100.0}
+	}, FailurePolicy{MaxRetries: 3, RetryDelay: time.Hour})
+
+	if results := scheduler.RunDue(); len(results) != 1 || results[0].Status != RunRetrying {
+		t.Fatalf("first attempt: %+v", results)
+	}
+	account.Deposit(100.0) // salary arrives before the retry
+	clock.Advance(time.Hour)
+	results := scheduler.RunDue()
+	if len(results) != 1 || results[0].Status != RunExecuted || results[0].Attempt != 2 || results[0].Err != nil {
+		t.Fatalf("retry: %+v", results)
+	}
+	if len(scheduler.Pending()) != 0 {
+		t.Errorf("one-off schedule still pending after it ran")
+	}
+}
+
+func TestSchedulerCatchesUpMissedOccurrences(t *testing.T) {
+	quietOperationLog(t)
+	start := time.Date(2024, time.February, 27, 8, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	account := &Account{accountID: "ACC001"}
+	scheduler := NewScheduler(NewBankingController(), clock)
+	scheduler.ScheduleRecurring("interest", start, Daily{}, func() BankingCommand {
+		return &DepositCommand{account: account, amount: 1.0}
+	}, FailurePolicy{})
+	scheduler.ScheduleOnce("fee", start.Add(36*time.Hour), func() BankingCommand {
+		return &DepositCommand{account: account, amount: 100.0}
+	}, FailurePolicy{})
+
+	// The scheduler was down for three days
+	clock.Advance(3*24*time.Hour + time.Hour)
+	var due []string
+	for _, result := range scheduler.RunDue() {
+		if result.Status != RunExecuted {
+			t.Fatalf("%s due %s: %s, %v", result.ScheduleID, result.DueAt, result.Status, result.Err)
+		}
+		due = append(due, result.ScheduleID+" "+result.DueAt.Format("01-02 15:04"))
+	}
+	want := []string{"interest 02-27 08:00", "interest 02-28 08:00", "fee 02-28 20:00", "interest 02-29 08:00", "interest 03-01 08:00"}
+	if !slices.Equal(due, want) {
+		t.Errorf("ran %v, want %v", due, want)
+	}
+	if pending := scheduler.Pending(); len(pending) != 1 || !pending[0].NextRun.Equal(time.Date(2024, time.March, 2, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("pending after catch-up: %+v", pending)
+	}
+	if results := scheduler.RunDue(); len(results) != 0 {
+		t.Errorf("second RunDue at the same time ran %d commands", len(results))
+	}
+}
+
+func newIdempotencyTest(t *testing.T) (*BankingController, *ManualClock, *Account, func(amount float64) BankingCommand) {
+	t.Helper()
+	quietOperationLog(t)
+	clock := NewManualClock(time.Date(2024, time.June, 3, 14, 0, 0, 0, time.UTC))
+	controller := NewBankingController()
+	controller.SetClock(clock)
+	controller.SetIdempotencyRetention(time.Hour)
+	buyer := &Account{accountID: "BUY001", balance: 500.0}
+	merchant := &Account{accountID: "MER001"}
+	pay := func(amount float64) BankingCommand {
+		return &TransferCommand{transferService: &TransferService{}, from: buyer, to: merchant, amount: amount}
+	}
+	return controller, clock, buyer, pay
+}
+
+func TestExecuteIdempotentReplaysDuplicates(t *testing.T) {
+	controller, clock, buyer, pay := newIdempotencyTest(t)
+
+	first, err := controller.ExecuteIdempotent("order-1", pay(120.0))
+	if err != nil || first.Replayed || first.Seq != 1 {
+		t.Fatalf("first call: %+v, %v", first, err)
+	}
+	clock.Advance(30 * time.Second)
+	retry, err := controller.ExecuteIdempotent("order-1", pay(120.0))
+	if err != nil || !retry.Replayed || retry.Seq != first.Seq || !retry.ExecutedAt.Equal(first.ExecutedAt) {
+		t.Fatalf("retry: %+v, %v", retry, err)
+	}
+	if buyer.GetBalance() != 380.0 || len(controller.History()) != 1 {
+		t.Errorf("retry executed again: balance $%.2f, %d history entries", buyer.GetBalance(), len(controller.History()))
+	}
+
+	// A different key is a different request
+	if outcome, err := controller.ExecuteIdempotent("order-2", pay(120.0)); err != nil || outcome.Replayed || outcome.Seq != 2 {
+		t.Errorf("second key: %+v, %v", outcome, err)
+	}
+}
+
+func TestExecuteIdempotentRejectsKeyConflicts(t *testing.T) {
+	controller, _, buyer, pay := newIdempotencyTest(t)
+	controller.ExecuteIdempotent("order-1", pay(120.0))
+
+	outcome, err := controller.ExecuteIdempotent("order-1", pay(999.0))
+	if !errors.Is(err, ErrKeyConflict) || outcome.Seq != 0 {
+		t.Fatalf("conflicting reuse: %+v, %v", outcome, err)
+	}
+	if buyer.GetBalance() != 380.0 {
+		t.Errorf("conflicting reuse moved money: balance $%.2f", buyer.GetBalance())
+	}
+	// The original outcome is still there for a genuine retry
+	if outcome, err := controller.ExecuteIdempotent("order-1", pay(120.0)); err != nil || !outcome.Replayed {
+		t.Errorf("retry after conflict: %+v, %v", outcome, err)
+	}
+}
+
+func TestExecuteIdempotentForgetsAfterRetention(t *testing.T) {
+	controller, clock, buyer, pay := newIdempotencyTest(t)
+	controller.ExecuteIdempotent("order-1", pay(120.0))
+
+	clock.Advance(time.Hour - time.Second)
+	if outcome, _ := controller.ExecuteIdempotent("order-1", pay(120.0)); !outcome.Replayed {
+		t.Fatalf("retry inside the window executed again")
+	}
+	clock.Advance(time.Second)
+	outcome, err := controller.ExecuteIdempotent("order-1", pay(120.0))
+	if err != nil || outcome.Replayed || outcome.Seq != 2 {
+		t.Fatalf("retry after the window: %+v, %v", outcome, err)
+	}
+	if buyer.GetBalance() != 260.0 {
+		t.Errorf("balance $%.2f, want $260.00", buyer.GetBalance())
+	}
+}
+
+func TestExecuteIdempotentStoresCommandFailures(t *testing.T) {
+	controller, _, buyer, pay := newIdempotencyTest(t)
+	if _, err := controller.ExecuteIdempotent("order-1", pay(900.0)); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("err = %v, want ErrInsufficientFunds", err)
+	}
+	buyer.Deposit(1000.0)
+	outcome, err := controller.ExecuteIdempotent("order-1", pay(900.0))
+	if !errors.Is(err, ErrInsufficientFunds) || !outcome.Replayed {
+		t.Errorf("retry of a failed command: %+v, %v", outcome, err)
+	}
+}
+
+func TestExecuteIdempotentDoesNotStoreJournalFailures(t *testing.T) {
+	controller, _, buyer, pay := newIdempotencyTest(t)
+	path := filepath.Join(t.TempDir(), "commands.journal")
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.Close() // every append now fails, like a full disk
+	controller.AttachJournal(journal)
+
+	if _, err := controller.ExecuteIdempotent("order-1", pay(120.0)); err == nil {
+		t.Fatal("expected a journal error")
+	}
+	if buyer.GetBalance() != 500.0 {
+		t.Fatalf("unjournaled transfer was not reversed: balance $%.2f", buyer.GetBalance())
+	}
+
+	// The disk recovers and the client retries with the same key
+	journal, err = OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	controller.AttachJournal(journal)
+	outcome, err := controller.ExecuteIdempotent("order-1", pay(120.0))
+	if err != nil || outcome.Replayed || outcome.Seq != 1 {
+		t.Fatalf("retry after the journal recovered: %+v, %v", outcome, err)
+	}
+	if buyer.GetBalance() != 380.0 {
+		t.Errorf("balance $%.2f, want $380.00", buyer.GetBalance())
+	}
+}
+
+// TestConcurrentTransfersConserveMoney runs transfers in both directions around
+// a pool of accounts from many goroutines; run it with -race.
+func TestConcurrentTransfersConserveMoney(t *testing.T) {
+	quietOperationLog(t)
+
+	transferService := &TransferService{}
+	pool := []*Account{
+		{accountID: "POOL-A", balance: 10000.0},
+		{accountID: "POOL-B", balance: 10000.0},
+		{accountID: "POOL-C", balance: 10000.0},
+		{accountID: "POOL-D", balance: 10000.0},
+	}
+	moneySupply := func() float64 {
+		total := 0.0
+		for _, account := range pool {
+			total += account.GetBalance()
+		}
+		return total
+	}
+	before := moneySupply()
+
+	done := make(chan struct{})
+	var executed, rejected atomic.Int64
+	go func() {
+		var wg sync.WaitGroup
+		for worker := range 16 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 2000 {
+					// Even workers push money one way round the pool, odd workers the other
+					from := pool[(worker+i)%len(pool)]
+					to := pool[(worker+i+1)%len(pool)]
+					if worker%2 == 1 {
+						from, to = to, from
+					}
+					cmd := &TransferCommand{transferService: transferService, from: from, to: to, amount: float64(1 + rand.IntN(500))}
+					if err := cmd.Execute(); err != nil {
+						rejected.Add(1)
+						continue
+					}
+					executed.Add(1)
+					if i%3 == 0 {
+						cmd.Undo()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("transfers did not finish: possible deadlock")
+	}
+	if after := moneySupply(); after != before {
+		t.Errorf("money supply before $%.2f, after $%.2f", before, after)
+	}
+	if executed.Load()+rejected.Load() != 16*2000 {
+		t.Errorf("%d executed + %d rejected, want %d attempts", executed.Load(), rejected.Load(), 16*2000)
+	}
+}
+
+func TestLockOrderIgnoresArgumentOrder(t *testing.T) {
+	a := &Account{accountID: "ACC001"}
+	b := &Account{accountID: "ACC002"}
+	twinA := &Account{accountID: "DUP001"}
+	twinB := &Account{accountID: "DUP001"}
+	for _, pair := range [][2]*Account{{a, b}, {twinA, twinB}, {twinB, a}} {
+		first1, second1 := lockOrder(pair[0], pair[1])
+		first2, second2 := lockOrder(pair[1], pair[0])
+		if first1 != first2 || second1 != second2 {
+			t.Errorf("%s/%s: lock order depends on argument order", pair[0].accountID, pair[1].accountID)
+		}
+	}
+	if first, _ := lockOrder(b, a); first != a {
+		t.Errorf("accounts with different IDs are not locked in ID order")
+	}
+}
+
+func TestOppositeTransfersBetweenAccountsSharingAnIDDoNotDeadlock(t *testing.T) {
+	quietOperationLog(t)
+	transferService := &TransferService{}
+	a := &Account{accountID: "DUP001", balance: 10000.0}
+	b := &Account{accountID: "DUP001", balance: 10000.0}
+
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for worker := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				from, to := a, b
+				if worker%2 == 1 {
+					from, to = b, a
+				}
+				for range 5000 {
+					transferService.Transfer(from, to, 1.0)
+				}
+			}()
+		}
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("transfers did not finish: deadlock between accounts sharing an ID")
+	}
+	if total := a.GetBalance() + b.GetBalance(); total != 20000.0 {
+		t.Errorf("money supply $%.2f, want $20000.00", total)
+	}
+}
+
+// feeCommand is a command type the controller doesn't know
+type feeCommand struct {
+	account *Account
+	amount  float64
+}
+
+func (c *feeCommand) Execute() error         { return c.account.Withdraw(c.amount) }
+func (c *feeCommand) Undo() error            { c.account.Deposit(c.amount); return nil }
+func (c *feeCommand) GetDescription() string { return "Charge fee" }
+
+// authorizableFee is the same fee, described to the policy
+type authorizableFee struct {
+	feeCommand
+}
+
+func (c *authorizableFee) CommandType() string { return "fee" }
+func (c *authorizableFee) Amount() float64     { return c.amount }
+
+func tellerPolicy() *RolePolicy {
+	return NewRolePolicy(
+		Permission{Role: "teller", Action: ActionExecute, CommandType: "*", MaxAmount: 1000},
+		Permission{Role: "teller", Action: ActionUndo, CommandType: "*"},
+	)
+}
+
+func quietOperationLog(t *testing.T) {
+	log := operationLog
+	operationLog = io.Discard
+	t.Cleanup(func() { operationLog = log })
+}
+
+func TestUnknownCommandTypesAreDenied(t *testing.T) {
+	quietOperationLog(t)
+	teller := Actor{ID: "tina", Roles: []string{"teller"}}
+	account := &Account{accountID: "ACC001", balance: 100000.0}
+
+	tests := []struct {
+		name    string
+		cmd     BankingCommand
+		wantErr bool
+	}{
+		{name: "unknown type", cmd: &feeCommand{account: account, amount: 50000.0}, wantErr: true},
+		{name: "macro with unknown step", cmd: NewMacroCommand("Fees", []BankingCommand{&feeCommand{account: account, amount: 5.0}}), wantErr: true},
+		{name: "authorizable over the cap", cmd: &authorizableFee{feeCommand{account: account, amount: 50000.0}}, wantErr: true},
+		{name: "authorizable within the cap", cmd: &authorizableFee{feeCommand{account: account, amount: 50.0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := NewBankingController()
+			controller.SetAuthorization(tellerPolicy())
+			err := controller.ExecuteAs(teller, tt.cmd)
+			if tt.wantErr != errors.Is(err, ErrUnauthorized) {
+				t.Errorf("err = %v, want unauthorized %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMacroStepsAreAuthorizedOnTheirOwn(t *testing.T) {
+	quietOperationLog(t)
+	transferService := &TransferService{}
+	vault := &Account{accountID: "VLT001", balance: 100000.0}
+	client := &Account{accountID: "CLI001", balance: 100.0}
+	teller := Actor{ID: "tina", Roles: []string{"teller"}}
+	policy := NewRolePolicy(
+		Permission{Role: "teller", Action: ActionExecute, CommandType: CommandTypeDeposit, MaxAmount: 1000},
+		Permission{Role: "teller", Action: ActionExecute, CommandType: CommandTypeMacro, MaxAmount: 1500},
+		Permission{Role: "teller", Action: ActionUndo, CommandType: CommandTypeMacro},
+		Permission{Role: "teller", Action: ActionUndo, CommandType: CommandTypeDeposit},
+	)
+	transfer := func(amount float64) BankingCommand {
+		return &TransferCommand{transferService: transferService, from: vault, to: client, amount: amount}
+	}
+	deposit := func(amount float64) BankingCommand {
+		return &DepositCommand{account: client, amount: amount}
+	}
+
+	tests := []struct {
+		name    string
+		cmd     BankingCommand
+		allowed bool
+	}{
+		{"transfer on its own", transfer(40000), false},
+		{"transfer wrapped in a macro", NewMacroCommand("Sweep", []BankingCommand{transfer(40000)}), false},
+		{"small transfer wrapped in a macro", NewMacroCommand("Sweep", []BankingCommand{deposit(10), transfer(5)}), false},
+		{"transfer in a nested macro", NewMacroCommand("Outer", []BankingCommand{NewMacroCommand("Inner", []BankingCommand{transfer(5)})}), false},
+		{"deposit over the step cap in a macro", NewMacroCommand("Cash In", []BankingCommand{deposit(1200)}), false},
+		{"deposits over the macro cap", NewMacroCommand("Cash In", []BankingCommand{deposit(800), deposit(800)}), false},
+		{"deposits within both caps", NewMacroCommand("Cash In", []BankingCommand{deposit(800), deposit(600)}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := NewBankingController()
+			controller.SetAuthorization(policy)
+			before := client.GetBalance()
+			err := controller.ExecuteAs(teller, tt.cmd)
+			if tt.allowed {
+				if err != nil {
+					t.Fatalf("err = %v", err)
+				}
+				if err := controller.UndoLastAs(teller); err != nil {
+					t.Fatalf("undo: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnauthorized) {
+				t.Fatalf("err = %v, want ErrUnauthorized", err)
+			}
+			if client.GetBalance() != before {
+				t.Errorf("refused command moved money: $%.2f → $%.2f", before, client.GetBalance())
+			}
+			history := controller.History()
+			if len(history) != 1 || !history[0].Denied || history[0].Description != tt.cmd.GetDescription() {
+				t.Errorf("history: %+v", history)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeysAreScopedToTheActor(t *testing.T) {
+	quietOperationLog(t)
+	vault := &Account{accountID: "VLT001", balance: 100000.0}
+	client := &Account{accountID: "CLI001"}
+	transfer := func() BankingCommand {
+		return &TransferCommand{transferService: &TransferService{}, from: vault, to: client, amount: 5000.0}
+	}
+	policy := NewRolePolicy(Permission{Role: "supervisor", Action: ActionExecute, CommandType: "*"})
+	supervisor := Actor{ID: "sam", Roles: []string{"supervisor"}}
+	nobody := Actor{ID: "mallory"}
+
+	t.Run("a stored success is not replayed to another actor", func(t *testing.T) {
+		controller := NewBankingController()
+		controller.SetAuthorization(policy)
+		if _, err := controller.ExecuteIdempotentAs(supervisor, "k1", transfer()); err != nil {
+			t.Fatal(err)
+		}
+		outcome, err := controller.ExecuteIdempotentAs(nobody, "k1", transfer())
+		if !errors.Is(err, ErrUnauthorized) || outcome.Replayed || outcome.Seq != 0 {
+			t.Errorf("other actor reusing the key: %+v, %v", outcome, err)
+		}
+		if outcome, err := controller.ExecuteIdempotentAs(supervisor, "k1", transfer()); err != nil || !outcome.Replayed || outcome.Actor != "sam" {
+			t.Errorf("supervisor retry: %+v, %v", outcome, err)
+		}
+	})
+
+	t.Run("a denial is not replayed to an authorized actor", func(t *testing.T) {
+		controller := NewBankingController()
+		controller.SetAuthorization(policy)
+		if _, err := controller.ExecuteIdempotentAs(nobody, "k1", transfer()); !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("err = %v, want ErrUnauthorized", err)
+		}
+		outcome, err := controller.ExecuteIdempotentAs(supervisor, "k1", transfer())
+		if err != nil || outcome.Replayed || outcome.Seq == 0 {
+			t.Errorf("supervisor using the key after a denial: %+v, %v", outcome, err)
+		}
+		// The denied actor isn't handed a stored denial either; it is asked again
+		outcome, err = controller.ExecuteIdempotentAs(nobody, "k1", transfer())
+		if !errors.Is(err, ErrUnauthorized) || outcome.Replayed {
+			t.Errorf("denied actor retrying: %+v, %v", outcome, err)
+		}
+	})
+
+	t.Run("keys don't conflict across actors", func(t *testing.T) {
+		controller := NewBankingController()
+		other := Actor{ID: "sue", Roles: []string{"supervisor"}}
+		controller.SetAuthorization(policy)
+		controller.ExecuteIdempotentAs(supervisor, "k1", transfer())
+		outcome, err := controller.ExecuteIdempotentAs(other, "k1", &DepositCommand{account: client, amount: 1})
+		if err != nil || outcome.Replayed {
+			t.Errorf("another actor's payload under the same key: %+v, %v", outcome, err)
+		}
+	})
+}
+
+func TestNavigationRunsAsTheGivenActor(t *testing.T) {
+	quietOperationLog(t)
+	tina := Actor{ID: "tina", Roles: []string{"teller"}}
+	tom := Actor{ID: "tom", Roles: []string{"teller"}}
+	account := &Account{accountID: "ACC001", balance: 100.0}
+
+	controller := NewBankingController()
+	controller.SetAuthorization(tellerPolicy())
+	for range 2 {
+		if err := controller.ExecuteAs(tina, &DepositCommand{account: account, amount: 10.0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := controller.UndoToAs(tom, 0); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("tom undoing tina's commands: err = %v, want ErrUnauthorized", err)
+	}
+	if err := controller.UndoToAs(tina, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.RedoToAs(tom, 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range controller.History() {
+		if !entry.Denied && entry.RedoneBy != "tom" {
+			t.Errorf("#%d redone by %q, want tom", entry.Seq, entry.RedoneBy)
+		}
+	}
+
+	outcome, err := controller.ExecuteIdempotentAs(tom, "k1", &DepositCommand{account: account, amount: 5000.0})
+	if !errors.Is(err, ErrUnauthorized) || outcome.Seq != 0 {
+		t.Errorf("idempotent execute over the cap: seq %d, err %v", outcome.Seq, err)
+	}
+}
+
+func TestScheduledRunsExecuteAsTheScheduleActor(t *testing.T) {
+	quietOperationLog(t)
+	start := time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	controller := NewBankingController()
+	controller.SetAuthorization(tellerPolicy())
+	scheduler := NewScheduler(controller, clock)
+	teller := Actor{ID: "tina", Roles: []string{"teller"}}
+	account := &Account{accountID: "ACC001", balance: 10000.0}
+
+	scheduler.ScheduleOnceAs(teller, "small", start, func() BankingCommand {
+		return &WithdrawCommand{account: account, amount: 100.0}
+	}, FailurePolicy{})
+	scheduler.ScheduleOnceAs(teller, "large", start, func() BankingCommand {
+		return &WithdrawCommand{account: account, amount: 5000.0}
+	}, FailurePolicy{})
+
+	results := scheduler.RunDue()
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	for _, result := range results {
+		denied := errors.Is(result.Err, ErrUnauthorized)
+		if denied != (result.ScheduleID == "large") {
+			t.Errorf("%s: err = %v", result.ScheduleID, result.Err)
+		}
+	}
+	for _, entry := range controller.History() {
+		if entry.Actor != "tina" {
+			t.Errorf("#%d %s ran as %q, want tina", entry.Seq, entry.Description, entry.Actor)
+		}
+		if entry.Denied && !strings.Contains(entry.Reason, "$5000.00") {
+			t.Errorf("#%d denied for %q", entry.Seq, entry.Reason)
+		}
+	}
+}
+
+func TestRegisterRejectsTakenCommandTypes(t *testing.T) {
+	registry := NewCommandRegistry(NewInMemoryAccountRepository(), &TransferService{})
+	for _, commandType := range []string{CommandTypeDeposit, CommandTypeWithdraw, CommandTypeTransfer, CommandTypeMacro} {
+		if err := registry.Register(commandType, decodeDeposit); err == nil || !strings.Contains(err.Error(), "already registered") {
+			t.Errorf("Register(%q) = %v, want an already registered error", commandType, err)
+		}
+	}
+	if err := registry.Register("refund", decodeDeposit); err != nil {
+		t.Errorf("Register(refund) = %v", err)
+	}
+}
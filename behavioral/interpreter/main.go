@@ -1,16 +1,144 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 )
 
+// --- Values ---
+
+// Kind distinguishes the two value types of the language
+type Kind int
+
+const (
+	KindNumber Kind = iota
+	KindBool
+)
+
+func (k Kind) String() string {
+	if k == KindBool {
+		return "bool"
+	}
+	return "number"
+}
+
+// Value is the result of interpreting an expression: either a number or a boolean
+type Value struct {
+	kind    Kind
+	number  float64
+	boolean bool
+}
+
+func NumberValue(n float64) Value { return Value{kind: KindNumber, number: n} }
+
+func BoolValue(b bool) Value { return Value{kind: KindBool, boolean: b} }
+
+func (v Value) Kind() Kind      { return v.kind }
+func (v Value) Number() float64 { return v.number }
+func (v Value) Bool() bool      { return v.boolean }
+
+func (v Value) String() string {
+	if v.kind == KindBool {
+		return strconv.FormatBool(v.boolean)
+	}
+	return strconv.FormatFloat(v.number, 'f', 2, 64)
+}
+
+// TypeError reports an operand of the wrong kind, such as TRUE + 1 or ACC001 AND ACC002
+type TypeError struct {
+	Expr string // the operator expression, as rendered by ToString
+	Want Kind
+	Got  Kind
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("type error in %s: expected %s, got %s", e.Expr, e.Want, e.Got)
+}
+
+var (
+	ErrUnknownAccount = errors.New("unknown account")
+	ErrDivisionByZero = errors.New("division by zero")
+	ErrNotFinite      = errors.New("result is not a finite number")
+
+	ErrArity               = errors.New("wrong number of arguments")
+	ErrSelectorOutsideCall = errors.New("account selector used outside a function call")
+	ErrFrameNotLoaded      = errors.New("frame was not loaded from a context")
+)
+
+// EvalError reports a failure while interpreting, at the innermost sub-expression
+// that failed. Err is one of the Err* sentinels above, for use with errors.Is.
+type EvalError struct {
+	Expr string // the failing sub-expression, as rendered by ToString
+	Err  error
+}
+
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("evaluating %s: %v", e.Expr, e.Err)
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// finiteNumber is finite for callers that don't need a Value
+func finiteNumber(expr Expression, n float64) (float64, error) {
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, &EvalError{Expr: expr.ToString(), Err: ErrNotFinite}
+	}
+	return n, nil
+}
+
+// finite wraps an arithmetic result, rejecting NaN and ±Inf so they can't leak into later comparisons
+func finite(expr Expression, n float64) (Value, error) {
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return Value{}, &EvalError{Expr: expr.ToString(), Err: ErrNotFinite}
+	}
+	return NumberValue(n), nil
+}
+
 // Expression is the abstract expression interface
 type Expression interface {
-	Interpret(context map[string]float64) float64
+	Interpret(context map[string]float64) (Value, error)
 	ToString() string
 }
 
+// evalAs interprets an operand of parent and checks it has the kind parent needs
+func evalAs(parent, operand Expression, want Kind, context map[string]float64) (Value, error) {
+	v, err := operand.Interpret(context)
+	if err != nil {
+		return Value{}, err
+	}
+	if v.kind != want {
+		return Value{}, &TypeError{Expr: parent.ToString(), Want: want, Got: v.kind}
+	}
+	return v, nil
+}
+
+// evalNumbers interprets both operands of a binary operator that takes numbers
+func evalNumbers(parent, left, right Expression, context map[string]float64) (float64, float64, error) {
+	l, err := evalAs(parent, left, KindNumber, context)
+	if err != nil {
+		return 0, 0, err
+	}
+	r, err := evalAs(parent, right, KindNumber, context)
+	if err != nil {
+		return 0, 0, err
+	}
+	return l.number, r.number, nil
+}
+
 // --- Terminal Expressions ---
 
 // AccountBalance is a terminal expression representing an account balance
@@ -18,8 +146,12 @@ type AccountBalance struct {
 	accountID string
 }
 
-func (a *AccountBalance) Interpret(context map[string]float64) float64 {
-	return context[a.accountID]
+func (a *AccountBalance) Interpret(context map[string]float64) (Value, error) {
+	balance, ok := context[a.accountID]
+	if !ok {
+		return Value{}, &EvalError{Expr: a.ToString(), Err: ErrUnknownAccount}
+	}
+	return finite(a, balance)
 }
 
 func (a *AccountBalance) ToString() string {
@@ -31,14 +163,27 @@ type Number struct {
 	value float64
 }
 
-func (n *Number) Interpret(context map[string]float64) float64 {
-	return n.value
+func (n *Number) Interpret(context map[string]float64) (Value, error) {
+	return NumberValue(n.value), nil
 }
 
 func (n *Number) ToString() string {
 	return strconv.FormatFloat(n.value, 'f', 2, 64)
 }
 
+// Bool is a terminal expression for TRUE and FALSE
+type Bool struct {
+	value bool
+}
+
+func (b *Bool) Interpret(context map[string]float64) (Value, error) {
+	return BoolValue(b.value), nil
+}
+
+func (b *Bool) ToString() string {
+	return strings.ToUpper(strconv.FormatBool(b.value))
+}
+
 // --- Non-Terminal Expressions ---
 
 // Add represents addition operation
@@ -47,8 +192,12 @@ type Add struct {
 	right Expression
 }
 
-func (a *Add) Interpret(context map[string]float64) float64 {
-	return a.left.Interpret(context) + a.right.Interpret(context)
+func (a *Add) Interpret(context map[string]float64) (Value, error) {
+	l, r, err := evalNumbers(a, a.left, a.right, context)
+	if err != nil {
+		return Value{}, err
+	}
+	return finite(a, l+r)
 }
 
 func (a *Add) ToString() string {
@@ -61,8 +210,12 @@ type Subtract struct {
 	right Expression
 }
 
-func (s *Subtract) Interpret(context map[string]float64) float64 {
-	return s.left.Interpret(context) - s.right.Interpret(context)
+func (s *Subtract) Interpret(context map[string]float64) (Value, error) {
+	l, r, err := evalNumbers(s, s.left, s.right, context)
+	if err != nil {
+		return Value{}, err
+	}
+	return finite(s, l-r)
 }
 
 func (s *Subtract) ToString() string {
@@ -75,36 +228,1750 @@ type Multiply struct {
 	right Expression
 }
 
-func (m *Multiply) Interpret(context map[string]float64) float64 {
-	return m.left.Interpret(context) * m.right.Interpret(context)
+func (m *Multiply) Interpret(context map[string]float64) (Value, error) {
+	l, r, err := evalNumbers(m, m.left, m.right, context)
+	if err != nil {
+		return Value{}, err
+	}
+	return finite(m, l*r)
 }
 
 func (m *Multiply) ToString() string {
 	return fmt.Sprintf("(%s * %s)", m.left.ToString(), m.right.ToString())
 }
 
+// Divide represents division operation
+type Divide struct {
+	left  Expression
+	right Expression
+}
+
+func (d *Divide) Interpret(context map[string]float64) (Value, error) {
+	l, r, err := evalNumbers(d, d.left, d.right, context)
+	if err != nil {
+		return Value{}, err
+	}
+	if r == 0 {
+		return Value{}, &EvalError{Expr: d.ToString(), Err: ErrDivisionByZero}
+	}
+	return finite(d, l/r)
+}
+
+func (d *Divide) ToString() string {
+	return fmt.Sprintf("(%s / %s)", d.left.ToString(), d.right.ToString())
+}
+
+// Modulo represents the floating-point remainder, with the sign of the left operand
+type Modulo struct {
+	left  Expression
+	right Expression
+}
+
+func (m *Modulo) Interpret(context map[string]float64) (Value, error) {
+	l, r, err := evalNumbers(m, m.left, m.right, context)
+	if err != nil {
+		return Value{}, err
+	}
+	if r == 0 {
+		return Value{}, &EvalError{Expr: m.ToString(), Err: ErrDivisionByZero}
+	}
+	return finite(m, math.Mod(l, r))
+}
+
+func (m *Modulo) ToString() string {
+	return fmt.Sprintf("(%s %% %s)", m.left.ToString(), m.right.ToString())
+}
+
 // GreaterThan represents comparison operation
 type GreaterThan struct {
 	left  Expression
 	right Expression
 }
 
-func (g *GreaterThan) Interpret(context map[string]float64) float64 {
-	if g.left.Interpret(context) > g.right.Interpret(context) {
-		return 1.0
+func (g *GreaterThan) Interpret(context map[string]float64) (Value, error) {
+	l, r, err := evalNumbers(g, g.left, g.right, context)
+	if err != nil {
+		return Value{}, err
 	}
-	return 0.0
+	return BoolValue(l > r), nil
 }
 
 func (g *GreaterThan) ToString() string {
 	return fmt.Sprintf("(%s > %s)", g.left.ToString(), g.right.ToString())
 }
 
+// LessThan represents comparison operation
+type LessThan struct {
+	left  Expression
+	right Expression
+}
+
+func (l *LessThan) Interpret(context map[string]float64) (Value, error) {
+	left, right, err := evalNumbers(l, l.left, l.right, context)
+	if err != nil {
+		return Value{}, err
+	}
+	return BoolValue(left < right), nil
+}
+
+func (l *LessThan) ToString() string {
+	return fmt.Sprintf("(%s < %s)", l.left.ToString(), l.right.ToString())
+}
+
+// Equals compares two numbers or two booleans; mixing the kinds is a type error
+type Equals struct {
+	left  Expression
+	right Expression
+}
+
+func (e *Equals) Interpret(context map[string]float64) (Value, error) {
+	l, err := e.left.Interpret(context)
+	if err != nil {
+		return Value{}, err
+	}
+	r, err := evalAs(e, e.right, l.kind, context)
+	if err != nil {
+		return Value{}, err
+	}
+	if l.kind == KindBool {
+		return BoolValue(l.boolean == r.boolean), nil
+	}
+	return BoolValue(l.number == r.number), nil
+}
+
+func (e *Equals) ToString() string {
+	return fmt.Sprintf("(%s == %s)", e.left.ToString(), e.right.ToString())
+}
+
+// And is logical conjunction; the right side is only evaluated when the left is true
+type And struct {
+	left  Expression
+	right Expression
+}
+
+func (a *And) Interpret(context map[string]float64) (Value, error) {
+	l, err := evalAs(a, a.left, KindBool, context)
+	if err != nil || !l.boolean {
+		return l, err
+	}
+	return evalAs(a, a.right, KindBool, context)
+}
+
+func (a *And) ToString() string {
+	return fmt.Sprintf("(%s AND %s)", a.left.ToString(), a.right.ToString())
+}
+
+// Or is logical disjunction; the right side is only evaluated when the left is false
+type Or struct {
+	left  Expression
+	right Expression
+}
+
+func (o *Or) Interpret(context map[string]float64) (Value, error) {
+	l, err := evalAs(o, o.left, KindBool, context)
+	if err != nil || l.boolean {
+		return l, err
+	}
+	return evalAs(o, o.right, KindBool, context)
+}
+
+func (o *Or) ToString() string {
+	return fmt.Sprintf("(%s OR %s)", o.left.ToString(), o.right.ToString())
+}
+
+// Not is logical negation
+type Not struct {
+	operand Expression
+}
+
+func (n *Not) Interpret(context map[string]float64) (Value, error) {
+	v, err := evalAs(n, n.operand, KindBool, context)
+	if err != nil {
+		return Value{}, err
+	}
+	return BoolValue(!v.boolean), nil
+}
+
+func (n *Not) ToString() string {
+	return fmt.Sprintf("(NOT %s)", n.operand.ToString())
+}
+
+// If picks one of two branches; only the chosen branch is evaluated, and the
+// branches may be of different kinds
+type If struct {
+	condition Expression
+	then      Expression
+	otherwise Expression
+}
+
+func (i *If) Interpret(context map[string]float64) (Value, error) {
+	c, err := evalAs(i, i.condition, KindBool, context)
+	if err != nil {
+		return Value{}, err
+	}
+	if c.boolean {
+		return i.then.Interpret(context)
+	}
+	return i.otherwise.Interpret(context)
+}
+
+func (i *If) ToString() string {
+	return fmt.Sprintf("(IF %s THEN %s ELSE %s)", i.condition.ToString(), i.then.ToString(), i.otherwise.ToString())
+}
+
+// --- Functions ---
+
+// Function computes a number from its arguments, in the order they were written.
+// Selector arguments such as SAV* contribute one value per matching account, in
+// account-ID order. Functions must be pure: Simplify evaluates calls whose
+// arguments are all constants once, ahead of time.
+type Function func(args []float64) (float64, error)
+
+// FunctionRegistry maps function names (case-insensitive) to their implementations
+type FunctionRegistry struct {
+	functions map[string]Function
+}
+
+// NewFunctionRegistry returns a registry with the built-in aggregates SUM, MIN, MAX, AVG and COUNT and ABS
+func NewFunctionRegistry() *FunctionRegistry {
+	r := &FunctionRegistry{functions: make(map[string]Function)}
+	r.mustRegister("SUM", func(args []float64) (float64, error) {
+		total := 0.0
+		for _, a := range args {
+			total += a
+		}
+		return total, nil
+	})
+	r.mustRegister("MIN", func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("MIN needs at least one argument: %w", ErrArity)
+		}
+		return slices.Min(args), nil
+	})
+	r.mustRegister("MAX", func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("MAX needs at least one argument: %w", ErrArity)
+		}
+		return slices.Max(args), nil
+	})
+	r.mustRegister("AVG", func(args []float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("AVG needs at least one argument: %w", ErrArity)
+		}
+		total := 0.0
+		for _, a := range args {
+			total += a
+		}
+		return total / float64(len(args)), nil
+	})
+	r.mustRegister("COUNT", func(args []float64) (float64, error) {
+		return float64(len(args)), nil
+	})
+	r.mustRegister("ABS", func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("ABS takes one argument, got %d: %w", len(args), ErrArity)
+		}
+		return math.Abs(args[0]), nil
+	})
+	return r
+}
+
+// Register adds a function. Names are case-insensitive, so registering SUM and
+// sum is a clash; a name that is already taken is an error and keeps the existing function.
+func (r *FunctionRegistry) Register(name string, fn Function) error {
+	key := strings.ToUpper(name)
+	if _, exists := r.functions[key]; exists {
+		return fmt.Errorf("function %q is already registered", key)
+	}
+	r.functions[key] = fn
+	return nil
+}
+
+// mustRegister registers a built-in; a clash there is a programming error
+func (r *FunctionRegistry) mustRegister(name string, fn Function) {
+	if err := r.Register(name, fn); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup finds a function by name
+func (r *FunctionRegistry) Lookup(name string) (Function, bool) {
+	fn, ok := r.functions[strings.ToUpper(name)]
+	return fn, ok
+}
+
+// DefaultFunctions is the registry ParseExpression resolves calls against
+var DefaultFunctions = NewFunctionRegistry()
+
+// AccountSelector matches every account in the context whose ID starts with prefix.
+// It stands for a list of balances, so it can only be used as a function argument.
+type AccountSelector struct {
+	prefix string
+}
+
+// Matches returns the IDs of the selected accounts in order; no match is an ErrUnknownAccount
+func (a *AccountSelector) Matches(context map[string]float64) ([]string, error) {
+	var ids []string
+	for id := range context {
+		if strings.HasPrefix(id, a.prefix) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, &EvalError{Expr: a.ToString(), Err: ErrUnknownAccount}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (a *AccountSelector) Interpret(context map[string]float64) (Value, error) {
+	return Value{}, &EvalError{Expr: a.ToString(), Err: ErrSelectorOutsideCall}
+}
+
+func (a *AccountSelector) ToString() string {
+	return a.prefix + "*"
+}
+
+// FunctionCall applies a registered function to its arguments
+type FunctionCall struct {
+	name string
+	fn   Function
+	args []Expression
+}
+
+func (f *FunctionCall) Interpret(context map[string]float64) (Value, error) {
+	var values []float64
+	for _, arg := range f.args {
+		if selector, ok := arg.(*AccountSelector); ok {
+			ids, err := selector.Matches(context)
+			if err != nil {
+				return Value{}, err
+			}
+			for _, id := range ids {
+				v, err := finite(selector, context[id])
+				if err != nil {
+					return Value{}, err
+				}
+				values = append(values, v.number)
+			}
+			continue
+		}
+		v, err := evalAs(f, arg, KindNumber, context)
+		if err != nil {
+			return Value{}, err
+		}
+		values = append(values, v.number)
+	}
+	result, err := f.fn(values)
+	if err != nil {
+		return Value{}, &EvalError{Expr: f.ToString(), Err: err}
+	}
+	return finite(f, result)
+}
+
+func (f *FunctionCall) ToString() string {
+	args := make([]string, len(f.args))
+	for i, arg := range f.args {
+		args[i] = arg.ToString()
+	}
+	return fmt.Sprintf("%s(%s)", f.name, strings.Join(args, ", "))
+}
+
+// --- Parser ---
+
+// SyntaxError reports where a rule's text stops making sense; Line and Col are 1-based
+type SyntaxError struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, col %d: %s", e.Line, e.Col, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenKeyword
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+	col  int
+}
+
+func (t token) describe() string {
+	if t.kind == tokenEOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// keywords are matched case-insensitively and can't be used as account IDs
+var keywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true,
+	"IF": true, "THEN": true, "ELSE": true,
+	"TRUE": true, "FALSE": true,
+}
+
+// tokenize splits a rule into tokens. Identifiers start with a letter or '_'
+// and may contain letters, digits and '_', so account IDs like ACC001 are one token.
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	runes := []rune(src)
+	line, col := 1, 1
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := token{line: line, col: col}
+		switch {
+		case r == '\n':
+			line, col = line+1, 1
+			i++
+			continue
+		case unicode.IsSpace(r):
+			i, col = i+1, col+1
+			continue
+		case unicode.IsDigit(r) || r == '.':
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			text := string(runes[i:j])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, &SyntaxError{Line: line, Col: col, Msg: fmt.Sprintf("invalid number %q", text)}
+			}
+			start.kind, start.text = tokenNumber, text
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			start.kind, start.text = tokenIdent, string(runes[i:j])
+			if word := strings.ToUpper(start.text); keywords[word] {
+				start.kind, start.text = tokenKeyword, word
+			}
+		case r == '(':
+			start.kind, start.text = tokenLParen, "("
+		case r == ')':
+			start.kind, start.text = tokenRParen, ")"
+		case r == ',':
+			start.kind, start.text = tokenComma, ","
+		case r == '=' && i+1 < len(runes) && runes[i+1] == '=':
+			start.kind, start.text = tokenOperator, "=="
+		case strings.ContainsRune("+-*/%<>", r):
+			start.kind, start.text = tokenOperator, string(r)
+		default:
+			return nil, &SyntaxError{Line: line, Col: col, Msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+		n := len([]rune(start.text))
+		i, col = i+n, col+n
+		tokens = append(tokens, start)
+	}
+	return append(tokens, token{kind: tokenEOF, line: line, col: col}), nil
+}
+
+// binaryOperator describes how tightly an infix operator binds and which node it builds
+type binaryOperator struct {
+	precedence int
+	build      func(left, right Expression) Expression
+}
+
+// binaryOperators lists the infix operators from loosest to tightest binding.
+// All of them are left-associative, so ACC001 - 100 - 50 is (ACC001 - 100) - 50.
+var binaryOperators = map[string]binaryOperator{
+	"OR":  {1, func(l, r Expression) Expression { return &Or{left: l, right: r} }},
+	"AND": {2, func(l, r Expression) Expression { return &And{left: l, right: r} }},
+	">":   {precedenceComparison, func(l, r Expression) Expression { return &GreaterThan{left: l, right: r} }},
+	"<":   {precedenceComparison, func(l, r Expression) Expression { return &LessThan{left: l, right: r} }},
+	"==":  {precedenceComparison, func(l, r Expression) Expression { return &Equals{left: l, right: r} }},
+	"+":   {4, func(l, r Expression) Expression { return &Add{left: l, right: r} }},
+	"-":   {4, func(l, r Expression) Expression { return &Subtract{left: l, right: r} }},
+	"*":   {5, func(l, r Expression) Expression { return &Multiply{left: l, right: r} }},
+	"/":   {5, func(l, r Expression) Expression { return &Divide{left: l, right: r} }},
+	"%":   {5, func(l, r Expression) Expression { return &Modulo{left: l, right: r} }},
+}
+
+// precedenceComparison is also how far NOT reaches: NOT ACC001 > 5 AND x is (NOT (ACC001 > 5)) AND x
+const precedenceComparison = 3
+
+type parser struct {
+	tokens    []token
+	pos       int
+	functions *FunctionRegistry
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorAt(t token, format string, args ...any) error {
+	return &SyntaxError{Line: t.line, Col: t.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// ParseExpression turns rule text such as "ACC001 + ACC002 * 2 > 5000" into an
+// Expression tree, using the usual precedence: * / % before + and -, before
+// comparisons, before NOT, AND and OR. Function calls resolve against DefaultFunctions.
+func ParseExpression(src string) (Expression, error) {
+	return DefaultFunctions.Parse(src)
+}
+
+// Parse is ParseExpression with function calls resolved against this registry;
+// calling a function that isn't registered is a syntax error
+func (r *FunctionRegistry) Parse(src string) (Expression, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, functions: r}
+	expr, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorAt(t, "unexpected %s after expression", t.describe())
+	}
+	return expr, nil
+}
+
+// parseBinary parses operands joined by operators binding at least as tightly as minPrecedence
+func (p *parser) parseBinary(minPrecedence int) (Expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		op, ok := binaryOperators[t.text]
+		if (t.kind != tokenOperator && t.kind != tokenKeyword) || !ok || op.precedence < minPrecedence {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(op.precedence + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = op.build(left, right)
+	}
+}
+
+// parseUnary handles a leading minus; -5 becomes Number{-5}, -ACC001 becomes 0 - ACC001
+func (p *parser) parseUnary() (Expression, error) {
+	if t := p.peek(); t.kind == tokenKeyword && t.text == "NOT" {
+		p.next()
+		operand, err := p.parseBinary(precedenceComparison)
+		if err != nil {
+			return nil, err
+		}
+		return &Not{operand: operand}, nil
+	}
+	if t := p.peek(); t.kind == tokenOperator && t.text == "-" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if n, ok := operand.(*Number); ok {
+			return &Number{value: -n.value}, nil
+		}
+		return &Subtract{left: &Number{value: 0}, right: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expression, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		value, _ := strconv.ParseFloat(t.text, 64) // validated by tokenize
+		return &Number{value: value}, nil
+	case tokenIdent:
+		if p.peek().kind == tokenLParen {
+			return p.parseCall(t)
+		}
+		return &AccountBalance{accountID: t.text}, nil
+	case tokenKeyword:
+		switch t.text {
+		case "TRUE", "FALSE":
+			return &Bool{value: t.text == "TRUE"}, nil
+		case "IF":
+			return p.parseIf()
+		}
+	case tokenLParen:
+		expr, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, p.errorAt(closing, "expected \")\" to close \"(\" at line %d, col %d, found %s", t.line, t.col, closing.describe())
+		}
+		return expr, nil
+	}
+	return nil, p.errorAt(t, "expected a number, account or \"(\", found %s", t.describe())
+}
+
+// parseCall parses the argument list of a call to the function named by t
+func (p *parser) parseCall(name token) (Expression, error) {
+	fn, ok := p.functions.Lookup(name.text)
+	if !ok {
+		return nil, p.errorAt(name, "unknown function %q", name.text)
+	}
+	open := p.next()
+	call := &FunctionCall{name: strings.ToUpper(name.text), fn: fn}
+	if p.peek().kind == tokenRParen {
+		p.next()
+		return call, nil
+	}
+	for {
+		arg, err := p.parseArgument()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+		switch t := p.next(); t.kind {
+		case tokenComma:
+			continue
+		case tokenRParen:
+			return call, nil
+		default:
+			return nil, p.errorAt(t, "expected \",\" or \")\" to close \"(\" at line %d, col %d, found %s", open.line, open.col, t.describe())
+		}
+	}
+}
+
+// parseArgument parses one function argument. An account prefix written directly
+// before "*" and ending the argument, like SAV*, is a selector; a lone * selects every account.
+func (p *parser) parseArgument() (Expression, error) {
+	t := p.peek()
+	if t.kind == tokenOperator && t.text == "*" && p.endsArgument(p.pos+1) {
+		p.next()
+		return &AccountSelector{}, nil
+	}
+	if t.kind == tokenIdent && p.pos+1 < len(p.tokens) {
+		star := p.tokens[p.pos+1]
+		adjacent := star.line == t.line && star.col == t.col+len([]rune(t.text))
+		if star.kind == tokenOperator && star.text == "*" && adjacent && p.endsArgument(p.pos+2) {
+			p.pos += 2
+			return &AccountSelector{prefix: t.text}, nil
+		}
+	}
+	return p.parseBinary(1)
+}
+
+func (p *parser) endsArgument(i int) bool {
+	return i < len(p.tokens) && (p.tokens[i].kind == tokenComma || p.tokens[i].kind == tokenRParen)
+}
+
+// parseIf parses the rest of IF cond THEN a ELSE b; the ELSE branch extends as far right as possible
+func (p *parser) parseIf() (Expression, error) {
+	condition, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("THEN"); err != nil {
+		return nil, err
+	}
+	then, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("ELSE"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	return &If{condition: condition, then: then, otherwise: otherwise}, nil
+}
+
+func (p *parser) expectKeyword(word string) error {
+	if t := p.next(); t.kind != tokenKeyword || t.text != word {
+		return p.errorAt(t, "expected %s, found %s", word, t.describe())
+	}
+	return nil
+}
+
+// --- Analysis ---
+
+// children returns the operands of a non-terminal expression, in evaluation order
+func children(expr Expression) []Expression {
+	switch e := expr.(type) {
+	case *Add:
+		return []Expression{e.left, e.right}
+	case *Subtract:
+		return []Expression{e.left, e.right}
+	case *Multiply:
+		return []Expression{e.left, e.right}
+	case *Divide:
+		return []Expression{e.left, e.right}
+	case *Modulo:
+		return []Expression{e.left, e.right}
+	case *GreaterThan:
+		return []Expression{e.left, e.right}
+	case *LessThan:
+		return []Expression{e.left, e.right}
+	case *Equals:
+		return []Expression{e.left, e.right}
+	case *And:
+		return []Expression{e.left, e.right}
+	case *Or:
+		return []Expression{e.left, e.right}
+	case *Not:
+		return []Expression{e.operand}
+	case *If:
+		return []Expression{e.condition, e.then, e.otherwise}
+	case *FunctionCall:
+		return e.args
+	default:
+		return nil
+	}
+}
+
+// withChildren returns a copy of expr with its operands replaced, in the order children returns them
+func withChildren(expr Expression, kids []Expression) Expression {
+	switch e := expr.(type) {
+	case *Add:
+		return &Add{left: kids[0], right: kids[1]}
+	case *Subtract:
+		return &Subtract{left: kids[0], right: kids[1]}
+	case *Multiply:
+		return &Multiply{left: kids[0], right: kids[1]}
+	case *Divide:
+		return &Divide{left: kids[0], right: kids[1]}
+	case *Modulo:
+		return &Modulo{left: kids[0], right: kids[1]}
+	case *GreaterThan:
+		return &GreaterThan{left: kids[0], right: kids[1]}
+	case *LessThan:
+		return &LessThan{left: kids[0], right: kids[1]}
+	case *Equals:
+		return &Equals{left: kids[0], right: kids[1]}
+	case *And:
+		return &And{left: kids[0], right: kids[1]}
+	case *Or:
+		return &Or{left: kids[0], right: kids[1]}
+	case *Not:
+		return &Not{operand: kids[0]}
+	case *If:
+		return &If{condition: kids[0], then: kids[1], otherwise: kids[2]}
+	case *FunctionCall:
+		return &FunctionCall{name: e.name, fn: e.fn, args: kids}
+	default:
+		return expr
+	}
+}
+
+// rewrite rebuilds a tree bottom-up, applying f to each node after its operands.
+// The original tree is never modified.
+func rewrite(expr Expression, f func(Expression) Expression) Expression {
+	kids := children(expr)
+	if len(kids) > 0 {
+		rewritten := make([]Expression, len(kids))
+		for i, kid := range kids {
+			rewritten[i] = rewrite(kid, f)
+		}
+		expr = withChildren(expr, rewritten)
+	}
+	return f(expr)
+}
+
+func isConstant(expr Expression) bool {
+	switch expr.(type) {
+	case *Number, *Bool:
+		return true
+	}
+	return false
+}
+
+func isNumber(expr Expression, value float64) bool {
+	n, ok := expr.(*Number)
+	return ok && n.value == value
+}
+
+func isBool(expr Expression, value bool) bool {
+	b, ok := expr.(*Bool)
+	return ok && b.value == value
+}
+
+// staticKind reports the kind an expression produces whenever it succeeds, if that is known without a context
+func staticKind(expr Expression) (Kind, bool) {
+	switch e := expr.(type) {
+	case *Number, *AccountBalance, *Add, *Subtract, *Multiply, *Divide, *Modulo, *FunctionCall:
+		return KindNumber, true
+	case *Bool, *GreaterThan, *LessThan, *Equals, *And, *Or, *Not:
+		return KindBool, true
+	case *If:
+		then, ok := staticKind(e.then)
+		if otherwise, ok2 := staticKind(e.otherwise); ok && ok2 && then == otherwise {
+			return then, true
+		}
+	}
+	return 0, false
+}
+
+func isKind(expr Expression, kind Kind) bool {
+	k, ok := staticKind(expr)
+	return ok && k == kind
+}
+
+// foldNode replaces an operator whose operands are all constants by its value.
+// Operators that would fail, like 1 / 0 or TRUE + 1, are left alone so they still fail at run time.
+func foldNode(expr Expression) Expression {
+	kids := children(expr)
+	if len(kids) == 0 {
+		return expr
+	}
+	for _, kid := range kids {
+		if !isConstant(kid) {
+			return expr
+		}
+	}
+	v, err := expr.Interpret(nil)
+	if err != nil {
+		return expr
+	}
+	if v.Kind() == KindBool {
+		return &Bool{value: v.Bool()}
+	}
+	return &Number{value: v.Number()}
+}
+
+// FoldConstants returns a copy of expr with every constant sub-tree, such as 12 * 100, replaced by its value
+func FoldConstants(expr Expression) Expression {
+	return rewrite(expr, foldNode)
+}
+
+// Simplify folds constants and applies algebraic identities such as x * 1 = x and
+// TRUE AND x = x. Identities are only applied when they keep the result, and
+// keep a failing expression failing with the same sentinel error (and, for a
+// TypeError, the same kinds): x * 0 is not reduced to 0, because x might be an
+// unknown account, and x + 0 is only reduced when x is known to be a number.
+// The Expr field of an EvalError or TypeError quotes the simplified tree, so
+// its text can differ from what the original tree reports.
+func Simplify(expr Expression) Expression {
+	return rewrite(expr, func(e Expression) Expression {
+		return simplifyNode(foldNode(e))
+	})
+}
+
+func simplifyNode(expr Expression) Expression {
+	switch e := expr.(type) {
+	case *Add:
+		if isNumber(e.right, 0) && isKind(e.left, KindNumber) {
+			return e.left
+		}
+		if isNumber(e.left, 0) && isKind(e.right, KindNumber) {
+			return e.right
+		}
+	case *Subtract:
+		if isNumber(e.right, 0) && isKind(e.left, KindNumber) {
+			return e.left
+		}
+	case *Multiply:
+		if isNumber(e.right, 1) && isKind(e.left, KindNumber) {
+			return e.left
+		}
+		if isNumber(e.left, 1) && isKind(e.right, KindNumber) {
+			return e.right
+		}
+	case *Divide:
+		if isNumber(e.right, 1) && isKind(e.left, KindNumber) {
+			return e.left
+		}
+	case *And:
+		// FALSE AND x never evaluates x, so dropping it is safe; x AND FALSE is not
+		if isBool(e.left, false) {
+			return e.left
+		}
+		if isBool(e.left, true) && isKind(e.right, KindBool) {
+			return e.right
+		}
+		if isBool(e.right, true) && isKind(e.left, KindBool) {
+			return e.left
+		}
+	case *Or:
+		if isBool(e.left, true) {
+			return e.left
+		}
+		if isBool(e.left, false) && isKind(e.right, KindBool) {
+			return e.right
+		}
+		if isBool(e.right, false) && isKind(e.left, KindBool) {
+			return e.left
+		}
+	case *Not:
+		if inner, ok := e.operand.(*Not); ok && isKind(inner.operand, KindBool) {
+			return inner.operand
+		}
+	case *If:
+		// Only the chosen branch is ever evaluated, so the other can go
+		if c, ok := e.condition.(*Bool); ok {
+			if c.value {
+				return e.then
+			}
+			return e.otherwise
+		}
+	}
+	return expr
+}
+
+// Dependencies lists, sorted and without duplicates, every account a rule reads,
+// so callers only need to load those balances. Selectors are listed as written,
+// e.g. SAV*, meaning every account with that prefix.
+func Dependencies(expr Expression) []string {
+	seen := map[string]bool{}
+	var walk func(Expression)
+	walk = func(e Expression) {
+		switch a := e.(type) {
+		case *AccountBalance:
+			seen[a.accountID] = true
+		case *AccountSelector:
+			seen[a.ToString()] = true
+		}
+		for _, kid := range children(e) {
+			walk(kid)
+		}
+	}
+	walk(expr)
+
+	accounts := make([]string, 0, len(seen))
+	for id := range seen {
+		accounts = append(accounts, id)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+// --- Compilation ---
+
+// Frame holds one context's balances by slot, so a compiled Program reads them
+// by index instead of looking each one up in a map
+type Frame struct {
+	values  []float64
+	known   []bool
+	context map[string]float64 // used by account selectors and by node types Compile doesn't know
+	loaded  bool               // context matches the slots; false once Set changes a slot
+}
+
+// Set stores the balance for a slot; slots are numbered as in Program.Slots.
+// The frame no longer matches any context loaded before, so parts of a program
+// that read the context, such as selectors, fail with ErrFrameNotLoaded until
+// the next Load.
+func (f *Frame) Set(slot int, balance float64) {
+	f.values[slot], f.known[slot] = balance, true
+	f.context, f.loaded = nil, false
+}
+
+// loadedContext returns the context the frame was loaded from, for expr to read
+func (f *Frame) loadedContext(expr Expression) (map[string]float64, error) {
+	if !f.loaded {
+		return nil, &EvalError{Expr: expr.ToString(), Err: ErrFrameNotLoaded}
+	}
+	return f.context, nil
+}
+
+type compiledExpr func(f *Frame) (Value, error)
+
+// Program is an Expression compiled to a tree of closures. Every account is
+// resolved to a slot once, at compile time, and operators are chosen once
+// instead of through the Expression interface on every evaluation.
+type Program struct {
+	slots []string
+	index map[string]int
+	run   compiledExpr
+}
+
+// Compile turns an expression into a Program whose results and errors match
+// expr.Interpret exactly
+func Compile(expr Expression) *Program {
+	p := &Program{index: make(map[string]int)}
+	p.run = p.compile(expr)
+	return p
+}
+
+// Slots lists the account behind each slot, in slot order
+func (p *Program) Slots() []string {
+	return p.slots
+}
+
+// NewFrame returns an empty frame sized for this program; reuse it across contexts
+func (p *Program) NewFrame() *Frame {
+	return &Frame{values: make([]float64, len(p.slots)), known: make([]bool, len(p.slots))}
+}
+
+// Load fills a frame from a context map, one lookup per slot
+func (p *Program) Load(f *Frame, context map[string]float64) {
+	for i, id := range p.slots {
+		f.values[i], f.known[i] = context[id]
+	}
+	f.context, f.loaded = context, true
+}
+
+// Run evaluates the program against a loaded frame
+func (p *Program) Run(f *Frame) (Value, error) {
+	return p.run(f)
+}
+
+// Eval loads a fresh frame from context and runs the program; use Load and Run in hot loops
+func (p *Program) Eval(context map[string]float64) (Value, error) {
+	f := p.NewFrame()
+	p.Load(f, context)
+	return p.run(f)
+}
+
+func (p *Program) slot(accountID string) int {
+	if i, ok := p.index[accountID]; ok {
+		return i
+	}
+	p.index[accountID] = len(p.slots)
+	p.slots = append(p.slots, accountID)
+	return len(p.slots) - 1
+}
+
+// compileAs wraps an operand so it fails like evalAs when it produces the wrong kind
+func compileAs(parent Expression, operand compiledExpr, want Kind) compiledExpr {
+	return func(f *Frame) (Value, error) {
+		v, err := operand(f)
+		if err != nil {
+			return Value{}, err
+		}
+		if v.kind != want {
+			return Value{}, &TypeError{Expr: parent.ToString(), Want: want, Got: v.kind}
+		}
+		return v, nil
+	}
+}
+
+type numberExpr func(f *Frame) (float64, error)
+
+// compileNumber compiles an operand of parent that must be a number. Sub-trees
+// that can only produce numbers skip the Value wrapping and the kind check.
+func (p *Program) compileNumber(parent, expr Expression) numberExpr {
+	switch e := expr.(type) {
+	case *Number:
+		v := e.value
+		return func(*Frame) (float64, error) { return v, nil }
+	case *AccountBalance:
+		slot := p.slot(e.accountID)
+		return func(f *Frame) (float64, error) {
+			if !f.known[slot] {
+				return 0, &EvalError{Expr: e.ToString(), Err: ErrUnknownAccount}
+			}
+			return finiteNumber(e, f.values[slot])
+		}
+	case *Add:
+		return p.compileArithmetic(e, e.left, e.right, func(l, r float64) (float64, error) { return finiteNumber(e, l+r) })
+	case *Subtract:
+		return p.compileArithmetic(e, e.left, e.right, func(l, r float64) (float64, error) { return finiteNumber(e, l-r) })
+	case *Multiply:
+		return p.compileArithmetic(e, e.left, e.right, func(l, r float64) (float64, error) { return finiteNumber(e, l*r) })
+	case *Divide:
+		return p.compileArithmetic(e, e.left, e.right, func(l, r float64) (float64, error) {
+			if r == 0 {
+				return 0, &EvalError{Expr: e.ToString(), Err: ErrDivisionByZero}
+			}
+			return finiteNumber(e, l/r)
+		})
+	case *Modulo:
+		return p.compileArithmetic(e, e.left, e.right, func(l, r float64) (float64, error) {
+			if r == 0 {
+				return 0, &EvalError{Expr: e.ToString(), Err: ErrDivisionByZero}
+			}
+			return finiteNumber(e, math.Mod(l, r))
+		})
+	}
+	operand := compileAs(parent, p.compile(expr), KindNumber)
+	return func(f *Frame) (float64, error) {
+		v, err := operand(f)
+		return v.number, err
+	}
+}
+
+// compileArithmetic builds a binary operator over numbers; op sees both operands already checked
+func (p *Program) compileArithmetic(parent, left, right Expression, op func(l, r float64) (float64, error)) numberExpr {
+	l, r := p.compileNumber(parent, left), p.compileNumber(parent, right)
+	return func(f *Frame) (float64, error) {
+		lv, err := l(f)
+		if err != nil {
+			return 0, err
+		}
+		rv, err := r(f)
+		if err != nil {
+			return 0, err
+		}
+		return op(lv, rv)
+	}
+}
+
+// compileComparison builds a binary operator from two numbers to a boolean
+func (p *Program) compileComparison(parent, left, right Expression, op func(l, r float64) bool) compiledExpr {
+	l, r := p.compileNumber(parent, left), p.compileNumber(parent, right)
+	return func(f *Frame) (Value, error) {
+		lv, err := l(f)
+		if err != nil {
+			return Value{}, err
+		}
+		rv, err := r(f)
+		if err != nil {
+			return Value{}, err
+		}
+		return BoolValue(op(lv, rv)), nil
+	}
+}
+
+func (p *Program) compile(expr Expression) compiledExpr {
+	switch e := expr.(type) {
+	case *Number:
+		v := NumberValue(e.value)
+		return func(*Frame) (Value, error) { return v, nil }
+	case *Bool:
+		v := BoolValue(e.value)
+		return func(*Frame) (Value, error) { return v, nil }
+	case *AccountBalance, *Add, *Subtract, *Multiply, *Divide, *Modulo:
+		number := p.compileNumber(nil, e)
+		return func(f *Frame) (Value, error) {
+			n, err := number(f)
+			if err != nil {
+				return Value{}, err
+			}
+			return NumberValue(n), nil
+		}
+	case *GreaterThan:
+		return p.compileComparison(e, e.left, e.right, func(l, r float64) bool { return l > r })
+	case *LessThan:
+		return p.compileComparison(e, e.left, e.right, func(l, r float64) bool { return l < r })
+	case *Equals:
+		l, r := p.compile(e.left), p.compile(e.right)
+		return func(f *Frame) (Value, error) {
+			lv, err := l(f)
+			if err != nil {
+				return Value{}, err
+			}
+			rv, err := r(f)
+			if err != nil {
+				return Value{}, err
+			}
+			if rv.kind != lv.kind {
+				return Value{}, &TypeError{Expr: e.ToString(), Want: lv.kind, Got: rv.kind}
+			}
+			if lv.kind == KindBool {
+				return BoolValue(lv.boolean == rv.boolean), nil
+			}
+			return BoolValue(lv.number == rv.number), nil
+		}
+	case *And:
+		l := compileAs(e, p.compile(e.left), KindBool)
+		r := compileAs(e, p.compile(e.right), KindBool)
+		return func(f *Frame) (Value, error) {
+			lv, err := l(f)
+			if err != nil || !lv.boolean {
+				return lv, err
+			}
+			return r(f)
+		}
+	case *Or:
+		l := compileAs(e, p.compile(e.left), KindBool)
+		r := compileAs(e, p.compile(e.right), KindBool)
+		return func(f *Frame) (Value, error) {
+			lv, err := l(f)
+			if err != nil || lv.boolean {
+				return lv, err
+			}
+			return r(f)
+		}
+	case *Not:
+		operand := compileAs(e, p.compile(e.operand), KindBool)
+		return func(f *Frame) (Value, error) {
+			v, err := operand(f)
+			if err != nil {
+				return Value{}, err
+			}
+			return BoolValue(!v.boolean), nil
+		}
+	case *If:
+		condition := compileAs(e, p.compile(e.condition), KindBool)
+		then, otherwise := p.compile(e.then), p.compile(e.otherwise)
+		return func(f *Frame) (Value, error) {
+			c, err := condition(f)
+			if err != nil {
+				return Value{}, err
+			}
+			if c.boolean {
+				return then(f)
+			}
+			return otherwise(f)
+		}
+	case *FunctionCall:
+		args := make([]func(f *Frame, values []float64) ([]float64, error), len(e.args))
+		for i, arg := range e.args {
+			if selector, ok := arg.(*AccountSelector); ok {
+				args[i] = func(f *Frame, values []float64) ([]float64, error) {
+					context, err := f.loadedContext(selector)
+					if err != nil {
+						return nil, err
+					}
+					ids, err := selector.Matches(context)
+					if err != nil {
+						return nil, err
+					}
+					for _, id := range ids {
+						v, err := finite(selector, context[id])
+						if err != nil {
+							return nil, err
+						}
+						values = append(values, v.number)
+					}
+					return values, nil
+				}
+				continue
+			}
+			operand := compileAs(e, p.compile(arg), KindNumber)
+			args[i] = func(f *Frame, values []float64) ([]float64, error) {
+				v, err := operand(f)
+				if err != nil {
+					return nil, err
+				}
+				return append(values, v.number), nil
+			}
+		}
+		return func(f *Frame) (Value, error) {
+			values := make([]float64, 0, len(args))
+			for _, arg := range args {
+				var err error
+				if values, err = arg(f, values); err != nil {
+					return Value{}, err
+				}
+			}
+			result, err := e.fn(values)
+			if err != nil {
+				return Value{}, &EvalError{Expr: e.ToString(), Err: err}
+			}
+			return finite(e, result)
+		}
+	default:
+		// Selectors on their own and custom node types read the context map directly
+		return func(f *Frame) (Value, error) {
+			context, err := f.loadedContext(expr)
+			if err != nil {
+				return Value{}, err
+			}
+			return expr.Interpret(context)
+		}
+	}
+}
+
+// --- Rule Engine ---
+
+// RuleAction is what should happen when a rule's condition holds
+type RuleAction string
+
+const (
+	ActionFlag   RuleAction = "flag"   // mark the account for review
+	ActionNotify RuleAction = "notify" // tell someone, e.g. treasury
+	ActionBlock  RuleAction = "block"  // stop outgoing payments
+)
+
+func (a RuleAction) valid() bool {
+	return a == ActionFlag || a == ActionNotify || a == ActionBlock
+}
+
+// Rule is a named condition with an action; rules with a higher Priority are evaluated first
+type Rule struct {
+	Name      string
+	Condition Expression
+	Action    RuleAction
+	Priority  int
+}
+
+// Snapshot is one balance context from the stream, e.g. a customer's accounts at a point in time
+type Snapshot struct {
+	ID       string
+	Balances map[string]float64
+}
+
+// FiredRule records a rule whose condition held for a snapshot
+type FiredRule struct {
+	Rule     string
+	Action   RuleAction
+	Priority int
+}
+
+// Evaluation is the outcome of running every applicable rule against one snapshot
+type Evaluation struct {
+	SnapshotID string
+	Fired      []FiredRule // in evaluation order
+	Errors     []error     // rules that failed to evaluate; they don't stop the others
+	Blocked    bool        // true if any fired rule's action is block
+}
+
+type engineRule struct {
+	Rule
+	program *Program
+	frame   *Frame
+}
+
+// RuleEngine evaluates a rule set against balance snapshots and counts how often
+// each rule fires. It is safe for concurrent use.
+type RuleEngine struct {
+	mu               sync.Mutex
+	rules            []*engineRule
+	stopOnFirstMatch bool
+	fired            map[string]int
+}
+
+// NewRuleEngine returns an empty engine. With stopOnFirstMatch, evaluation of a
+// snapshot stops at the first rule that fires, so only the highest-priority match is reported.
+func NewRuleEngine(stopOnFirstMatch bool) *RuleEngine {
+	return &RuleEngine{stopOnFirstMatch: stopOnFirstMatch, fired: make(map[string]int)}
+}
+
+// AddRule compiles and adds a rule. Rules with equal priority keep the order they were added in.
+func (e *RuleEngine) AddRule(rule Rule) error {
+	return e.addRules([]Rule{rule})
+}
+
+// addRules checks and compiles every rule before adding any, so on error the
+// engine is left as it was
+func (e *RuleEngine) addRules(rules []Rule) error {
+	compiled := make([]*engineRule, 0, len(rules))
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return errors.New("rule has no name")
+		}
+		if !rule.Action.valid() {
+			return fmt.Errorf("rule %q: unknown action %q", rule.Name, rule.Action)
+		}
+		if kind, ok := staticKind(rule.Condition); ok && kind != KindBool {
+			return fmt.Errorf("rule %q: condition %s is a %s, not a bool", rule.Name, rule.Condition.ToString(), kind)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %q is defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+		program := Compile(rule.Condition)
+		compiled = append(compiled, &engineRule{Rule: rule, program: program, frame: program.NewFrame()})
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, existing := range e.rules {
+		if names[existing.Name] {
+			return fmt.Errorf("rule %q is defined twice", existing.Name)
+		}
+	}
+	for _, added := range compiled {
+		i := len(e.rules)
+		for i > 0 && e.rules[i-1].Priority < added.Priority {
+			i--
+		}
+		e.rules = slices.Insert(e.rules, i, added)
+	}
+	return nil
+}
+
+// Evaluate runs the rules against one snapshot, highest priority first
+func (e *RuleEngine) Evaluate(snapshot Snapshot) Evaluation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	result := Evaluation{SnapshotID: snapshot.ID}
+	for _, rule := range e.rules {
+		rule.program.Load(rule.frame, snapshot.Balances)
+		v, err := rule.program.Run(rule.frame)
+		if err == nil && v.Kind() != KindBool {
+			err = &TypeError{Expr: rule.Condition.ToString(), Want: KindBool, Got: v.Kind()}
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("rule %q: %w", rule.Name, err))
+			continue
+		}
+		if !v.Bool() {
+			continue
+		}
+		e.fired[rule.Name]++
+		result.Fired = append(result.Fired, FiredRule{Rule: rule.Name, Action: rule.Action, Priority: rule.Priority})
+		if rule.Action == ActionBlock {
+			result.Blocked = true
+		}
+		if e.stopOnFirstMatch {
+			break
+		}
+	}
+	return result
+}
+
+// Run evaluates snapshots as they arrive and closes the returned channel once snapshots is closed
+func (e *RuleEngine) Run(snapshots <-chan Snapshot) <-chan Evaluation {
+	results := make(chan Evaluation)
+	go func() {
+		defer close(results)
+		for snapshot := range snapshots {
+			results <- e.Evaluate(snapshot)
+		}
+	}()
+	return results
+}
+
+// FiredCounts returns how many snapshots each rule has fired for so far; rules that never fired have 0
+func (e *RuleEngine) FiredCounts() map[string]int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	counts := make(map[string]int, len(e.rules))
+	for _, rule := range e.rules {
+		counts[rule.Name] = e.fired[rule.Name]
+	}
+	return counts
+}
+
+// Rules lists the rule names in evaluation order
+func (e *RuleEngine) Rules() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	names := make([]string, len(e.rules))
+	for i, rule := range e.rules {
+		names[i] = rule.Name
+	}
+	return names
+}
+
+// LoadRules reads a rule file, resolving function calls against functions, and
+// adds every rule to the engine. It is all or nothing: if any rule fails to
+// parse or compile, or clashes with a loaded rule, no rule from the file is added.
+func (e *RuleEngine) LoadRules(path string, functions *FunctionRegistry) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	rules, err := ParseRules(string(data), functions)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := e.addRules(rules); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// ParseRules parses the rule file format: a header line naming the rule and its
+// settings, followed by its condition in the expression syntax, which may span
+// several lines. Lines starting with # are comments.
+//
+//	[low-checking] action=block priority=100
+//	CHK001 < 0
+//
+// Syntax errors report their line and column within the whole file.
+func ParseRules(src string, functions *FunctionRegistry) ([]Rule, error) {
+	var rules []Rule
+	var current *Rule
+	var condition []string
+	conditionLine := 0
+
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		text := strings.Join(condition, "\n")
+		if strings.TrimSpace(text) == "" {
+			return &SyntaxError{Line: conditionLine, Col: 1, Msg: fmt.Sprintf("rule %q has no condition", current.Name)}
+		}
+		expr, err := functions.Parse(text)
+		var syntaxErr *SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return &SyntaxError{Line: syntaxErr.Line + conditionLine - 1, Col: syntaxErr.Col, Msg: fmt.Sprintf("rule %q: %s", current.Name, syntaxErr.Msg)}
+		}
+		if err != nil {
+			return err
+		}
+		current.Condition = expr
+		rules = append(rules, *current)
+		return nil
+	}
+
+	for i, line := range strings.Split(src, "\n") {
+		lineNo := i + 1
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			condition = append(condition, "") // keep line numbers aligned
+			continue
+		}
+		if !strings.HasPrefix(trimmed, "[") {
+			if current == nil && trimmed != "" {
+				return nil, &SyntaxError{Line: lineNo, Col: 1, Msg: "expected a [rule-name] header"}
+			}
+			condition = append(condition, line)
+			continue
+		}
+
+		if err := finish(); err != nil {
+			return nil, err
+		}
+		header, err := parseRuleHeader(trimmed, lineNo)
+		if err != nil {
+			return nil, err
+		}
+		current, condition, conditionLine = header, nil, lineNo+1
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// parseRuleHeader parses "[name] action=block priority=100"; action is required, priority defaults to 0
+func parseRuleHeader(header string, lineNo int) (*Rule, error) {
+	end := strings.Index(header, "]")
+	if end < 0 {
+		return nil, &SyntaxError{Line: lineNo, Col: len(header) + 1, Msg: "expected \"]\" after rule name"}
+	}
+	rule := &Rule{Name: strings.TrimSpace(header[1:end])}
+	if rule.Name == "" {
+		return nil, &SyntaxError{Line: lineNo, Col: 2, Msg: "rule name is empty"}
+	}
+	for _, setting := range strings.Fields(header[end+1:]) {
+		col := strings.Index(header, setting) + 1
+		key, value, ok := strings.Cut(setting, "=")
+		switch {
+		case !ok:
+			return nil, &SyntaxError{Line: lineNo, Col: col, Msg: fmt.Sprintf("expected key=value, found %q", setting)}
+		case key == "action":
+			rule.Action = RuleAction(value)
+			if !rule.Action.valid() {
+				return nil, &SyntaxError{Line: lineNo, Col: col, Msg: fmt.Sprintf("unknown action %q (want flag, notify or block)", value)}
+			}
+		case key == "priority":
+			priority, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, &SyntaxError{Line: lineNo, Col: col, Msg: fmt.Sprintf("priority must be an integer, found %q", value)}
+			}
+			rule.Priority = priority
+		default:
+			return nil, &SyntaxError{Line: lineNo, Col: col, Msg: fmt.Sprintf("unknown setting %q", key)}
+		}
+	}
+	if rule.Action == "" {
+		return nil, &SyntaxError{Line: lineNo, Col: 1, Msg: fmt.Sprintf("rule %q has no action", rule.Name)}
+	}
+	return rule, nil
+}
+
+// --- JSON AST ---
+
+// ASTVersion is the JSON AST format version written by MarshalExpression
+const ASTVersion = 1
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported AST version")
+	ErrNotSerializable    = errors.New("expression type cannot be serialized")
+)
+
+// astDocument is the top level of the JSON format
+type astDocument struct {
+	Version    int      `json:"version"`
+	Expression *astNode `json:"expression"`
+}
+
+// astNode is one node of the JSON format. Operands hold a non-terminal's
+// operands in evaluation order: left and right, the operand of "not", the
+// condition, then and else of "if", or a call's arguments.
+type astNode struct {
+	Type     string          `json:"type"`
+	Value    json.RawMessage `json:"value,omitempty"`   // number or bool literal
+	Account  string          `json:"account,omitempty"` // account ID, or a selector's prefix
+	Name     string          `json:"name,omitempty"`    // function name
+	Operands []*astNode      `json:"operands,omitempty"`
+}
+
+// astOperators maps each non-terminal's JSON type to an empty node, used as a template by withChildren
+var astOperators = map[string]Expression{
+	"add":          &Add{},
+	"subtract":     &Subtract{},
+	"multiply":     &Multiply{},
+	"divide":       &Divide{},
+	"modulo":       &Modulo{},
+	"greater_than": &GreaterThan{},
+	"less_than":    &LessThan{},
+	"equals":       &Equals{},
+	"and":          &And{},
+	"or":           &Or{},
+	"not":          &Not{},
+	"if":           &If{},
+}
+
+// MarshalExpression encodes an expression as a versioned JSON AST. Numbers keep
+// their full precision, unlike ToString, which rounds to two decimals.
+func MarshalExpression(expr Expression) ([]byte, error) {
+	node, err := toAST(expr)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(astDocument{Version: ASTVersion, Expression: node})
+}
+
+func toAST(expr Expression) (*astNode, error) {
+	switch e := expr.(type) {
+	case *Number:
+		value, err := json.Marshal(e.value)
+		if err != nil {
+			return nil, fmt.Errorf("number %v: %w", e.value, err)
+		}
+		return &astNode{Type: "number", Value: value}, nil
+	case *Bool:
+		return &astNode{Type: "bool", Value: json.RawMessage(strconv.FormatBool(e.value))}, nil
+	case *AccountBalance:
+		return &astNode{Type: "account", Account: e.accountID}, nil
+	case *AccountSelector:
+		return &astNode{Type: "selector", Account: e.prefix}, nil
+	}
+
+	node := &astNode{}
+	if call, ok := expr.(*FunctionCall); ok {
+		node.Type, node.Name = "call", call.name
+	} else {
+		node.Type = astOperatorType(expr)
+		if node.Type == "" {
+			return nil, fmt.Errorf("%T: %w", expr, ErrNotSerializable)
+		}
+	}
+	for _, operand := range children(expr) {
+		child, err := toAST(operand)
+		if err != nil {
+			return nil, err
+		}
+		node.Operands = append(node.Operands, child)
+	}
+	return node, nil
+}
+
+// astOperatorType is the JSON type of a non-terminal, the reverse of astOperators
+func astOperatorType(expr Expression) string {
+	switch expr.(type) {
+	case *Add:
+		return "add"
+	case *Subtract:
+		return "subtract"
+	case *Multiply:
+		return "multiply"
+	case *Divide:
+		return "divide"
+	case *Modulo:
+		return "modulo"
+	case *GreaterThan:
+		return "greater_than"
+	case *LessThan:
+		return "less_than"
+	case *Equals:
+		return "equals"
+	case *And:
+		return "and"
+	case *Or:
+		return "or"
+	case *Not:
+		return "not"
+	case *If:
+		return "if"
+	}
+	return ""
+}
+
+// UnmarshalExpression decodes a JSON AST written by MarshalExpression. Function
+// calls are resolved against functions, and unknown fields, node types or
+// functions are rejected.
+func UnmarshalExpression(data []byte, functions *FunctionRegistry) (Expression, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var doc astDocument
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode AST: %w", err)
+	}
+	if doc.Version != ASTVersion {
+		return nil, fmt.Errorf("version %d: %w", doc.Version, ErrUnsupportedVersion)
+	}
+	if doc.Expression == nil {
+		return nil, errors.New("decode AST: missing expression")
+	}
+	return fromAST(doc.Expression, functions, "expression")
+}
+
+// fromAST rebuilds a node; path locates it in the document for error messages, e.g. expression.operands[1]
+func fromAST(node *astNode, functions *FunctionRegistry, path string) (Expression, error) {
+	if node == nil {
+		return nil, fmt.Errorf("%s: missing node", path)
+	}
+	switch node.Type {
+	case "number":
+		var value float64
+		if err := json.Unmarshal(node.Value, &value); err != nil {
+			return nil, fmt.Errorf("%s: number needs a numeric value", path)
+		}
+		return &Number{value: value}, nil
+	case "bool":
+		var value bool
+		if err := json.Unmarshal(node.Value, &value); err != nil {
+			return nil, fmt.Errorf("%s: bool needs a true or false value", path)
+		}
+		return &Bool{value: value}, nil
+	case "account":
+		if node.Account == "" {
+			return nil, fmt.Errorf("%s: account needs an account ID", path)
+		}
+		return &AccountBalance{accountID: node.Account}, nil
+	case "selector":
+		return &AccountSelector{prefix: node.Account}, nil
+	}
+
+	operands := make([]Expression, len(node.Operands))
+	for i, child := range node.Operands {
+		operand, err := fromAST(child, functions, fmt.Sprintf("%s.operands[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		operands[i] = operand
+	}
+	if node.Type == "call" {
+		fn, ok := functions.Lookup(node.Name)
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown function %q", path, node.Name)
+		}
+		return &FunctionCall{name: strings.ToUpper(node.Name), fn: fn, args: operands}, nil
+	}
+	template, ok := astOperators[node.Type]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown node type %q", path, node.Type)
+	}
+	if want := len(children(template)); len(operands) != want {
+		return nil, fmt.Errorf("%s: %s needs %d operands, got %d", path, node.Type, want, len(operands))
+	}
+	return withChildren(template, operands), nil
+}
+
 func evaluateExpression(expr Expression, context map[string]float64) {
 	fmt.Printf("Expression: %s\n", expr.ToString())
 	fmt.Printf("Context: %v\n", context)
-	result := expr.Interpret(context)
-	fmt.Printf("Result: %.2f\n\n", result)
+	result, err := expr.Interpret(context)
+	if err != nil {
+		fmt.Printf("Error: %v\n\n", err)
+		return
+	}
+	fmt.Printf("Result: %s (%s)\n\n", result, result.Kind())
 }
 
 func main() {
@@ -112,19 +1979,19 @@ func main() {
 
 	// Example 1: Simple balance calculation
 	fmt.Println("\n--- Example 1: Balance Calculation ---")
-	
+
 	// ACC001 + 500
 	expr1 := &Add{
 		left:  &AccountBalance{accountID: "ACC001"},
 		right: &Number{value: 500.0},
 	}
-	
+
 	context1 := map[string]float64{"ACC001": 1000.0}
 	evaluateExpression(expr1, context1)
 
 	// Example 2: Complex calculation
 	fmt.Println("--- Example 2: Complex Calculation ---")
-	
+
 	// (ACC001 + ACC002) * 0.1
 	expr2 := &Multiply{
 		left: &Add{
@@ -133,28 +2000,28 @@ func main() {
 		},
 		right: &Number{value: 0.1},
 	}
-	
+
 	context2 := map[string]float64{"ACC001": 5000.0, "ACC002": 3000.0}
 	evaluateExpression(expr2, context2)
 
 	// Example 3: Balance comparison
 	fmt.Println("--- Example 3: Balance Comparison ---")
-	
+
 	// ACC001 > 10000
 	expr3 := &GreaterThan{
 		left:  &AccountBalance{accountID: "ACC001"},
 		right: &Number{value: 10000.0},
 	}
-	
+
 	context3 := map[string]float64{"ACC001": 15000.0}
 	evaluateExpression(expr3, context3)
-	
+
 	context4 := map[string]float64{"ACC001": 5000.0}
 	evaluateExpression(expr3, context4)
 
 	// Example 4: Net worth calculation
 	fmt.Println("--- Example 4: Net Worth Calculation ---")
-	
+
 	// (ACC001 + ACC002) - ACC003
 	expr4 := &Subtract{
 		left: &Add{
@@ -163,7 +2030,7 @@ func main() {
 		},
 		right: &AccountBalance{accountID: "ACC003"},
 	}
-	
+
 	context5 := map[string]float64{
 		"ACC001": 10000.0,
 		"ACC002": 5000.0,
@@ -171,9 +2038,357 @@ func main() {
 	}
 	evaluateExpression(expr4, context5)
 
+	// Example 5: Rules written as text instead of built by hand
+	fmt.Println("--- Example 5: Parsing Rule Text ---")
+
+	rules := []string{
+		"ACC001 + ACC002 * 2 > 5000",
+		"(ACC001 + ACC002) * 2 > 5000",
+		"ACC001 - ACC002 - ACC003",
+	}
+	for _, rule := range rules {
+		expr, err := ParseExpression(rule)
+		if err != nil {
+			fmt.Printf("Rule %q: %v\n\n", rule, err)
+			continue
+		}
+		fmt.Printf("Rule: %s\n", rule)
+		evaluateExpression(expr, context5)
+	}
+
+	// Mistakes are reported with the line and column where parsing stopped
+	invalid := []string{
+		"ACC001 + * 2",
+		"(ACC001 + ACC002\n  * 0.1",
+		"ACC001 > 10000 $",
+	}
+	for _, rule := range invalid {
+		if _, err := ParseExpression(rule); err != nil {
+			fmt.Printf("Rule %q\n  ✗ %v\n", rule, err)
+		}
+	}
+	fmt.Println()
+
+	// Example 6: Booleans, the full operator set and conditionals
+	fmt.Println("--- Example 6: Typed Logic and Conditionals ---")
+
+	context6 := map[string]float64{"CHK001": 1200.0, "SAV001": 25000.0, "LOAN01": 7500.0}
+	typedRules := []string{
+		"SAV001 / 12 > 2000 AND NOT CHK001 < 1000",
+		"LOAN01 % 1000 == 500 OR CHK001 == 0",
+		"IF CHK001 < 1000 THEN SAV001 * 0.01 ELSE 0",
+		"IF CHK001 > 1000 THEN TRUE ELSE LOAN01 > SAV001",
+		// Type errors: numbers and booleans don't mix
+		"CHK001 + (SAV001 > 0)",
+		"CHK001 AND SAV001",
+		"IF LOAN01 THEN 1 ELSE 2",
+	}
+	for _, rule := range typedRules {
+		expr, err := ParseExpression(rule)
+		if err != nil {
+			fmt.Printf("Rule %q: %v\n\n", rule, err)
+			continue
+		}
+		evaluateExpression(expr, context6)
+	}
+
+	// Example 7: A typo or bad input stops evaluation instead of quietly using 0
+	fmt.Println("--- Example 7: Evaluation Errors ---")
+
+	context7 := map[string]float64{"CHK001": 1200.0, "SAV001": 25000.0, "EMPTY1": 0.0, "BIG001": 1e308}
+	faultyRules := []string{
+		"CHK001 + SAV01 > 5000",
+		"SAV001 / (EMPTY1 * 2) > 100",
+		"BIG001 * 10 > CHK001",
+		// Only the chosen branch is evaluated, so a guard avoids the division
+		"IF EMPTY1 == 0 THEN 0 ELSE SAV001 / EMPTY1",
+	}
+	for _, rule := range faultyRules {
+		expr, err := ParseExpression(rule)
+		if err != nil {
+			fmt.Printf("Rule %q: %v\n\n", rule, err)
+			continue
+		}
+		evaluateExpression(expr, context7)
+	}
+
+	// Callers can branch on the cause and still show users where the rule broke
+	interest := &Divide{left: &AccountBalance{accountID: "SAV001"}, right: &AccountBalance{accountID: "EMPTY1"}}
+	_, err := interest.Interpret(context7)
+	var evalErr *EvalError
+	if errors.As(err, &evalErr) && errors.Is(err, ErrDivisionByZero) {
+		fmt.Printf("Rule skipped: %s divides by zero\n\n", evalErr.Expr)
+	}
+
+	// Example 8: Pre-processing rules before evaluating them many times
+	fmt.Println("--- Example 8: Folding, Simplification and Dependencies ---")
+
+	analysed := []string{
+		"CHK001 * (12 * 100 / 1200) + 0 > 500 * 2 AND TRUE",
+		"IF 1 > 2 THEN SAV001 ELSE CHK001 + LOAN01 - 0",
+		"NOT NOT (SAV001 > CHK001) OR FALSE",
+		"CHK001 * 0 + 1 / 0", // kept as is: the errors must still happen
+	}
+	for _, rule := range analysed {
+		expr, err := ParseExpression(rule)
+		if err != nil {
+			fmt.Printf("Rule %q: %v\n\n", rule, err)
+			continue
+		}
+		folded := FoldConstants(expr)
+		simplified := Simplify(expr)
+		fmt.Printf("Parsed:     %s\n", expr.ToString())
+		fmt.Printf("Folded:     %s\n", folded.ToString())
+		fmt.Printf("Simplified: %s\n", simplified.ToString())
+		fmt.Printf("Depends on: %v\n", Dependencies(simplified))
+
+		before, errBefore := expr.Interpret(context6)
+		after, errAfter := simplified.Interpret(context6)
+		if errBefore != nil || errAfter != nil {
+			fmt.Printf("Errors:     %v / %v\n\n", errBefore, errAfter)
+			continue
+		}
+		fmt.Printf("Results:    %s / %s\n\n", before, after)
+	}
+
+	// Example 9: Aggregates over groups of accounts
+	fmt.Println("--- Example 9: Functions and Account Selectors ---")
+
+	treasury := map[string]float64{
+		"SAV001": 40000.0, "SAV002": 25000.0, "SAV003": 5000.0,
+		"CHK001": 12000.0, "CHK002": 31000.0,
+	}
+	// Treasury's own function, the balance left after a reserve ratio, next to the built-ins
+	treasuryFunctions := NewFunctionRegistry()
+	err = treasuryFunctions.Register("RESERVE", func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("RESERVE takes a balance and a ratio: %w", ErrArity)
+		}
+		return args[0] * (1 - args[1]), nil
+	})
+	if err != nil {
+		fmt.Printf("  Register RESERVE: %v\n", err)
+	}
+	liquidityRules := []string{
+		"SUM(SAV*) > 2 * MAX(CHK001, CHK002)",
+		"AVG(SAV*) - MIN(SAV*)",
+		"COUNT(*) == 5 AND ABS(CHK001 - CHK002) > 10000",
+		"RESERVE(SUM(CHK*), 0.1)",
+		"SUM(LOAN*) > 0",                    // no account matches: an error, not 0
+		"SUM(SAV*) > CHK001 * MEDIAN(SAV*)", // unknown function: rejected when parsing
+	}
+	for _, rule := range liquidityRules {
+		expr, err := treasuryFunctions.Parse(rule)
+		if err != nil {
+			fmt.Printf("Rule %q\n  ✗ %v\n\n", rule, err)
+			continue
+		}
+		fmt.Printf("Depends on: %v\n", Dependencies(expr))
+		evaluateExpression(expr, treasury)
+	}
+
+	// Example 10: Scoring many contexts with a compiled rule
+	fmt.Println("--- Example 10: Compiled Evaluation ---")
+
+	scoring, _ := ParseExpression("IF CHK001 + CHK002 > 2 * SAV001 THEN (CHK001 - LOAN01) / SAV001 > 0.5 " +
+		"ELSE SAV001 % 1000 + LOAN01 * 0.05 > CHK002 / 4 AND NOT LOAN01 == 0")
+	program := Compile(scoring)
+	fmt.Printf("Rule:  %s\n", scoring.ToString())
+	fmt.Printf("Slots: %v\n", program.Slots())
+
+	// Deterministic contexts; a few have no loan account, so the error path is compared too
+	rng := rand.New(rand.NewPCG(7, 42))
+	contexts := make([]map[string]float64, 50000)
+	for i := range contexts {
+		ctx := map[string]float64{
+			"CHK001": float64(rng.IntN(20000)),
+			"CHK002": float64(rng.IntN(20000)),
+			"SAV001": float64(rng.IntN(40000)),
+		}
+		if i%1000 != 0 {
+			ctx["LOAN01"] = float64(rng.IntN(15000))
+		}
+		contexts[i] = ctx
+	}
+
+	const rounds = 10
+	type outcome struct {
+		value Value
+		err   string
+	}
+	interpreted := make([]outcome, len(contexts))
+	start := time.Now()
+	for range rounds {
+		for i, ctx := range contexts {
+			v, err := scoring.Interpret(ctx)
+			interpreted[i] = outcome{value: v}
+			if err != nil {
+				interpreted[i].err = err.Error()
+			}
+		}
+	}
+	interpretTime := time.Since(start)
+
+	compiled := make([]outcome, len(contexts))
+	frame := program.NewFrame()
+	start = time.Now()
+	for range rounds {
+		for i, ctx := range contexts {
+			program.Load(frame, ctx)
+			v, err := program.Run(frame)
+			compiled[i] = outcome{value: v}
+			if err != nil {
+				compiled[i].err = err.Error()
+			}
+		}
+	}
+	compileTime := time.Since(start)
+
+	// Callers that already hold balances by slot, e.g. columns of a batch, skip the map entirely
+	frames := make([]*Frame, len(contexts))
+	for i, ctx := range contexts {
+		frames[i] = program.NewFrame()
+		for slot, id := range program.Slots() {
+			if balance, ok := ctx[id]; ok {
+				frames[i].Set(slot, balance)
+			}
+		}
+	}
+	slotted := make([]outcome, len(contexts))
+	start = time.Now()
+	for range rounds {
+		for i, f := range frames {
+			v, err := program.Run(f)
+			slotted[i] = outcome{value: v}
+			if err != nil {
+				slotted[i].err = err.Error()
+			}
+		}
+	}
+	slottedTime := time.Since(start)
+
+	mismatches, failures := 0, 0
+	for i := range contexts {
+		if interpreted[i] != compiled[i] || interpreted[i] != slotted[i] {
+			mismatches++
+		}
+		if interpreted[i].err != "" {
+			failures++
+		}
+	}
+	evaluations := rounds * len(contexts)
+	fmt.Printf("Evaluations: %d (%d contexts fail, e.g. %s)\n", evaluations, failures, interpreted[0].err)
+	fmt.Printf("Interpret:   %v (%.0f ns/op)\n", interpretTime.Round(time.Millisecond), float64(interpretTime.Nanoseconds())/float64(evaluations))
+	fmt.Printf("Compiled:    %v (%.0f ns/op, %.1fx) loading frames from maps\n", compileTime.Round(time.Millisecond),
+		float64(compileTime.Nanoseconds())/float64(evaluations), float64(interpretTime)/float64(compileTime))
+	fmt.Printf("Compiled:    %v (%.0f ns/op, %.1fx) with pre-slotted frames\n", slottedTime.Round(time.Millisecond),
+		float64(slottedTime.Nanoseconds())/float64(evaluations), float64(interpretTime)/float64(slottedTime))
+	fmt.Printf("Mismatching results: %d\n\n", mismatches)
+
+	// Example 11: Alerting rules loaded from a file, run over a stream of snapshots
+	fmt.Println("--- Example 11: Rule Engine ---")
+
+	alerts := NewRuleEngine(false)
+	if err := alerts.LoadRules("alert-rules.txt", DefaultFunctions); err != nil {
+		fmt.Printf("Failed to load rules: %v\n", err)
+		return
+	}
+	fmt.Printf("Rules in evaluation order: %v\n", alerts.Rules())
+
+	snapshots := []Snapshot{
+		{ID: "cust-001", Balances: map[string]float64{"CHK001": 1200, "CHK002": 800, "SAV001": 9000, "SAV002": 4000}},
+		{ID: "cust-002", Balances: map[string]float64{"CHK001": -150, "CHK002": 300, "SAV001": 100}},
+		{ID: "cust-003", Balances: map[string]float64{"CHK001": 42000, "CHK002": 19000, "SAV001": 250}},
+		{ID: "cust-004", Balances: map[string]float64{"CHK001": 700, "CHK002": 900}}, // no savings: SUM(SAV*) fails
+	}
+	stream := make(chan Snapshot)
+	go func() {
+		defer close(stream)
+		for _, snapshot := range snapshots {
+			stream <- snapshot
+		}
+	}()
+	for evaluation := range alerts.Run(stream) {
+		fmt.Printf("%s: fired %v, blocked: %t\n", evaluation.SnapshotID, evaluation.Fired, evaluation.Blocked)
+		for _, err := range evaluation.Errors {
+			fmt.Printf("  ✗ %v\n", err)
+		}
+	}
+	fmt.Printf("Fired counts: %v\n", alerts.FiredCounts())
+
+	// Stop on first match: only the highest-priority rule that fires is reported
+	triage := NewRuleEngine(true)
+	if err := triage.LoadRules("alert-rules.txt", DefaultFunctions); err != nil {
+		fmt.Printf("Failed to load rules: %v\n", err)
+		return
+	}
+	fmt.Printf("Stop on first match, %s: fired %v\n", snapshots[1].ID, triage.Evaluate(snapshots[1]).Fired)
+
+	// Mistakes in a rule file point at the line and column within the file
+	_, err = ParseRules("[ok] action=flag\nCHK001 > 0\n\n[broken] action=notify\nCHK001 >\n  AND SAV001 < 0\n", DefaultFunctions)
+	fmt.Printf("Broken rule file: %v\n\n", err)
+
+	// Example 12: Handing rules to another service as JSON
+	fmt.Println("--- Example 12: JSON AST Round Trip ---")
+
+	shared := []string{
+		"ACC001 + ACC002 * 2 > 5000",
+		"IF CHK001 < 1000 THEN SAV001 * 0.015 ELSE -CHK001 / 3",
+		"NOT (LOAN01 % 1000 == 500) AND TRUE OR FALSE",
+		"SUM(SAV*) > 2 * MAX(CHK001, CHK002) AND COUNT(*) > 3",
+	}
+	roundTripContext := map[string]float64{
+		"ACC001": 4000, "ACC002": 900, "CHK001": 1234.5678, "CHK002": 800,
+		"SAV001": 25000, "SAV002": 120, "LOAN01": 7500,
+	}
+	for i, rule := range shared {
+		original, err := ParseExpression(rule)
+		if err != nil {
+			fmt.Printf("Rule %q: %v\n", rule, err)
+			continue
+		}
+		data, err := MarshalExpression(original)
+		if err != nil {
+			fmt.Printf("Marshal %q: %v\n", rule, err)
+			continue
+		}
+		if i == 0 {
+			fmt.Printf("JSON: %s\n", data)
+		}
+		decoded, err := UnmarshalExpression(data, DefaultFunctions)
+		if err != nil {
+			fmt.Printf("Unmarshal %q: %v\n", rule, err)
+			continue
+		}
+		want, wantErr := original.Interpret(roundTripContext)
+		got, gotErr := decoded.Interpret(roundTripContext)
+		same := decoded.ToString() == original.ToString() && want == got && fmt.Sprint(wantErr) == fmt.Sprint(gotErr)
+		fmt.Printf("%-60s %4d bytes, unchanged: %t (%s)\n", rule, len(data), same, got)
+	}
+
+	// Documents from a future format, or with unknown functions, are refused
+	for _, doc := range []string{
+		`{"version":2,"expression":{"type":"number","value":1}}`,
+		`{"version":1,"expression":{"type":"call","name":"MEDIAN","operands":[{"type":"selector","account":"SAV"}]}}`,
+		`{"version":1,"expression":{"type":"add","operands":[{"type":"number","value":1}]}}`,
+	} {
+		if _, err := UnmarshalExpression([]byte(doc), DefaultFunctions); err != nil {
+			fmt.Printf("  ✗ %v\n", err)
+		}
+	}
+	fmt.Println()
+
 	fmt.Println("✓ Interpreter pattern represents query grammar as class hierarchy")
 	fmt.Println("✓ Easy to change and extend query language")
 	fmt.Println("✓ Each grammar rule is a separate class")
 	fmt.Println("✓ Useful for transaction query language and rule engine")
+	fmt.Println("✓ A parser lets business users write rules as text")
+	fmt.Println("✓ Numbers and booleans are distinct types, so mixing them is an error")
+	fmt.Println("✓ Unknown accounts and invalid math are reported, not hidden")
+	fmt.Println("✓ Rules can be folded, simplified and scanned for dependencies up front")
+	fmt.Println("✓ Registered functions aggregate over groups of accounts")
+	fmt.Println("✓ Compiled rules resolve accounts to slots and match Interpret exactly")
+	fmt.Println("✓ A rule engine turns expressions into prioritised alerts")
+	fmt.Println("✓ Expressions round-trip losslessly through a versioned JSON AST")
 	fmt.Println("✓ JoshBank can evaluate complex financial expressions")
 }
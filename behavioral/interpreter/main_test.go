@@ -0,0 +1,929 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const scoringRule = "IF CHK001 + CHK002 > 2 * SAV001 THEN (CHK001 - LOAN01) / SAV001 > 0.5 " +
+	"ELSE SAV001 % 1000 + LOAN01 * 0.05 > CHK002 / 4 AND NOT LOAN01 == 0"
+
+func mustParse(tb testing.TB, src string) Expression {
+	tb.Helper()
+	expr, err := ParseExpression(src)
+	if err != nil {
+		tb.Fatalf("parse %q: %v", src, err)
+	}
+	return expr
+}
+
+// scoringContexts mirrors Example 10: every thousandth context has no loan account
+func scoringContexts(n int) []map[string]float64 {
+	rng := rand.New(rand.NewPCG(7, 42))
+	contexts := make([]map[string]float64, n)
+	for i := range contexts {
+		ctx := map[string]float64{
+			"CHK001": float64(rng.IntN(20000)),
+			"CHK002": float64(rng.IntN(20000)),
+			"SAV001": float64(rng.IntN(40000)),
+		}
+		if i%1000 != 0 {
+			ctx["LOAN01"] = float64(rng.IntN(15000))
+		}
+		contexts[i] = ctx
+	}
+	return contexts
+}
+
+func errText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func TestCompiledMatchesInterpret(t *testing.T) {
+	rules := []string{
+		scoringRule,
+		"CHK001 / (SAV001 - SAV001)",
+		"CHK001 > 100 AND MISSING > 0",
+		"FALSE AND MISSING > 0",
+		"TRUE + 1",
+		"NOT CHK001",
+		"IF CHK001 > 0 THEN SAV001 ELSE FALSE",
+		"SUM(SAV*) + CHK001",
+		"MAX(SAV*, CHK001 * 2) - MIN(LOAN*)",
+		"AVG(NONE*) > 1",
+		"ABS(CHK001, SAV001)",
+		"CHK001 % 0 == 0",
+	}
+	contexts := []map[string]float64{
+		{"CHK001": 5, "SAV001": 100, "SAV002": 200},
+		{"CHK001": -250, "SAV001": 0, "LOAN01": 900},
+		{},
+	}
+	for _, src := range rules {
+		expr := mustParse(t, src)
+		program := Compile(expr)
+		frame := program.NewFrame()
+		for _, ctx := range contexts {
+			want, wantErr := expr.Interpret(ctx)
+			got, gotErr := program.Eval(ctx)
+			if got != want || errText(gotErr) != errText(wantErr) {
+				t.Errorf("%s with %v: Eval = %v, %v; Interpret = %v, %v", src, ctx, got, gotErr, want, wantErr)
+			}
+			program.Load(frame, ctx)
+			got, gotErr = program.Run(frame)
+			if got != want || errText(gotErr) != errText(wantErr) {
+				t.Errorf("%s with %v: reused frame = %v, %v; Interpret = %v, %v", src, ctx, got, gotErr, want, wantErr)
+			}
+		}
+	}
+}
+
+func TestCompiledMatchesInterpretOverScoringContexts(t *testing.T) {
+	expr := mustParse(t, scoringRule)
+	program := Compile(expr)
+	frame := program.NewFrame()
+	for i, ctx := range scoringContexts(5000) {
+		want, wantErr := expr.Interpret(ctx)
+		program.Load(frame, ctx)
+		got, gotErr := program.Run(frame)
+		if got != want || errText(gotErr) != errText(wantErr) {
+			t.Fatalf("context %d: compiled = %v, %v; Interpret = %v, %v", i, got, gotErr, want, wantErr)
+		}
+	}
+}
+
+func TestFrameFilledBySet(t *testing.T) {
+	program := Compile(mustParse(t, "CHK001 + SAV001"))
+	frame := program.NewFrame()
+	for slot, id := range program.Slots() {
+		frame.Set(slot, map[string]float64{"CHK001": 5, "SAV001": 100}[id])
+	}
+	if got, err := program.Run(frame); err != nil || got != NumberValue(105) {
+		t.Errorf("Run = %v, %v; want 105", got, err)
+	}
+
+	selector := Compile(mustParse(t, "SUM(SAV*) + CHK001"))
+	frame = selector.NewFrame()
+	frame.Set(0, 5)
+	if _, err := selector.Run(frame); !errors.Is(err, ErrFrameNotLoaded) {
+		t.Errorf("selector on a Set-only frame: err = %v, want ErrFrameNotLoaded", err)
+	}
+}
+
+func TestFrameLoadedThenSet(t *testing.T) {
+	loaded := map[string]float64{"CHK001": 5, "SAV001": 100}
+
+	program := Compile(mustParse(t, "CHK001 + SAV001"))
+	frame := program.NewFrame()
+	program.Load(frame, loaded)
+	frame.Set(0, 50)
+	if got, err := program.Run(frame); err != nil || got != NumberValue(150) {
+		t.Errorf("Run after Set = %v, %v; want 150", got, err)
+	}
+
+	// The selector must not sum the loaded map while CHK001 comes from the slot
+	selector := Compile(mustParse(t, "SUM(SAV*) + CHK001"))
+	frame = selector.NewFrame()
+	selector.Load(frame, loaded)
+	if got, err := selector.Run(frame); err != nil || got != NumberValue(105) {
+		t.Fatalf("Run after Load = %v, %v; want 105", got, err)
+	}
+	frame.Set(0, 50)
+	if got, err := selector.Run(frame); !errors.Is(err, ErrFrameNotLoaded) {
+		t.Errorf("Run after Load then Set = %v, %v; want ErrFrameNotLoaded", got, err)
+	}
+	selector.Load(frame, loaded)
+	if got, err := selector.Run(frame); err != nil || got != NumberValue(105) {
+		t.Errorf("Run after reloading = %v, %v; want 105", got, err)
+	}
+}
+
+func BenchmarkInterpret(b *testing.B) {
+	expr := mustParse(b, scoringRule)
+	contexts := scoringContexts(1000)
+	for i := 0; b.Loop(); i++ {
+		expr.Interpret(contexts[i%len(contexts)])
+	}
+}
+
+func BenchmarkCompiledLoadRun(b *testing.B) {
+	program := Compile(mustParse(b, scoringRule))
+	contexts := scoringContexts(1000)
+	frame := program.NewFrame()
+	for i := 0; b.Loop(); i++ {
+		program.Load(frame, contexts[i%len(contexts)])
+		program.Run(frame)
+	}
+}
+
+func BenchmarkCompiledSlotted(b *testing.B) {
+	program := Compile(mustParse(b, scoringRule))
+	contexts := scoringContexts(1000)
+	frames := make([]*Frame, len(contexts))
+	for i, ctx := range contexts {
+		frames[i] = program.NewFrame()
+		for slot, id := range program.Slots() {
+			if balance, ok := ctx[id]; ok {
+				frames[i].Set(slot, balance)
+			}
+		}
+	}
+	for i := 0; b.Loop(); i++ {
+		program.Run(frames[i%len(frames)])
+	}
+}
+
+func TestLoadRulesIsAllOrNothing(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	good := write("good.txt", "[overdrawn] action=block priority=100\nCHK001 < 0\n")
+
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{name: "syntax error", src: "[fresh] action=flag\nCHK001 > 1\n\n[broken] action=flag\nCHK001 >\n"},
+		{name: "number condition", src: "[fresh] action=flag\nCHK001 > 1\n\n[sum] action=flag\nCHK001 + 1\n"},
+		{name: "duplicate in file", src: "[fresh] action=flag\nCHK001 > 1\n\n[fresh] action=notify\nCHK002 > 1\n"},
+		{name: "clashes with loaded rule", src: "[fresh] action=flag\nCHK001 > 1\n\n[overdrawn] action=notify\nCHK002 < 0\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewRuleEngine(false)
+			if err := engine.LoadRules(good, DefaultFunctions); err != nil {
+				t.Fatal(err)
+			}
+			if err := engine.LoadRules(write("bad.txt", tt.src), DefaultFunctions); err == nil {
+				t.Fatal("LoadRules succeeded, want an error")
+			}
+			if got := engine.Rules(); !slices.Equal(got, []string{"overdrawn"}) {
+				t.Errorf("rules after a failed load = %v, want [overdrawn]", got)
+			}
+		})
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	rules := []string{
+		"ACC001 + 500",
+		"(ACC001 - ACC002) * 0.123456789 / 3 % 7",
+		"ACC001 > 5000 AND NOT ACC002 < 100 OR ACC003 == 0",
+		"IF TRUE THEN ACC001 ELSE FALSE",
+		"SUM(SAV*) > MAX(CHK001, CHK002 * 2) AND ABS(LOAN01) < 1000",
+		"COUNT(NONE*) == 0",
+		scoringRule,
+	}
+	contexts := []map[string]float64{
+		{"ACC001": 7000, "ACC002": 50, "ACC003": 0, "SAV001": 10, "SAV002": 20, "CHK001": 1, "CHK002": 2, "LOAN01": -300, "CHK001X": 0},
+		{"ACC001": 1, "CHK001": 20000, "CHK002": 1, "SAV001": 3, "LOAN01": 0},
+		{},
+	}
+	for _, src := range rules {
+		t.Run(src, func(t *testing.T) {
+			expr := mustParse(t, src)
+			data, err := MarshalExpression(expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := UnmarshalExpression(data, DefaultFunctions)
+			if err != nil {
+				t.Fatalf("unmarshal %s: %v", data, err)
+			}
+			if decoded.ToString() != expr.ToString() {
+				t.Errorf("ToString = %s, want %s", decoded.ToString(), expr.ToString())
+			}
+			for _, ctx := range contexts {
+				want, wantErr := expr.Interpret(ctx)
+				got, gotErr := decoded.Interpret(ctx)
+				if got != want || errText(gotErr) != errText(wantErr) {
+					t.Errorf("with %v: Interpret = %v, %v; want %v, %v", ctx, got, gotErr, want, wantErr)
+				}
+			}
+			again, err := MarshalExpression(decoded)
+			if err != nil || string(again) != string(data) {
+				t.Errorf("re-marshalled = %s, %v; want %s", again, err, data)
+			}
+		})
+	}
+}
+
+func TestUnmarshalExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantIs  error  // sentinel to match, if any
+		wantMsg string // substring of the message
+	}{
+		{name: "newer version", json: `{"version":2,"expression":{"type":"number","value":1}}`, wantIs: ErrUnsupportedVersion},
+		{name: "missing version", json: `{"expression":{"type":"number","value":1}}`, wantIs: ErrUnsupportedVersion},
+		{name: "missing expression", json: `{"version":1}`, wantMsg: "missing expression"},
+		{name: "unknown node type", json: `{"version":1,"expression":{"type":"power","operands":[{"type":"number","value":2},{"type":"number","value":3}]}}`, wantMsg: `expression: unknown node type "power"`},
+		{name: "unknown nested node type", json: `{"version":1,"expression":{"type":"not","operands":[{"type":"xor"}]}}`, wantMsg: `expression.operands[0]: unknown node type "xor"`},
+		{name: "unknown function", json: `{"version":1,"expression":{"type":"call","name":"MEDIAN","operands":[{"type":"selector","account":"SAV"}]}}`, wantMsg: `unknown function "MEDIAN"`},
+		{name: "wrong operand count", json: `{"version":1,"expression":{"type":"add","operands":[{"type":"number","value":1}]}}`, wantMsg: "add needs 2 operands, got 1"},
+		{name: "null operand", json: `{"version":1,"expression":{"type":"not","operands":[null]}}`, wantMsg: "expression.operands[0]: missing node"},
+		{name: "unknown field", json: `{"version":1,"expression":{"type":"number","value":1,"unit":"USD"}}`, wantMsg: "unknown field"},
+		{name: "bad literal", json: `{"version":1,"expression":{"type":"bool","value":"yes"}}`, wantMsg: "bool needs a true or false value"},
+		{name: "account without ID", json: `{"version":1,"expression":{"type":"account"}}`, wantMsg: "account needs an account ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := UnmarshalExpression([]byte(tt.json), DefaultFunctions)
+			if err == nil {
+				t.Fatalf("decoded %s, want an error", expr.ToString())
+			}
+			if tt.wantIs != nil && !errors.Is(err, tt.wantIs) {
+				t.Errorf("err = %v, want %v", err, tt.wantIs)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+// pi is a node type the JSON format doesn't know
+type pi struct{}
+
+func (pi) Interpret(map[string]float64) (Value, error) { return NumberValue(3.14159), nil }
+func (pi) ToString() string                            { return "PI" }
+
+func TestMarshalExpressionRejectsUnknownNodes(t *testing.T) {
+	expr := &Add{left: &AccountBalance{accountID: "ACC001"}, right: pi{}}
+	if _, err := MarshalExpression(expr); !errors.Is(err, ErrNotSerializable) {
+		t.Errorf("err = %v, want ErrNotSerializable", err)
+	}
+}
+
+func TestParsePrecedenceAndAssociativity(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{src: "ACC001 - 100 - 50", want: "((ACC001 - 100.00) - 50.00)"},
+		{src: "ACC001 / 2 / 4", want: "((ACC001 / 2.00) / 4.00)"},
+		{src: "ACC001 + ACC002 * 2", want: "(ACC001 + (ACC002 * 2.00))"},
+		{src: "ACC001 * 2 % 3 + 1", want: "(((ACC001 * 2.00) % 3.00) + 1.00)"},
+		{src: "(ACC001 + ACC002) * 2", want: "((ACC001 + ACC002) * 2.00)"},
+		{src: "ACC001 - (100 - 50)", want: "(ACC001 - (100.00 - 50.00))"},
+		{src: "ACC001 + 1 > ACC002 * 2", want: "((ACC001 + 1.00) > (ACC002 * 2.00))"},
+		{src: "ACC001 > 1 OR ACC002 > 2 AND ACC003 > 3", want: "((ACC001 > 1.00) OR ((ACC002 > 2.00) AND (ACC003 > 3.00)))"},
+		{src: "NOT ACC001 > 5 AND ACC002 < 1", want: "((NOT (ACC001 > 5.00)) AND (ACC002 < 1.00))"},
+		{src: "-5 + ACC001", want: "(-5.00 + ACC001)"},
+		{src: "-ACC001 * 2", want: "((0.00 - ACC001) * 2.00)"},
+		{src: "--ACC001", want: "(0.00 - (0.00 - ACC001))"},
+		{src: "ACC001 - -50", want: "(ACC001 - -50.00)"},
+		{src: "-(ACC001 + 1)", want: "(0.00 - (ACC001 + 1.00))"},
+		{src: "IF ACC001 > 0 THEN 1 ELSE 2 + 3", want: "(IF (ACC001 > 0.00) THEN 1.00 ELSE (2.00 + 3.00))"},
+		{src: "acc001 > 1 and true", want: "((acc001 > 1.00) AND TRUE)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			if got := mustParse(t, tt.src).ToString(); got != tt.want {
+				t.Errorf("parsed as %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSubtractionIsLeftAssociative(t *testing.T) {
+	v, err := mustParse(t, "ACC001 - 100 - 50").Interpret(map[string]float64{"ACC001": 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != NumberValue(850) {
+		t.Errorf("ACC001 - 100 - 50 with ACC001=1000 = %v, want 850", v)
+	}
+}
+
+func TestParseSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		src     string
+		line    int
+		col     int
+		wantMsg string
+	}{
+		{src: "a\n  + )", line: 2, col: 5, wantMsg: `found ")"`},
+		{src: "ACC001 >", line: 1, col: 9, wantMsg: "found end of input"},
+		{src: "ACC001 +\n\n   * 2", line: 3, col: 4, wantMsg: `found "*"`},
+		{src: "(ACC001 + 1", line: 1, col: 12, wantMsg: `expected ")" to close "(" at line 1, col 1`},
+		{src: "ACC001 2", line: 1, col: 8, wantMsg: `unexpected "2" after expression`},
+		{src: "ACC001 $ 2", line: 1, col: 8, wantMsg: "unexpected character '$'"},
+		{src: "1.2.3 > ACC001", line: 1, col: 1, wantMsg: `invalid number "1.2.3"`},
+		{src: "IF ACC001 > 0 THEN 1", line: 1, col: 21, wantMsg: "expected ELSE"},
+		{src: "MEDIAN(SAV*)", line: 1, col: 1, wantMsg: `unknown function "MEDIAN"`},
+		{src: "SUM(SAV001\n  ACC001)", line: 2, col: 3, wantMsg: `expected "," or ")" to close "(" at line 1, col 4`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			expr, err := ParseExpression(tt.src)
+			if err == nil {
+				t.Fatalf("parsed as %s, want a syntax error", expr.ToString())
+			}
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("err = %v (%T), want a *SyntaxError", err, err)
+			}
+			if syntaxErr.Line != tt.line || syntaxErr.Col != tt.col {
+				t.Errorf("error at line %d, col %d, want line %d, col %d (%v)", syntaxErr.Line, syntaxErr.Col, tt.line, tt.col, err)
+			}
+			if !strings.Contains(syntaxErr.Msg, tt.wantMsg) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestFoldConstants(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{src: "ACC001 > 12 * 100", want: "(ACC001 > 1200.00)"},
+		{src: "(1 + 2) * (10 - 4) / 3", want: "6.00"},
+		{src: "ACC001 + 1 + 2", want: "((ACC001 + 1.00) + 2.00)"},
+		{src: "1 < 2 AND 3 == 3", want: "TRUE"},
+		{src: "IF 1 > 2 THEN ACC001 ELSE 5", want: "(IF FALSE THEN ACC001 ELSE 5.00)"},
+		{src: "ACC001 / (2 - 2)", want: "(ACC001 / 0.00)"},
+		{src: "1 / 0", want: "(1.00 / 0.00)"},
+		{src: "TRUE + 1", want: "(TRUE + 1.00)"},
+		{src: "1 < 2 < 3", want: "(TRUE < 3.00)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			if got := FoldConstants(mustParse(t, tt.src)).ToString(); got != tt.want {
+				t.Errorf("folded to %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{src: "ACC001 * 1", want: "ACC001"},
+		{src: "1 * ACC001 / 1", want: "ACC001"},
+		{src: "ACC001 - 0 + 0", want: "ACC001"},
+		{src: "0 + ACC001", want: "ACC001"},
+		{src: "ACC001 * (3 - 2)", want: "ACC001"},
+		// x * 0 keeps x, which might be an unknown account
+		{src: "ACC001 * 0", want: "(ACC001 * 0.00)"},
+		// x + 0 is only dropped when x is statically a number
+		{src: "(ACC001 > 1) + 0", want: "((ACC001 > 1.00) + 0.00)"},
+		{src: "(IF ACC001 > 1 THEN 2 ELSE FALSE) + 0", want: "((IF (ACC001 > 1.00) THEN 2.00 ELSE FALSE) + 0.00)"},
+		{src: "(IF ACC001 > 1 THEN 2 ELSE ACC002) + 0", want: "(IF (ACC001 > 1.00) THEN 2.00 ELSE ACC002)"},
+		{src: "SUM(SAV*) + 0", want: "SUM(SAV*)"},
+		// FALSE AND x never evaluates x; x AND FALSE does
+		{src: "FALSE AND ACC001 > 1", want: "FALSE"},
+		{src: "FALSE AND ACC001", want: "FALSE"},
+		{src: "ACC001 > 1 AND FALSE", want: "((ACC001 > 1.00) AND FALSE)"},
+		{src: "TRUE AND ACC001 > 1", want: "(ACC001 > 1.00)"},
+		{src: "ACC001 > 1 AND TRUE", want: "(ACC001 > 1.00)"},
+		{src: "TRUE AND ACC001", want: "(TRUE AND ACC001)"},
+		{src: "TRUE OR ACC001 > 1", want: "TRUE"},
+		{src: "ACC001 > 1 OR TRUE", want: "((ACC001 > 1.00) OR TRUE)"},
+		{src: "ACC001 > 1 OR FALSE", want: "(ACC001 > 1.00)"},
+		{src: "NOT NOT ACC001 > 1", want: "(ACC001 > 1.00)"},
+		{src: "NOT NOT ACC001", want: "(NOT (NOT ACC001))"},
+		{src: "IF 1 < 2 THEN ACC001 * 1 ELSE 1 / 0", want: "ACC001"},
+		{src: "IF 1 > 2 THEN 1 / 0 ELSE ACC002", want: "ACC002"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			if got := Simplify(mustParse(t, tt.src)).ToString(); got != tt.want {
+				t.Errorf("simplified to %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimplifyKeepsFailuresFailing(t *testing.T) {
+	tests := []struct {
+		src    string
+		ctx    map[string]float64
+		wantIs error // sentinel to match; nil means a *TypeError with want's kinds
+		want   TypeError
+	}{
+		{src: "1 / 0", wantIs: ErrDivisionByZero},
+		{src: "ACC001 * 1 / (2 - 2)", ctx: map[string]float64{"ACC001": 5}, wantIs: ErrDivisionByZero},
+		{src: "MISSING * 0", wantIs: ErrUnknownAccount},
+		{src: "MISSING > 1 AND FALSE", wantIs: ErrUnknownAccount},
+		{src: "ACC001 * 1 > MISSING + 0", ctx: map[string]float64{"ACC001": 5}, wantIs: ErrUnknownAccount},
+		{src: "1 < 2 < 3", want: TypeError{Want: KindNumber, Got: KindBool}},
+		{src: "(1 < 2) + 0", want: TypeError{Want: KindNumber, Got: KindBool}},
+		{src: "TRUE AND 5 * 1", want: TypeError{Want: KindBool, Got: KindNumber}},
+		{src: "NOT NOT 1", want: TypeError{Want: KindBool, Got: KindNumber}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			expr := mustParse(t, tt.src)
+			simplified := Simplify(expr)
+			for name, e := range map[string]Expression{"original": expr, "simplified": simplified} {
+				v, err := e.Interpret(tt.ctx)
+				if err == nil {
+					t.Fatalf("%s %s = %v, want an error", name, e.ToString(), v)
+				}
+				if tt.wantIs != nil {
+					if !errors.Is(err, tt.wantIs) {
+						t.Errorf("%s %s: err = %v, want %v", name, e.ToString(), err, tt.wantIs)
+					}
+					continue
+				}
+				var typeErr *TypeError
+				if !errors.As(err, &typeErr) || typeErr.Want != tt.want.Want || typeErr.Got != tt.want.Got {
+					t.Errorf("%s %s: err = %v, want a type error expecting %s, got %s", name, e.ToString(), err, tt.want.Want, tt.want.Got)
+				}
+			}
+		})
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{src: "1 + 2", want: []string{}},
+		{src: "ACC002 + ACC001 > ACC002 * 2", want: []string{"ACC001", "ACC002"}},
+		{src: "IF LOAN01 > 0 THEN CHK001 ELSE LOAN01", want: []string{"CHK001", "LOAN01"}},
+		{src: "SUM(SAV*) + MAX(CHK001, SAV*) > AVG(*)", want: []string{"*", "CHK001", "SAV*"}},
+		{src: "COUNT(SAV*) > 0 AND SAV001 > 0", want: []string{"SAV*", "SAV001"}},
+		{src: scoringRule, want: []string{"CHK001", "CHK002", "LOAN01", "SAV001"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			if got := Dependencies(mustParse(t, tt.src)); !slices.Equal(got, tt.want) {
+				t.Errorf("Dependencies = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testEngine returns an engine with the given rules added in order
+func testEngine(t *testing.T, stopOnFirstMatch bool, rules ...Rule) *RuleEngine {
+	t.Helper()
+	engine := NewRuleEngine(stopOnFirstMatch)
+	for _, rule := range rules {
+		if err := engine.AddRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return engine
+}
+
+func firedNames(evaluation Evaluation) []string {
+	names := make([]string, len(evaluation.Fired))
+	for i, fired := range evaluation.Fired {
+		names[i] = fired.Rule
+	}
+	return names
+}
+
+func TestRuleEngineOrdersByPriority(t *testing.T) {
+	engine := testEngine(t, false,
+		Rule{Name: "low", Condition: mustParse(t, "CHK001 > 0"), Action: ActionFlag, Priority: 1},
+		Rule{Name: "high", Condition: mustParse(t, "CHK001 > 0"), Action: ActionNotify, Priority: 10},
+		Rule{Name: "tie-first", Condition: mustParse(t, "CHK001 > 0"), Action: ActionFlag, Priority: 5},
+		Rule{Name: "tie-second", Condition: mustParse(t, "CHK001 > 0"), Action: ActionFlag, Priority: 5},
+		Rule{Name: "tie-third", Condition: mustParse(t, "CHK001 > 0"), Action: ActionFlag, Priority: 5},
+	)
+	want := []string{"high", "tie-first", "tie-second", "tie-third", "low"}
+	if got := engine.Rules(); !slices.Equal(got, want) {
+		t.Errorf("Rules = %v, want %v", got, want)
+	}
+	evaluation := engine.Evaluate(Snapshot{ID: "s1", Balances: map[string]float64{"CHK001": 1}})
+	if got := firedNames(evaluation); !slices.Equal(got, want) {
+		t.Errorf("fired %v, want %v", got, want)
+	}
+	if evaluation.SnapshotID != "s1" {
+		t.Errorf("SnapshotID = %q, want s1", evaluation.SnapshotID)
+	}
+	if got := evaluation.Fired[0]; got != (FiredRule{Rule: "high", Action: ActionNotify, Priority: 10}) {
+		t.Errorf("Fired[0] = %+v, want high/notify/10", got)
+	}
+}
+
+func TestRuleEngineStopOnFirstMatch(t *testing.T) {
+	rules := []Rule{
+		{Name: "overdrawn", Condition: mustParse(t, "CHK001 < 0"), Action: ActionBlock, Priority: 100},
+		{Name: "large", Condition: mustParse(t, "CHK001 > 1000"), Action: ActionNotify, Priority: 50},
+		{Name: "any", Condition: mustParse(t, "CHK001 > 0"), Action: ActionFlag, Priority: 1},
+	}
+	tests := []struct {
+		name    string
+		stop    bool
+		balance float64
+		want    []string
+	}{
+		{name: "all matches", stop: false, balance: 5000, want: []string{"large", "any"}},
+		{name: "first match only", stop: true, balance: 5000, want: []string{"large"}},
+		{name: "skips non-matching rules first", stop: true, balance: 5, want: []string{"any"}},
+		{name: "nothing fires", stop: true, balance: 0, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := testEngine(t, tt.stop, rules...)
+			evaluation := engine.Evaluate(Snapshot{Balances: map[string]float64{"CHK001": tt.balance}})
+			if got := firedNames(evaluation); !slices.Equal(got, tt.want) {
+				t.Errorf("fired %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleEngineBlocked(t *testing.T) {
+	engine := testEngine(t, false,
+		Rule{Name: "overdrawn", Condition: mustParse(t, "CHK001 < 0"), Action: ActionBlock, Priority: 10},
+		Rule{Name: "negative", Condition: mustParse(t, "CHK001 < 0"), Action: ActionFlag, Priority: 1},
+		Rule{Name: "large", Condition: mustParse(t, "CHK001 > 1000"), Action: ActionNotify, Priority: 1},
+	)
+	tests := []struct {
+		balance float64
+		blocked bool
+	}{
+		{balance: -5, blocked: true},
+		{balance: 5000, blocked: false},
+		{balance: 5, blocked: false},
+	}
+	for _, tt := range tests {
+		evaluation := engine.Evaluate(Snapshot{Balances: map[string]float64{"CHK001": tt.balance}})
+		if evaluation.Blocked != tt.blocked {
+			t.Errorf("CHK001=%v: Blocked = %v, want %v (fired %v)", tt.balance, evaluation.Blocked, tt.blocked, firedNames(evaluation))
+		}
+	}
+}
+
+func TestRuleEngineErrorsDoNotStopOtherRules(t *testing.T) {
+	engine := testEngine(t, false,
+		Rule{Name: "needs-loan", Condition: mustParse(t, "LOAN01 > 0"), Action: ActionBlock, Priority: 30},
+		Rule{Name: "ratio", Condition: mustParse(t, "CHK001 / SAV001 > 1"), Action: ActionFlag, Priority: 20},
+		Rule{Name: "branchy", Condition: mustParse(t, "IF CHK001 > 0 THEN 1 ELSE FALSE"), Action: ActionFlag, Priority: 15},
+		Rule{Name: "positive", Condition: mustParse(t, "CHK001 > 0"), Action: ActionNotify, Priority: 10},
+	)
+	evaluation := engine.Evaluate(Snapshot{Balances: map[string]float64{"CHK001": 10, "SAV001": 0}})
+	if got := firedNames(evaluation); !slices.Equal(got, []string{"positive"}) {
+		t.Errorf("fired %v, want [positive]", got)
+	}
+	if evaluation.Blocked {
+		t.Error("Blocked = true, but the blocking rule failed rather than fired")
+	}
+	if len(evaluation.Errors) != 3 {
+		t.Fatalf("errors = %v, want one per failing rule", evaluation.Errors)
+	}
+	if err := evaluation.Errors[0]; !errors.Is(err, ErrUnknownAccount) || !strings.Contains(err.Error(), `rule "needs-loan"`) {
+		t.Errorf("errors[0] = %v, want needs-loan's unknown account", err)
+	}
+	if err := evaluation.Errors[1]; !errors.Is(err, ErrDivisionByZero) || !strings.Contains(err.Error(), `rule "ratio"`) {
+		t.Errorf("errors[1] = %v, want ratio's division by zero", err)
+	}
+	var typeErr *TypeError
+	if err := evaluation.Errors[2]; !errors.As(err, &typeErr) || typeErr.Got != KindNumber || !strings.Contains(err.Error(), `rule "branchy"`) {
+		t.Errorf("errors[2] = %v, want branchy's number condition", err)
+	}
+}
+
+func TestRuleEngineFiredCounts(t *testing.T) {
+	engine := testEngine(t, true,
+		Rule{Name: "overdrawn", Condition: mustParse(t, "CHK001 < 0"), Action: ActionBlock, Priority: 10},
+		Rule{Name: "negative", Condition: mustParse(t, "CHK001 < 0"), Action: ActionFlag, Priority: 5},
+		Rule{Name: "large", Condition: mustParse(t, "CHK001 > 1000"), Action: ActionNotify, Priority: 1},
+		Rule{Name: "missing", Condition: mustParse(t, "NONE01 > 0"), Action: ActionFlag, Priority: 0},
+	)
+	for _, balance := range []float64{-1, -2, 5000, 10, -3} {
+		engine.Evaluate(Snapshot{Balances: map[string]float64{"CHK001": balance}})
+	}
+	want := map[string]int{"overdrawn": 3, "negative": 0, "large": 1, "missing": 0}
+	if got := engine.FiredCounts(); !maps.Equal(got, want) {
+		t.Errorf("FiredCounts = %v, want %v", got, want)
+	}
+}
+
+func TestRuleEngineRun(t *testing.T) {
+	engine := testEngine(t, false,
+		Rule{Name: "overdrawn", Condition: mustParse(t, "CHK001 < 0"), Action: ActionBlock, Priority: 10},
+	)
+	snapshots := make(chan Snapshot)
+	go func() {
+		defer close(snapshots)
+		for i, balance := range []float64{-1, 5, -2} {
+			snapshots <- Snapshot{ID: strconv.Itoa(i), Balances: map[string]float64{"CHK001": balance}}
+		}
+	}()
+	var blocked []string
+	for evaluation := range engine.Run(snapshots) {
+		if evaluation.Blocked {
+			blocked = append(blocked, evaluation.SnapshotID)
+		}
+	}
+	if !slices.Equal(blocked, []string{"0", "2"}) {
+		t.Errorf("blocked snapshots = %v, want [0 2]", blocked)
+	}
+	if got := engine.FiredCounts()["overdrawn"]; got != 2 {
+		t.Errorf("overdrawn fired %d times, want 2", got)
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	ctx := map[string]float64{"SAV001": 10, "SAV002": 20, "CHK001": -5}
+	tests := []struct {
+		src      string
+		want     Value
+		wantIs   error  // sentinel to match, if evaluation should fail
+		wantExpr string // the sub-expression the error names
+	}{
+		{src: "SUM(SAV*)", want: NumberValue(30)},
+		{src: "SUM(SAV*, CHK001, 100)", want: NumberValue(125)},
+		{src: "SUM()", want: NumberValue(0)},
+		{src: "MIN(SAV*, CHK001)", want: NumberValue(-5)},
+		{src: "MAX(SAV*, CHK001 * -10)", want: NumberValue(50)},
+		{src: "AVG(SAV*)", want: NumberValue(15)},
+		{src: "AVG(SAV001, 5)", want: NumberValue(7.5)},
+		{src: "COUNT(*)", want: NumberValue(3)},
+		{src: "COUNT()", want: NumberValue(0)},
+		{src: "ABS(CHK001)", want: NumberValue(5)},
+		{src: "abs(CHK001 - SAV002)", want: NumberValue(25)},
+		{src: "SUM(SAV*) > 2 * MAX(CHK001, SAV001)", want: BoolValue(true)},
+		{src: "MIN()", wantIs: ErrArity, wantExpr: "MIN()"},
+		{src: "MAX()", wantIs: ErrArity, wantExpr: "MAX()"},
+		{src: "AVG()", wantIs: ErrArity, wantExpr: "AVG()"},
+		{src: "ABS()", wantIs: ErrArity, wantExpr: "ABS()"},
+		{src: "ABS(SAV*)", wantIs: ErrArity, wantExpr: "ABS(SAV*)"},
+		{src: "SUM(LOAN*)", wantIs: ErrUnknownAccount, wantExpr: "LOAN*"},
+		{src: "COUNT(LOAN*) == 0", wantIs: ErrUnknownAccount, wantExpr: "LOAN*"},
+		{src: "SUM(SAV*, LOAN*)", wantIs: ErrUnknownAccount, wantExpr: "LOAN*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			got, err := mustParse(t, tt.src).Interpret(ctx)
+			if tt.wantIs == nil {
+				if err != nil || got != tt.want {
+					t.Errorf("= %v, %v; want %v", got, err, tt.want)
+				}
+				return
+			}
+			var evalErr *EvalError
+			if !errors.Is(err, tt.wantIs) || !errors.As(err, &evalErr) {
+				t.Fatalf("err = %v, want an EvalError wrapping %v", err, tt.wantIs)
+			}
+			if evalErr.Expr != tt.wantExpr {
+				t.Errorf("error names %s, want %s", evalErr.Expr, tt.wantExpr)
+			}
+		})
+	}
+}
+
+func TestFunctionRegistryNames(t *testing.T) {
+	registry := NewFunctionRegistry()
+	reserve := func(args []float64) (float64, error) { return args[0] * 0.9, nil }
+	if err := registry.Register("Reserve", reserve); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"RESERVE", "reserve", "ReSeRvE"} {
+		if _, ok := registry.Lookup(name); !ok {
+			t.Errorf("Lookup(%q) found nothing", name)
+		}
+	}
+	for _, name := range []string{"RESERVE", "reserve", "sum", "Max"} {
+		if err := registry.Register(name, reserve); err == nil || !strings.Contains(err.Error(), "already registered") {
+			t.Errorf("Register(%q) = %v, want an already registered error", name, err)
+		}
+	}
+	sum, _ := registry.Lookup("SUM")
+	if got, err := sum([]float64{1, 2}); err != nil || got != 3 {
+		t.Errorf("SUM after a rejected re-registration = %v, %v; want the built-in's 3", got, err)
+	}
+	if _, ok := DefaultFunctions.Lookup("RESERVE"); ok {
+		t.Error("registering on one registry changed DefaultFunctions")
+	}
+
+	expr, err := registry.Parse("reserve(1000) + Sum(SAV*)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := expr.ToString(), "(RESERVE(1000.00) + SUM(SAV*))"; got != want {
+		t.Errorf("parsed as %s, want %s", got, want)
+	}
+	if _, err := ParseExpression("RESERVE(1000)"); err == nil || !strings.Contains(err.Error(), `unknown function "RESERVE"`) {
+		t.Errorf("ParseExpression with another registry's function: err = %v, want unknown function", err)
+	}
+}
+
+func TestSelectorOrderIsDeterministic(t *testing.T) {
+	// DIGITS reads its arguments as the digits of one number, so it exposes their order
+	registry := NewFunctionRegistry()
+	err := registry.Register("DIGITS", func(args []float64) (float64, error) {
+		n := 0.0
+		for _, a := range args {
+			n = n*10 + a
+		}
+		return n, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expr, err := registry.Parse("DIGITS(SAV*, 9)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	program := Compile(expr)
+	ctx := map[string]float64{"SAV010": 4, "SAV002": 2, "SAV003": 3, "SAV001": 1, "CHK001": 7}
+
+	wantIDs := []string{"SAV001", "SAV002", "SAV003", "SAV010"}
+	for range 20 {
+		ids, err := (&AccountSelector{prefix: "SAV"}).Matches(ctx)
+		if err != nil || !slices.Equal(ids, wantIDs) {
+			t.Fatalf("Matches = %v, %v; want %v", ids, err, wantIDs)
+		}
+		if got, err := expr.Interpret(ctx); err != nil || got != NumberValue(12349) {
+			t.Fatalf("Interpret = %v, %v; want 12349", got, err)
+		}
+		if got, err := program.Eval(ctx); err != nil || got != NumberValue(12349) {
+			t.Fatalf("compiled = %v, %v; want 12349", got, err)
+		}
+	}
+}
+
+func TestEvalErrorsNameTheFailingSubExpression(t *testing.T) {
+	tests := []struct {
+		src      string
+		ctx      map[string]float64
+		wantIs   error
+		wantExpr string
+	}{
+		{src: "CHK001 + SAV001 / ZERO", ctx: map[string]float64{"CHK001": 1, "SAV001": 2, "ZERO": 0}, wantIs: ErrDivisionByZero, wantExpr: "(SAV001 / ZERO)"},
+		{src: "1 + (CHK001 % 0) * 2", ctx: map[string]float64{"CHK001": 1}, wantIs: ErrDivisionByZero, wantExpr: "(CHK001 % 0.00)"},
+		{src: "(CHK001 + TYPO01) * 2 > 5", ctx: map[string]float64{"CHK001": 1}, wantIs: ErrUnknownAccount, wantExpr: "TYPO01"},
+		{src: "IF CHK001 > 0 THEN MISSING ELSE 0", ctx: map[string]float64{"CHK001": 1}, wantIs: ErrUnknownAccount, wantExpr: "MISSING"},
+		{src: "1 + BIG * BIG", ctx: map[string]float64{"BIG": 1e200}, wantIs: ErrNotFinite, wantExpr: "(BIG * BIG)"},
+		{src: "(BIG * -BIG) < 0", ctx: map[string]float64{"BIG": 1e200}, wantIs: ErrNotFinite, wantExpr: "(BIG * (0.00 - BIG))"},
+		{src: "BIG / TINY + 1", ctx: map[string]float64{"BIG": 1e300, "TINY": 1e-300}, wantIs: ErrNotFinite, wantExpr: "(BIG / TINY)"},
+		{src: "CHK001 + 1", ctx: map[string]float64{"CHK001": math.NaN()}, wantIs: ErrNotFinite, wantExpr: "CHK001"},
+		{src: "CHK001 - 1 > 0", ctx: map[string]float64{"CHK001": math.Inf(-1)}, wantIs: ErrNotFinite, wantExpr: "CHK001"},
+		{src: "SUM(SAV*) > 0", ctx: map[string]float64{"SAV001": math.Inf(1)}, wantIs: ErrNotFinite, wantExpr: "SAV*"},
+		{src: "SUM(SAV*) > 0", ctx: map[string]float64{"SAV001": math.MaxFloat64, "SAV002": math.MaxFloat64}, wantIs: ErrNotFinite, wantExpr: "SUM(SAV*)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			expr := mustParse(t, tt.src)
+			v, err := expr.Interpret(tt.ctx)
+			if err == nil {
+				t.Fatalf("= %v, want an error", v)
+			}
+			if !errors.Is(err, tt.wantIs) {
+				t.Errorf("err = %v, want %v", err, tt.wantIs)
+			}
+			var evalErr *EvalError
+			if !errors.As(err, &evalErr) {
+				t.Fatalf("err = %v (%T), want an *EvalError", err, err)
+			}
+			if evalErr.Expr != tt.wantExpr {
+				t.Errorf("error names %s, want %s (root is %s)", evalErr.Expr, tt.wantExpr, expr.ToString())
+			}
+			if want := "evaluating " + tt.wantExpr + ": " + tt.wantIs.Error(); err.Error() != want {
+				t.Errorf("message = %q, want %q", err.Error(), want)
+			}
+			if _, compiledErr := Compile(expr).Eval(tt.ctx); errText(compiledErr) != err.Error() {
+				t.Errorf("compiled err = %v, want %v", compiledErr, err)
+			}
+		})
+	}
+}
+
+func TestOperatorResults(t *testing.T) {
+	ctx := map[string]float64{"CHK001": 7, "SAV001": 2}
+	tests := []struct {
+		src  string
+		want Value
+	}{
+		{src: "CHK001 / SAV001", want: NumberValue(3.5)},
+		{src: "-CHK001 / 4", want: NumberValue(-1.75)},
+		{src: "CHK001 % 3", want: NumberValue(1)},
+		{src: "-CHK001 % 3", want: NumberValue(-1)},
+		{src: "CHK001 % -3", want: NumberValue(1)},
+		{src: "7.5 % SAV001", want: NumberValue(1.5)},
+		{src: "SAV001 < CHK001", want: BoolValue(true)},
+		{src: "CHK001 < SAV001", want: BoolValue(false)},
+		{src: "CHK001 < 7", want: BoolValue(false)},
+		{src: "CHK001 == 7", want: BoolValue(true)},
+		{src: "CHK001 == SAV001", want: BoolValue(false)},
+		{src: "TRUE == (CHK001 > 1)", want: BoolValue(true)},
+		{src: "FALSE == (CHK001 > 1)", want: BoolValue(false)},
+		{src: "CHK001 > 1 AND SAV001 > 1", want: BoolValue(true)},
+		{src: "CHK001 > 1 AND SAV001 > 5", want: BoolValue(false)},
+		{src: "FALSE AND MISSING > 0", want: BoolValue(false)},
+		{src: "CHK001 > 10 OR SAV001 > 1", want: BoolValue(true)},
+		{src: "CHK001 > 10 OR SAV001 > 5", want: BoolValue(false)},
+		{src: "TRUE OR MISSING > 0", want: BoolValue(true)},
+		{src: "NOT CHK001 > 10", want: BoolValue(true)},
+		{src: "NOT TRUE", want: BoolValue(false)},
+		{src: "IF CHK001 > SAV001 THEN CHK001 ELSE SAV001", want: NumberValue(7)},
+		{src: "IF CHK001 < SAV001 THEN CHK001 ELSE SAV001", want: NumberValue(2)},
+		{src: "IF TRUE THEN FALSE ELSE 1", want: BoolValue(false)},
+		{src: "IF FALSE THEN CHK001 / 0 ELSE SAV001 == 2", want: BoolValue(true)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			got, err := mustParse(t, tt.src).Interpret(ctx)
+			if err != nil || got != tt.want {
+				t.Errorf("= %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypeErrors(t *testing.T) {
+	tests := []struct {
+		src      string
+		wantExpr string
+		want     Kind
+		got      Kind
+	}{
+		{src: "CHK001 + TRUE", wantExpr: "(CHK001 + TRUE)", want: KindNumber, got: KindBool},
+		{src: "(CHK001 > 1) * 2", wantExpr: "((CHK001 > 1.00) * 2.00)", want: KindNumber, got: KindBool},
+		{src: "CHK001 / FALSE", wantExpr: "(CHK001 / FALSE)", want: KindNumber, got: KindBool},
+		{src: "TRUE % 2", wantExpr: "(TRUE % 2.00)", want: KindNumber, got: KindBool},
+		{src: "TRUE < CHK001", wantExpr: "(TRUE < CHK001)", want: KindNumber, got: KindBool},
+		{src: "CHK001 == TRUE", wantExpr: "(CHK001 == TRUE)", want: KindNumber, got: KindBool},
+		{src: "TRUE == CHK001", wantExpr: "(TRUE == CHK001)", want: KindBool, got: KindNumber},
+		{src: "CHK001 AND TRUE", wantExpr: "(CHK001 AND TRUE)", want: KindBool, got: KindNumber},
+		{src: "FALSE OR CHK001", wantExpr: "(FALSE OR CHK001)", want: KindBool, got: KindNumber},
+		{src: "NOT CHK001", wantExpr: "(NOT CHK001)", want: KindBool, got: KindNumber},
+		{src: "IF CHK001 THEN 1 ELSE 2", wantExpr: "(IF CHK001 THEN 1.00 ELSE 2.00)", want: KindBool, got: KindNumber},
+		{src: "1 + (IF CHK001 > 0 THEN TRUE ELSE 2)", wantExpr: "(1.00 + (IF (CHK001 > 0.00) THEN TRUE ELSE 2.00))", want: KindNumber, got: KindBool},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			v, err := mustParse(t, tt.src).Interpret(map[string]float64{"CHK001": 7})
+			var typeErr *TypeError
+			if !errors.As(err, &typeErr) {
+				t.Fatalf("= %v, %v; want a *TypeError", v, err)
+			}
+			if typeErr.Expr != tt.wantExpr || typeErr.Want != tt.want || typeErr.Got != tt.got {
+				t.Errorf("TypeError{%s, want %s, got %s}, want {%s, want %s, got %s}",
+					typeErr.Expr, typeErr.Want, typeErr.Got, tt.wantExpr, tt.want, tt.got)
+			}
+			if want := "type error in " + tt.wantExpr + ": expected " + tt.want.String() + ", got " + tt.got.String(); err.Error() != want {
+				t.Errorf("message = %q, want %q", err.Error(), want)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"iter"
+	"slices"
+)
 
 // Transaction represents a banking transaction
 type Transaction struct {
@@ -17,10 +21,12 @@ type Iterator interface {
 	Reset()
 }
 
-// Collection interface declares method to create iterator
+// Collection interface declares method to create iterator, plus range-over-func views
 type Collection interface {
 	CreateIterator() Iterator
 	Add(transaction *Transaction)
+	All() iter.Seq2[int, *Transaction]
+	Values() iter.Seq[*Transaction]
 }
 
 // --- Array-based Transaction History ---
@@ -41,6 +47,28 @@ func (h *ArrayTransactionHistory) CreateIterator() Iterator {
 	return &ArrayIterator{history: h, index: 0}
 }
 
+// All yields each transaction with its position, for range-over-func loops
+func (h *ArrayTransactionHistory) All() iter.Seq2[int, *Transaction] {
+	return func(yield func(int, *Transaction) bool) {
+		for i, transaction := range h.transactions {
+			if !yield(i, transaction) {
+				return
+			}
+		}
+	}
+}
+
+// Values yields each transaction in order
+func (h *ArrayTransactionHistory) Values() iter.Seq[*Transaction] {
+	return func(yield func(*Transaction) bool) {
+		for _, transaction := range h.transactions {
+			if !yield(transaction) {
+				return
+			}
+		}
+	}
+}
+
 type ArrayIterator struct {
 	history *ArrayTransactionHistory
 	index   int
@@ -96,6 +124,30 @@ func (h *LinkedListTransactionHistory) CreateIterator() Iterator {
 	return &LinkedListIterator{current: h.head, head: h.head}
 }
 
+// All yields each transaction with its position, for range-over-func loops
+func (h *LinkedListTransactionHistory) All() iter.Seq2[int, *Transaction] {
+	return func(yield func(int, *Transaction) bool) {
+		i := 0
+		for node := h.head; node != nil; node = node.next {
+			if !yield(i, node.transaction) {
+				return
+			}
+			i++
+		}
+	}
+}
+
+// Values yields each transaction in order
+func (h *LinkedListTransactionHistory) Values() iter.Seq[*Transaction] {
+	return func(yield func(*Transaction) bool) {
+		for node := h.head; node != nil; node = node.next {
+			if !yield(node.transaction) {
+				return
+			}
+		}
+	}
+}
+
 type LinkedListIterator struct {
 	current *TransactionNode
 	head    *TransactionNode
@@ -118,6 +170,78 @@ func (i *LinkedListIterator) Reset() {
 	i.current = i.head
 }
 
+// --- Adapters between Iterator and iter.Seq ---
+
+// IteratorSeq turns a classic Iterator into a sequence. It yields the remaining
+// transactions from the iterator's current position and advances the iterator.
+func IteratorSeq(it Iterator) iter.Seq[*Transaction] {
+	return func(yield func(*Transaction) bool) {
+		for it.HasNext() {
+			if !yield(it.Next()) {
+				return
+			}
+		}
+	}
+}
+
+// IteratorSeq2 is IteratorSeq with positions, counted from 0 at the iterator's
+// current position, like a collection's All
+func IteratorSeq2(it Iterator) iter.Seq2[int, *Transaction] {
+	return func(yield func(int, *Transaction) bool) {
+		for i := 0; it.HasNext(); i++ {
+			if !yield(i, it.Next()) {
+				return
+			}
+		}
+	}
+}
+
+// PullIterator exposes a sequence through the classic Iterator interface, using
+// iter.Pull. Reset starts the sequence again, so it must be re-iterable like the
+// histories' Values. Call Stop when abandoning an unfinished iterator.
+type PullIterator struct {
+	seq    iter.Seq[*Transaction]
+	next   func() (*Transaction, bool)
+	stop   func()
+	peeked *Transaction
+	ready  bool // peeked holds the next transaction
+	done   bool
+}
+
+func NewPullIterator(seq iter.Seq[*Transaction]) *PullIterator {
+	it := &PullIterator{seq: seq}
+	it.next, it.stop = iter.Pull(seq)
+	return it
+}
+
+func (i *PullIterator) HasNext() bool {
+	if !i.ready && !i.done {
+		i.peeked, i.ready = i.next()
+		i.done = !i.ready
+	}
+	return i.ready
+}
+
+func (i *PullIterator) Next() *Transaction {
+	if !i.HasNext() {
+		return nil
+	}
+	i.ready = false
+	return i.peeked
+}
+
+func (i *PullIterator) Reset() {
+	i.stop()
+	i.next, i.stop = iter.Pull(i.seq)
+	i.peeked, i.ready, i.done = nil, false, false
+}
+
+// Stop releases the sequence; HasNext reports false afterwards
+func (i *PullIterator) Stop() {
+	i.stop()
+	i.peeked, i.ready, i.done = nil, false, true
+}
+
 // Helper function to print transaction history
 func printTransactionHistory(collection Collection, name string) {
 	fmt.Printf("\n%s:\n", name)
@@ -165,8 +289,58 @@ func main() {
 		fmt.Printf("  - %s: $%.2f\n", txn.ID, txn.Amount)
 	}
 
+	// Example 3: Range-over-func views of the same histories
+	fmt.Println("\n--- Example 3: Range-over-func Iterators ---")
+	for i, txn := range arrayHistory.All() {
+		fmt.Printf("  %d. %s: $%.2f - %s\n", i+1, txn.ID, txn.Amount, txn.Description)
+	}
+
+	// Breaking out of the loop stops the traversal early
+	fmt.Println("\nFirst transfer in the linked list:")
+	for _, txn := range linkedHistory.All() {
+		if txn.Type == "transfer" {
+			fmt.Printf("  %s: $%.2f - %s\n", txn.ID, txn.Amount, txn.Description)
+			break
+		}
+	}
+
+	// Sequences work with the standard library, e.g. slices.Collect
+	total := 0.0
+	for txn := range linkedHistory.Values() {
+		total += txn.Amount
+	}
+	fmt.Printf("\nLinked list total: $%.2f across %d transactions\n", total, len(slices.Collect(linkedHistory.Values())))
+
+	// Example 4: Adapters between the classic Iterator and sequences
+	fmt.Println("\n--- Example 4: Iterator Adapters ---")
+	classic := arrayHistory.CreateIterator()
+	classic.Next() // already consumed TXN001
+	fmt.Println("Rest of a classic iterator, ranged over:")
+	for txn := range IteratorSeq(classic) {
+		fmt.Printf("  - %s: $%.2f\n", txn.ID, txn.Amount)
+	}
+
+	// Any Collection offers the same views, so callers needn't know which history they hold
+	fmt.Println("\nNumbered through the Collection interface:")
+	for _, collection := range []Collection{arrayHistory, linkedHistory} {
+		for i, txn := range IteratorSeq2(collection.CreateIterator()) {
+			fmt.Printf("  %d. %s: $%.2f\n", i+1, txn.ID, txn.Amount)
+		}
+	}
+
+	pulled := NewPullIterator(linkedHistory.Values())
+	defer pulled.Stop()
+	fmt.Println("\nSequence driven through HasNext/Next, then Reset:")
+	fmt.Printf("  - %s\n", pulled.Next().ID)
+	pulled.Reset()
+	for pulled.HasNext() {
+		txn := pulled.Next()
+		fmt.Printf("  - %s: $%.2f\n", txn.ID, txn.Amount)
+	}
+
 	fmt.Println("\n✓ Iterator provides uniform way to traverse transaction collections")
 	fmt.Println("✓ Hides internal structure of collections")
 	fmt.Println("✓ Supports multiple simultaneous traversals")
+	fmt.Println("✓ iter.Seq views and adapters bridge the classic interface and range-over-func")
 	fmt.Println("✓ JoshBank can iterate through transactions regardless of storage implementation")
 }
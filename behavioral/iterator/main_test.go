@@ -0,0 +1,105 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func testCollections() map[string]Collection {
+	collections := map[string]Collection{
+		"array":       NewArrayTransactionHistory(),
+		"linked list": NewLinkedListTransactionHistory(),
+	}
+	for _, collection := range collections {
+		collection.Add(&Transaction{ID: "TXN001", Amount: 100.0})
+		collection.Add(&Transaction{ID: "TXN002", Amount: 50.0})
+		collection.Add(&Transaction{ID: "TXN003", Amount: 250.0})
+	}
+	return collections
+}
+
+func ids(transactions []*Transaction) []string {
+	out := make([]string, len(transactions))
+	for i, txn := range transactions {
+		out[i] = txn.ID
+	}
+	return out
+}
+
+var wantIDs = []string{"TXN001", "TXN002", "TXN003"}
+
+func TestCollectionViewsAgree(t *testing.T) {
+	for name, collection := range testCollections() {
+		t.Run(name, func(t *testing.T) {
+			var positions []int
+			var all []*Transaction
+			for i, txn := range collection.All() {
+				positions = append(positions, i)
+				all = append(all, txn)
+			}
+			if !slices.Equal(positions, []int{0, 1, 2}) || !slices.Equal(ids(all), wantIDs) {
+				t.Errorf("All = %v %v, want [0 1 2] %v", positions, ids(all), wantIDs)
+			}
+			if got := ids(slices.Collect(collection.Values())); !slices.Equal(got, wantIDs) {
+				t.Errorf("Values = %v, want %v", got, wantIDs)
+			}
+			if got := ids(slices.Collect(IteratorSeq(collection.CreateIterator()))); !slices.Equal(got, wantIDs) {
+				t.Errorf("IteratorSeq = %v, want %v", got, wantIDs)
+			}
+		})
+	}
+}
+
+func TestIteratorSeq2(t *testing.T) {
+	for name, collection := range testCollections() {
+		t.Run(name, func(t *testing.T) {
+			it := collection.CreateIterator()
+			it.Next()
+
+			// Positions count from where the iterator was, not from the collection's start
+			var positions []int
+			var rest []*Transaction
+			for i, txn := range IteratorSeq2(it) {
+				positions = append(positions, i)
+				rest = append(rest, txn)
+			}
+			if !slices.Equal(positions, []int{0, 1}) || !slices.Equal(ids(rest), wantIDs[1:]) {
+				t.Errorf("IteratorSeq2 = %v %v, want [0 1] %v", positions, ids(rest), wantIDs[1:])
+			}
+			if it.HasNext() {
+				t.Error("iterator not exhausted after ranging to the end")
+			}
+
+			// Breaking out leaves the iterator just past the last yielded transaction
+			it.Reset()
+			for i := range IteratorSeq2(it) {
+				if i == 0 {
+					break
+				}
+			}
+			if next := it.Next(); next == nil || next.ID != "TXN002" {
+				t.Errorf("after break, Next = %v, want TXN002", next)
+			}
+		})
+	}
+}
+
+func TestPullIteratorReset(t *testing.T) {
+	for name, collection := range testCollections() {
+		t.Run(name, func(t *testing.T) {
+			pulled := NewPullIterator(collection.Values())
+			defer pulled.Stop()
+			if first := pulled.Next(); first == nil || first.ID != "TXN001" {
+				t.Fatalf("Next = %v, want TXN001", first)
+			}
+			pulled.Reset()
+			if got := ids(slices.Collect(IteratorSeq(pulled))); !slices.Equal(got, wantIDs) {
+				t.Errorf("after Reset = %v, want %v", got, wantIDs)
+			}
+			pulled.Stop()
+			if pulled.HasNext() {
+				t.Error("HasNext after Stop")
+			}
+		})
+	}
+}